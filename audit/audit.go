@@ -0,0 +1,128 @@
+// Package audit describes the immutable record of changes made to users.
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+
+	// MaxActorLength bounds the self-declared actor names that are stored.
+	MaxActorLength = 128
+)
+
+type Operation string
+
+const (
+	Create  Operation = "create"
+	Update  Operation = "update"
+	Delete  Operation = "delete"
+	Restore Operation = "restore"
+	Purge   Operation = "purge"
+)
+
+// Origin identifies who made a change and in which request.
+type Origin struct {
+	Actor     string
+	RequestID string
+}
+
+// System is the origin of changes the server makes by itself, such as
+// purging deleted users.
+var System = Origin{Actor: "system"}
+
+// Change holds the JSON values of a field before and after an operation.
+// Before is absent for fields that were added, After for fields that were
+// removed.
+type Change struct {
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+type Event struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"userId"`
+	Operation Operation `json:"operation"`
+	Actor     string    `json:"actor"`
+	RequestID string    `json:"requestId,omitempty"`
+	At        time.Time `json:"at"`
+	// Changes maps the JSON name of every field the operation changed to
+	// its values before and after.
+	Changes map[string]Change `json:"changes"`
+}
+
+// Query selects one page of events across all users, oldest first.
+type Query struct {
+	// Since keeps only the events at or after this time.
+	Since time.Time
+	// After is the ID of the last event of the previous page, or zero for
+	// the first page.
+	After int64
+	Limit int
+}
+
+// WithDefaults clamps the page size like user.ListOptions.WithDefaults.
+func (q Query) WithDefaults() Query {
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+	return q
+}
+
+type Page struct {
+	Events []Event
+	// Total counts every event since Query.Since, across all pages.
+	Total int64
+	// Next is the After value for the following page, or zero on the last
+	// page.
+	Next int64
+}
+
+// Diff compares the JSON forms of two values field by field. A nil before
+// or after stands for a value that did not exist, as for creations and
+// purges.
+func Diff(before, after interface{}) (map[string]Change, error) {
+	b, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]Change{}
+	for name, value := range b {
+		if other, ok := a[name]; !ok || !bytes.Equal(value, other) {
+			changes[name] = Change{Before: value, After: a[name]}
+		}
+	}
+	for name, value := range a {
+		if _, ok := b[name]; !ok {
+			changes[name] = Change{After: value}
+		}
+	}
+	return changes, nil
+}
+
+func fields(v interface{}) (map[string]json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	doc, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type person struct {
+	Name  string  `json:"name"`
+	Desk  string  `json:"desk,omitempty"`
+	Score float64 `json:"score"`
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   interface{}
+		after    interface{}
+		expected string
+	}{
+		{
+			name:     "Created",
+			after:    person{Name: "Ada", Score: 1},
+			expected: `{"name":{"after":"Ada"},"score":{"after":1}}`,
+		},
+		{
+			name:     "Changed",
+			before:   person{Name: "Ada", Score: 1},
+			after:    person{Name: "Ada", Score: 2},
+			expected: `{"score":{"before":1,"after":2}}`,
+		},
+		{
+			name:     "Field added and removed",
+			before:   person{Name: "Ada", Desk: "4B"},
+			after:    person{Name: "Ada"},
+			expected: `{"desk":{"before":"4B"}}`,
+		},
+		{
+			name:     "Removed",
+			before:   &person{Name: "Ada"},
+			expected: `{"name":{"before":"Ada"},"score":{"before":0}}`,
+		},
+		{
+			name:     "Unchanged",
+			before:   person{Name: "Ada"},
+			after:    person{Name: "Ada"},
+			expected: `{}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := Diff(tt.before, tt.after)
+			assert.Nil(t, err)
+			doc, err := json.Marshal(changes)
+			assert.Nil(t, err)
+			assert.JSONEq(t, tt.expected, string(doc))
+		})
+	}
+}
+
+func TestQueryWithDefaults(t *testing.T) {
+	assert.Equal(t, DefaultLimit, Query{}.WithDefaults().Limit)
+	assert.Equal(t, MaxLimit, Query{Limit: MaxLimit + 1}.WithDefaults().Limit)
+	assert.Equal(t, 7, Query{Limit: 7}.WithDefaults().Limit)
+}
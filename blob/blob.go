@@ -0,0 +1,101 @@
+// Package blob stores opaque binary objects, such as profile photos, by key.
+package blob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pmaterer/peopler/domain"
+)
+
+// Store keeps blobs under slash-separated keys such as users/7/photo/small.
+// Implementations must be safe for concurrent use, and a reader must never
+// see a blob that is only partly written.
+type Store interface {
+	// Put stores everything read from data under key, replacing any blob
+	// already there.
+	Put(ctx context.Context, key string, data io.Reader) error
+	// Get opens the blob under key for reading. It fails with
+	// domain.ErrNotFound if there is none.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob under key. Deleting a missing blob is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+}
+
+// segmentPattern matches a single segment of a key.
+var segmentPattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// CheckKey rejects keys that are empty or have empty, dot-leading or
+// otherwise unusual segments, so that every store can map them to names
+// safely.
+func CheckKey(key string) error {
+	for _, segment := range strings.Split(key, "/") {
+		if !segmentPattern.MatchString(segment) {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+	return nil
+}
+
+// notFound reports a missing blob.
+func notFound(key string) error {
+	return fmt.Errorf("blob %q: %w", key, domain.ErrNotFound)
+}
+
+// MemoryStore keeps blobs in memory, for tests and for servers that persist
+// nothing.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	blobs map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		blobs: map[string][]byte{},
+	}
+}
+
+func (s *MemoryStore) Put(_ context.Context, key string, data io.Reader) error {
+	if err := CheckKey(key); err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[key] = b
+	return nil
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	if err := CheckKey(key); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.blobs[key]
+	if !ok {
+		return nil, notFound(key)
+	}
+	// Blobs are replaced, never modified, so readers can share b.
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, key string) error {
+	if err := CheckKey(key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blobs, key)
+	return nil
+}
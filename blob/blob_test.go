@@ -0,0 +1,56 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStores(t *testing.T) {
+	stores := []struct {
+		name  string
+		store func(t *testing.T) Store
+	}{
+		{"Memory", func(t *testing.T) Store { return NewMemoryStore() }},
+		{"File", func(t *testing.T) Store { return NewFileStore(t.TempDir()) }},
+	}
+
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := tt.store(t)
+
+			_, err := s.Get(ctx, "users/1/photo")
+			assert.True(t, errors.Is(err, domain.ErrNotFound))
+
+			assert.Nil(t, s.Put(ctx, "users/1/photo", strings.NewReader("first")))
+			assert.Nil(t, s.Put(ctx, "users/1/photo", strings.NewReader("second")))
+			assert.Equal(t, "second", read(t, s, "users/1/photo"))
+
+			assert.Nil(t, s.Delete(ctx, "users/1/photo"))
+			assert.Nil(t, s.Delete(ctx, "users/1/photo"))
+			_, err = s.Get(ctx, "users/1/photo")
+			assert.True(t, errors.Is(err, domain.ErrNotFound))
+
+			for _, key := range []string{"", "users//photo", "../photo", "users/.tmp", "users/1/photo/"} {
+				assert.NotNil(t, s.Put(ctx, key, strings.NewReader("bad")), key)
+			}
+		})
+	}
+}
+
+func read(t *testing.T, s Store, key string) string {
+	rc, err := s.Get(context.Background(), key)
+	if !assert.Nil(t, err) {
+		return ""
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	assert.Nil(t, err)
+	return string(b)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pmaterer/peopler/config"
+)
+
+const migrateUsage = "usage: peopler migrate up|down|status"
+
+func runMigrate(cnf config.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+
+	db, m, err := openMigrator(cnf.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		count, err := m.Up()
+		if err != nil {
+			return err
+		}
+		log.Printf("Applied %d migration(s)\n", count)
+	case "down":
+		rolledBack, err := m.Down()
+		if err != nil {
+			return err
+		}
+		if !rolledBack {
+			log.Println("No migrations to roll back")
+			return nil
+		}
+		log.Println("Rolled back 1 migration")
+	case "status":
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.Applied {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pmaterer/peopler/config"
-	"github.com/pmaterer/peopler/internal/sqlite"
+	groupcontroller "github.com/pmaterer/peopler/group/controller"
+	"github.com/pmaterer/peopler/internal/deadline"
+	"github.com/pmaterer/peopler/internal/problem"
 	"github.com/pmaterer/peopler/user/controller"
-	"github.com/pmaterer/peopler/user/repository"
-	"github.com/pmaterer/peopler/user/service"
 )
 
 func main() {
@@ -21,24 +25,108 @@ func main() {
 		},
 	}
 
-	db, err := sqlite.NewSQLiteHandler("./peopler.db")
+	flag.StringVar(&cnf.Database.Driver, "storage", envOrDefault("PEOPLER_STORAGE", config.DriverSQLite),
+		"storage backend: sqlite, postgres or memory")
+	flag.StringVar(&cnf.Database.DSN, "dsn", envOrDefault("PEOPLER_DSN", "./peopler.db"),
+		"SQLite database filename or PostgreSQL connection string")
+	flag.BoolVar(&cnf.Server.RequireIfMatch, "require-if-match", os.Getenv("PEOPLER_REQUIRE_IF_MATCH") == "true",
+		"reject PUT, PATCH and DELETE requests without an If-Match header")
+	flag.DurationVar(&cnf.Server.RequestTimeout, "request-timeout", envDuration("PEOPLER_REQUEST_TIMEOUT", 30*time.Second),
+		"cancel requests that take longer than this, or 0 to never time out")
+	flag.DurationVar(&cnf.Purge.Retention, "purge-after", envDuration("PEOPLER_PURGE_AFTER", 30*24*time.Hour),
+		"how long deleted users are kept before being purged, or 0 to keep them forever")
+	flag.DurationVar(&cnf.Purge.Interval, "purge-interval", envDuration("PEOPLER_PURGE_INTERVAL", time.Hour),
+		"how often to purge deleted users")
+	flag.StringVar(&cnf.Photos.Dir, "photo-dir", envOrDefault("PEOPLER_PHOTO_DIR", "./photos"),
+		"directory to store profile photos in")
+	flag.Parse()
+
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(cnf, flag.Args()[1:]); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+
+	userService, groupService, closeStorage, err := newServices(cnf.Database, cnf.Photos)
 	if err != nil {
-		log.Fatalf("failed to open database: %v", err)
+		log.Fatalf("failed to open storage: %v", err)
+	}
+	defer closeStorage()
+
+	if cnf.Purge.Retention > 0 {
+		if cnf.Purge.Interval <= 0 {
+			log.Fatalf("purge interval must be positive, got %s", cnf.Purge.Interval)
+		}
+		go userService.RunPurge(context.Background(), cnf.Purge.Retention, cnf.Purge.Interval)
 	}
 
-	userRepo := repository.NewRepository(db)
-	userService := service.NewService(userRepo)
 	userController := controller.NewController(userService)
+	userController.RequireIfMatch = cnf.Server.RequireIfMatch
+	groupController := groupcontroller.NewController(groupService)
 
 	router := mux.NewRouter()
 	router.HandleFunc("/user", userController.CreateUser()).Methods("POST")
 	router.HandleFunc("/users", userController.GetAllUsers()).Methods("GET")
+	router.HandleFunc("/users.csv", userController.ExportUsers()).Methods("GET")
+	router.HandleFunc("/users:batch", userController.Batch()).Methods("POST")
+	router.HandleFunc("/users/import", userController.ImportUsers()).Methods("POST")
+	router.HandleFunc("/users/search", userController.SearchUsers()).Methods("GET")
 	router.HandleFunc("/user/{id}", userController.GetUser()).Methods("GET")
 	router.HandleFunc("/user/{id}", userController.UpdateUser()).Methods("PUT")
+	router.HandleFunc("/user/{id}", userController.PatchUser()).Methods("PATCH")
 	router.HandleFunc("/user/{id}", userController.DeleteUser()).Methods("DELETE")
+	router.HandleFunc("/user/{id}/restore", userController.RestoreUser()).Methods("POST")
+	router.HandleFunc("/user/{id}/history", userController.GetUserHistory()).Methods("GET")
+	router.HandleFunc("/user/{id}/reports", userController.GetReports()).Methods("GET")
+	router.HandleFunc("/user/{id}/chain", userController.GetManagementChain()).Methods("GET")
+	router.HandleFunc("/orgchart", userController.GetOrgChart()).Methods("GET")
+	router.HandleFunc("/user/{id}/groups", groupController.GetUserGroups()).Methods("GET")
+	router.HandleFunc("/user/{id}/photo", userController.GetUserPhoto()).Methods("GET")
+	router.HandleFunc("/user/{id}/photo", userController.PutUserPhoto()).Methods("PUT")
+	router.HandleFunc("/user/{id}/photo", userController.DeleteUserPhoto()).Methods("DELETE")
+	router.HandleFunc("/user/{id}/tags", userController.GetUserTags()).Methods("GET")
+	router.HandleFunc("/user/{id}/tags/{tag}", userController.TagUser()).Methods("PUT")
+	router.HandleFunc("/user/{id}/tags/{tag}", userController.UntagUser()).Methods("DELETE")
+	router.HandleFunc("/tags", userController.GetTags()).Methods("GET")
+	router.HandleFunc("/audit", userController.GetAuditEvents()).Methods("GET")
+	router.HandleFunc("/attributes", userController.GetAttributeDefinitions()).Methods("GET")
+	router.HandleFunc("/attribute/{name}", userController.GetAttributeDefinition()).Methods("GET")
+	router.HandleFunc("/attribute/{name}", userController.PutAttributeDefinition()).Methods("PUT")
+	router.HandleFunc("/attribute/{name}", userController.DeleteAttributeDefinition()).Methods("DELETE")
+	router.HandleFunc("/group", groupController.CreateGroup()).Methods("POST")
+	router.HandleFunc("/groups", groupController.GetAllGroups()).Methods("GET")
+	router.HandleFunc("/group/{id}", groupController.GetGroup()).Methods("GET")
+	router.HandleFunc("/group/{id}", groupController.UpdateGroup()).Methods("PUT")
+	router.HandleFunc("/group/{id}", groupController.DeleteGroup()).Methods("DELETE")
+	router.HandleFunc("/group/{id}/members", groupController.GetMembers()).Methods("GET")
+	router.HandleFunc("/group/{id}/members/users/{memberId}", groupController.AddUser()).Methods("PUT")
+	router.HandleFunc("/group/{id}/members/users/{memberId}", groupController.RemoveUser()).Methods("DELETE")
+	router.HandleFunc("/group/{id}/members/groups/{memberId}", groupController.AddSubgroup()).Methods("PUT")
+	router.HandleFunc("/group/{id}/members/groups/{memberId}", groupController.RemoveSubgroup()).Methods("DELETE")
+	router.Use(problem.AssignRequestID, deadline.Middleware(cnf.Server.RequestTimeout))
 
 	log.Printf("Starting server on %s:%d\n", cnf.Server.ListenAddress, cnf.Server.ListenPort)
 	log.Fatal(http.ListenAndServe(
 		fmt.Sprintf("%s:%d", cnf.Server.ListenAddress, cnf.Server.ListenPort),
 		router))
 }
+
+func envOrDefault(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+	return d
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/pmaterer/peopler/blob"
+	"github.com/pmaterer/peopler/config"
+	grouprepository "github.com/pmaterer/peopler/group/repository"
+	groupmemory "github.com/pmaterer/peopler/group/repository/memory"
+	groupservice "github.com/pmaterer/peopler/group/service"
+	"github.com/pmaterer/peopler/internal/migrate"
+	"github.com/pmaterer/peopler/internal/postgres"
+	"github.com/pmaterer/peopler/internal/sqlite"
+	"github.com/pmaterer/peopler/user/repository"
+	"github.com/pmaterer/peopler/user/repository/memory"
+	"github.com/pmaterer/peopler/user/service"
+)
+
+// openDatabase connects to the configured database and applies pending
+// migrations.
+func openDatabase(cnf config.Database) (*sql.DB, error) {
+	switch cnf.Driver {
+	case config.DriverSQLite:
+		return sqlite.NewSQLiteHandler(cnf.DSN)
+	case config.DriverPostgres:
+		return postgres.NewPostgresHandler(cnf.DSN)
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", cnf.Driver)
+	}
+}
+
+// openMigrator connects to the configured database without migrating it.
+func openMigrator(cnf config.Database) (*sql.DB, *migrate.Migrator, error) {
+	var open func(string) (*sql.DB, error)
+	var newMigrator func(*sql.DB) (*migrate.Migrator, error)
+	switch cnf.Driver {
+	case config.DriverSQLite:
+		open, newMigrator = sqlite.Open, sqlite.NewMigrator
+	case config.DriverPostgres:
+		open, newMigrator = postgres.Open, postgres.NewMigrator
+	case config.DriverMemory:
+		return nil, nil, fmt.Errorf("the %s driver has no schema to migrate", cnf.Driver)
+	default:
+		return nil, nil, fmt.Errorf("unsupported database driver %q", cnf.Driver)
+	}
+
+	db, err := open(cnf.DSN)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := newMigrator(db)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return db, m, nil
+}
+
+// newServices wires the user and group services to the configured storage
+// backend. The returned close function releases the backend's resources.
+func newServices(cnf config.Database, photos config.Photos) (*service.Service, *groupservice.Service, func() error, error) {
+	if cnf.Driver == config.DriverMemory {
+		users := memory.NewRepository()
+		return service.NewService(users), groupservice.NewService(groupmemory.NewRepository(), users),
+			func() error { return nil }, nil
+	}
+
+	db, err := openDatabase(cnf)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var userService *service.Service
+	var groupService *groupservice.Service
+	if cnf.Driver == config.DriverPostgres {
+		users := repository.NewPostgresRepository(db)
+		userService, groupService = service.NewService(users), groupservice.NewService(grouprepository.NewPostgresRepository(db), users)
+	} else {
+		users := repository.NewRepository(db)
+		userService, groupService = service.NewService(users), groupservice.NewService(grouprepository.NewRepository(db), users)
+	}
+	userService.Photos = blob.NewFileStore(photos.Dir)
+	return userService, groupService, db.Close, nil
+}
@@ -1,10 +1,51 @@
 package config
 
+import "time"
+
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+	DriverMemory   = "memory"
+)
+
 type Config struct {
-	Server Server
+	Server   Server
+	Database Database
+	Purge    Purge
+	Photos   Photos
 }
 
 type Server struct {
 	ListenAddress string
 	ListenPort    int64
+	// RequireIfMatch makes updates and deletes of a user conditional, so
+	// clients cannot overwrite changes they have not seen.
+	RequireIfMatch bool
+	// RequestTimeout cancels requests, and the queries they run, that take
+	// longer. Zero disables it.
+	RequestTimeout time.Duration
+}
+
+type Database struct {
+	// Driver is one of DriverSQLite, DriverPostgres or DriverMemory.
+	Driver string
+	// DSN is the SQLite database filename or the PostgreSQL connection string.
+	// It is ignored by the memory driver.
+	DSN string
+}
+
+// Purge controls the job that permanently removes deleted users.
+type Purge struct {
+	// Retention is how long a deleted user can still be restored. Zero
+	// disables purging.
+	Retention time.Duration
+	// Interval is how often the job runs.
+	Interval time.Duration
+}
+
+// Photos controls where profile photos are stored.
+type Photos struct {
+	// Dir is the directory photos are kept in. It is ignored by the memory
+	// driver, which keeps photos in memory too.
+	Dir string
 }
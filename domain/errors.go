@@ -0,0 +1,85 @@
+// Package domain holds the error values shared by every layer. Repositories
+// and services wrap them with context; controllers map them to HTTP statuses
+// with errors.Is.
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrNotFound means the requested resource does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the request clashes with the current state of a
+	// resource, such as a duplicate of a unique value.
+	ErrConflict = errors.New("conflict")
+	// ErrValidation means the request was well-formed but its contents were
+	// rejected.
+	ErrValidation = errors.New("validation failed")
+	// ErrPreconditionFailed means a conditional request was made against a
+	// version of a resource that is no longer current.
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrNotApplied means an operation was rolled back, or never attempted,
+	// because another operation it had to succeed or fail with failed.
+	ErrNotApplied = errors.New("not applied")
+)
+
+// IsKnown reports whether err wraps one of the error kinds above, rather
+// than being an unexpected failure such as a lost database connection.
+func IsKnown(err error) bool {
+	for _, kind := range []error{ErrNotFound, ErrConflict, ErrValidation, ErrPreconditionFailed, ErrNotApplied} {
+		if errors.Is(err, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldError describes why a single field of a request was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError carries every rejected field of a request. It matches
+// ErrValidation with errors.Is.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Fields) == 0 {
+		return ErrValidation.Error()
+	}
+	fields := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		fields[i] = f.Field + " " + f.Message
+	}
+	return ErrValidation.Error() + ": " + strings.Join(fields, "; ")
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// ConflictError reports a value that must be unique but is already taken by
+// another resource. It matches ErrConflict with errors.Is.
+type ConflictError struct {
+	Field string
+	Value string
+	// ID identifies the resource holding the value, or is 0 if unknown.
+	ID int64
+}
+
+func (e *ConflictError) Error() string {
+	if e.ID == 0 {
+		return fmt.Sprintf("%s %q is already in use: %v", e.Field, e.Value, ErrConflict)
+	}
+	return fmt.Sprintf("%s %q is already in use by #%d: %v", e.Field, e.Value, e.ID, ErrConflict)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
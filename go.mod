@@ -1,9 +1,13 @@
 module github.com/pmaterer/peopler
 
-go 1.15
+go 1.16
 
 require (
+	github.com/evanphx/json-patch v4.12.0+incompatible
 	github.com/gorilla/mux v1.8.0
-	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/lib/pq v1.10.0
+	github.com/mattn/go-sqlite3 v1.14.7
+	github.com/pkg/errors v0.9.1 // indirect
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/text v0.3.6
 )
@@ -0,0 +1,270 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/group"
+	"github.com/pmaterer/peopler/internal/problem"
+)
+
+type service interface {
+	CreateGroup(ctx context.Context, g group.Group) (group.Group, error)
+	GetGroup(ctx context.Context, id int64) (group.Group, error)
+	GetAllGroups(ctx context.Context) ([]group.Group, error)
+	UpdateGroup(ctx context.Context, g group.Group) (group.Group, error)
+	DeleteGroup(ctx context.Context, id int64) error
+	AddUser(ctx context.Context, groupID, userID int64) error
+	RemoveUser(ctx context.Context, groupID, userID int64) error
+	AddSubgroup(ctx context.Context, groupID, subgroupID int64) error
+	RemoveSubgroup(ctx context.Context, groupID, subgroupID int64) error
+	GetMembers(ctx context.Context, id int64, effective bool) (group.Members, error)
+	GetUserGroups(ctx context.Context, userID int64, effective bool) ([]group.Group, error)
+}
+
+type Controller struct {
+	service service
+}
+
+func NewController(s service) *Controller {
+	return &Controller{
+		service: s,
+	}
+}
+
+type Response struct {
+	Message string `json:"message,omitempty"`
+}
+
+// ListResponse is the envelope for collections, as for users.
+type ListResponse struct {
+	Data  interface{} `json:"data"`
+	Total int64       `json:"total"`
+}
+
+func writeResponse(w http.ResponseWriter, code int, payload interface{}) {
+	response, _ := json.Marshal(payload)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(response)
+}
+
+func (c *Controller) CreateGroup() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		g, err := readGroup(r)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		created, err := c.service.CreateGroup(r.Context(), g)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/group/%d", created.ID))
+		writeResponse(w, http.StatusCreated, created)
+	}
+}
+
+func (c *Controller) GetAllGroups() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		groups, err := c.service.GetAllGroups(r.Context())
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		writeGroups(w, groups)
+	}
+}
+
+func (c *Controller) GetGroup() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := pathID(r, "id")
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		g, err := c.service.GetGroup(r.Context(), id)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		writeResponse(w, http.StatusOK, g)
+	}
+}
+
+func (c *Controller) UpdateGroup() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := pathID(r, "id")
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		g, err := readGroup(r)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		g.ID = id
+		updated, err := c.service.UpdateGroup(r.Context(), g)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		writeResponse(w, http.StatusOK, updated)
+	}
+}
+
+func (c *Controller) DeleteGroup() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := pathID(r, "id")
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if err := c.service.DeleteGroup(r.Context(), id); err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		writeResponse(w, http.StatusOK, Response{Message: "OK"})
+	}
+}
+
+// GetMembers lists the users and groups in the group, or with
+// effective=true everyone in it through nested groups too.
+func (c *Controller) GetMembers() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := pathID(r, "id")
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		effective, err := parseBool(r.URL.Query(), "effective", false)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		members, err := c.service.GetMembers(r.Context(), id, effective)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		writeResponse(w, http.StatusOK, members)
+	}
+}
+
+// AddUser handles PUT /group/{id}/members/users/{memberId}.
+func (c *Controller) AddUser() func(w http.ResponseWriter, r *http.Request) {
+	return c.changeMembers(func(ctx context.Context, groupID, memberID int64) error {
+		return c.service.AddUser(ctx, groupID, memberID)
+	})
+}
+
+// RemoveUser handles DELETE /group/{id}/members/users/{memberId}.
+func (c *Controller) RemoveUser() func(w http.ResponseWriter, r *http.Request) {
+	return c.changeMembers(func(ctx context.Context, groupID, memberID int64) error {
+		return c.service.RemoveUser(ctx, groupID, memberID)
+	})
+}
+
+// AddSubgroup handles PUT /group/{id}/members/groups/{memberId}.
+func (c *Controller) AddSubgroup() func(w http.ResponseWriter, r *http.Request) {
+	return c.changeMembers(func(ctx context.Context, groupID, memberID int64) error {
+		return c.service.AddSubgroup(ctx, groupID, memberID)
+	})
+}
+
+// RemoveSubgroup handles DELETE /group/{id}/members/groups/{memberId}.
+func (c *Controller) RemoveSubgroup() func(w http.ResponseWriter, r *http.Request) {
+	return c.changeMembers(func(ctx context.Context, groupID, memberID int64) error {
+		return c.service.RemoveSubgroup(ctx, groupID, memberID)
+	})
+}
+
+// changeMembers applies change to the group and member in the path.
+func (c *Controller) changeMembers(change func(ctx context.Context, groupID, memberID int64) error) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		groupID, err := pathID(r, "id")
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		memberID, err := pathID(r, "memberId")
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if err := change(r.Context(), groupID, memberID); err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		writeResponse(w, http.StatusOK, Response{Message: "OK"})
+	}
+}
+
+// GetUserGroups lists the groups the user belongs to, through nested groups
+// too unless effective=false.
+func (c *Controller) GetUserGroups() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := pathID(r, "id")
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		effective, err := parseBool(r.URL.Query(), "effective", true)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		groups, err := c.service.GetUserGroups(r.Context(), id, effective)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		writeGroups(w, groups)
+	}
+}
+
+func writeGroups(w http.ResponseWriter, groups []group.Group) {
+	if groups == nil {
+		groups = []group.Group{}
+	}
+	writeResponse(w, http.StatusOK, ListResponse{Data: groups, Total: int64(len(groups))})
+}
+
+func readGroup(r *http.Request) (group.Group, error) {
+	defer r.Body.Close()
+	var g group.Group
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return g, err
+	}
+	return g, json.Unmarshal(body, &g)
+}
+
+// pathID reads the ID in the named path variable.
+func pathID(r *http.Request, name string) (int64, error) {
+	return strconv.ParseInt(mux.Vars(r)[name], 10, 64)
+}
+
+// parseBool reads an optional boolean query parameter.
+func parseBool(query url.Values, name string, fallback bool) (bool, error) {
+	raw := query.Get(name)
+	if raw == "" {
+		return fallback, nil
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return fallback, &domain.ValidationError{Fields: []domain.FieldError{
+			{Field: name, Message: "must be true or false"},
+		}}
+	}
+	return value, nil
+}
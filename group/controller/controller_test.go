@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/group/repository/memory"
+	groupservice "github.com/pmaterer/peopler/group/service"
+	"github.com/pmaterer/peopler/user"
+	usermemory "github.com/pmaterer/peopler/user/repository/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestController returns a controller over in-memory repositories holding
+// Shane Glass and Stephen King, users #1 and #2.
+func newTestController(t *testing.T) *Controller {
+	users := usermemory.NewRepository()
+	for _, u := range []user.User{{FirstName: "Shane", LastName: "Glass"}, {FirstName: "Stephen", LastName: "King"}} {
+		_, err := users.CreateUser(context.Background(), u, audit.System)
+		assert.Nil(t, err)
+	}
+	return NewController(groupservice.NewService(memory.NewRepository(), users))
+}
+
+func send(handler http.HandlerFunc, method, path, payload string, vars map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(payload))
+	req = mux.SetURLVars(req, vars)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestGroups(t *testing.T) {
+	c := newTestController(t)
+
+	rr := send(c.CreateGroup(), "POST", "/group", `{"name":"Platform","description":"Runs things"}`, nil)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "/group/1", rr.Header().Get("Location"))
+	assert.Equal(t, `{"id":1,"name":"Platform","description":"Runs things"}`, rr.Body.String())
+
+	rr = send(c.CreateGroup(), "POST", "/group", `{"name":"platform"}`, nil)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	rr = send(c.CreateGroup(), "POST", "/group", `{"name":""}`, nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	rr = send(c.CreateGroup(), "POST", "/group", `{"name":`, nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = send(c.UpdateGroup(), "PUT", "/group/1", `{"name":"Infrastructure"}`, map[string]string{"id": "1"})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `{"id":1,"name":"Infrastructure"}`, rr.Body.String())
+
+	rr = send(c.GetAllGroups(), "GET", "/groups", "", nil)
+	assert.Equal(t, `{"data":[{"id":1,"name":"Infrastructure"}],"total":1}`, rr.Body.String())
+
+	rr = send(c.DeleteGroup(), "DELETE", "/group/1", "", map[string]string{"id": "1"})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = send(c.GetGroup(), "GET", "/group/1", "", map[string]string{"id": "1"})
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	rr = send(c.GetGroup(), "GET", "/group/one", "", map[string]string{"id": "one"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestGroupMembers(t *testing.T) {
+	c := newTestController(t)
+	for _, name := range []string{"Engineering", "Platform"} {
+		rr := send(c.CreateGroup(), "POST", "/group", `{"name":"`+name+`"}`, nil)
+		assert.Equal(t, http.StatusCreated, rr.Code)
+	}
+	member := func(handler http.HandlerFunc, method, groupID, memberID string) int {
+		return send(handler, method, "/group/"+groupID+"/members", "", map[string]string{"id": groupID, "memberId": memberID}).Code
+	}
+
+	assert.Equal(t, http.StatusOK, member(c.AddUser(), "PUT", "1", "1"))
+	assert.Equal(t, http.StatusOK, member(c.AddUser(), "PUT", "2", "2"))
+	assert.Equal(t, http.StatusOK, member(c.AddSubgroup(), "PUT", "1", "2"))
+	assert.Equal(t, http.StatusNotFound, member(c.AddUser(), "PUT", "1", "99"))
+	assert.Equal(t, http.StatusConflict, member(c.AddSubgroup(), "PUT", "2", "1"))
+	assert.Equal(t, http.StatusBadRequest, member(c.AddUser(), "PUT", "1", "me"))
+
+	rr := send(c.GetMembers(), "GET", "/group/1/members", "", map[string]string{"id": "1"})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `{"users":[{"id":1,"firstName":"Shane","lastName":"Glass"}],"groups":[{"id":2,"name":"Platform"}]}`, rr.Body.String())
+	rr = send(c.GetMembers(), "GET", "/group/1/members?effective=true", "", map[string]string{"id": "1"})
+	assert.Equal(t, `{"users":[{"id":1,"firstName":"Shane","lastName":"Glass"},{"id":2,"firstName":"Stephen","lastName":"King"}],`+
+		`"groups":[{"id":2,"name":"Platform"}]}`, rr.Body.String())
+	rr = send(c.GetMembers(), "GET", "/group/1/members?effective=maybe", "", map[string]string{"id": "1"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = send(c.GetUserGroups(), "GET", "/user/2/groups", "", map[string]string{"id": "2"})
+	assert.Equal(t, `{"data":[{"id":1,"name":"Engineering"},{"id":2,"name":"Platform"}],"total":2}`, rr.Body.String())
+	rr = send(c.GetUserGroups(), "GET", "/user/2/groups?effective=false", "", map[string]string{"id": "2"})
+	assert.Equal(t, `{"data":[{"id":2,"name":"Platform"}],"total":1}`, rr.Body.String())
+	rr = send(c.GetUserGroups(), "GET", "/user/99/groups", "", map[string]string{"id": "99"})
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	assert.Equal(t, http.StatusOK, member(c.RemoveSubgroup(), "DELETE", "1", "2"))
+	assert.Equal(t, http.StatusNotFound, member(c.RemoveSubgroup(), "DELETE", "1", "2"))
+	assert.Equal(t, http.StatusOK, member(c.RemoveUser(), "DELETE", "1", "1"))
+	rr = send(c.GetMembers(), "GET", "/group/1/members", "", map[string]string{"id": "1"})
+	assert.Equal(t, `{"users":[],"groups":[]}`, rr.Body.String())
+}
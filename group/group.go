@@ -0,0 +1,26 @@
+// Package group organises users into named groups, such as teams. Groups may
+// contain other groups, and a user belongs to every group that contains a
+// group they are in.
+package group
+
+import "github.com/pmaterer/peopler/user"
+
+type Group struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// Description says what the group is for. It is optional.
+	Description string `json:"description,omitempty"`
+}
+
+// Membership lists the members of a group by reference, as stored.
+type Membership struct {
+	UserIDs []int64
+	Groups  []Group
+}
+
+// Members lists the users and groups in a group. Deleted users are left out
+// until they are restored.
+type Members struct {
+	Users  []user.User `json:"users"`
+	Groups []Group     `json:"groups"`
+}
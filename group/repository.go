@@ -0,0 +1,37 @@
+package group
+
+import "context"
+
+// Repository stores groups and their members. Groups are listed by name,
+// ignoring case, then by ID.
+type Repository interface {
+	CreateGroup(ctx context.Context, g Group) (int64, error)
+	GetAllGroups(ctx context.Context) ([]Group, error)
+	GetGroup(ctx context.Context, id int64) (Group, error)
+	UpdateGroup(ctx context.Context, g Group) error
+	// DeleteGroup removes the group and its memberships, both its own
+	// members and its place in other groups.
+	DeleteGroup(ctx context.Context, id int64) error
+	// AddUser makes the user a member of the group. Adding a member twice
+	// is not an error.
+	AddUser(ctx context.Context, groupID, userID int64) error
+	// RemoveUser fails with domain.ErrNotFound unless the user is a direct
+	// member of the group.
+	RemoveUser(ctx context.Context, groupID, userID int64) error
+	// AddSubgroup makes the subgroup a member of the group. It does not
+	// check for cycles.
+	AddSubgroup(ctx context.Context, groupID, subgroupID int64) error
+	// RemoveSubgroup fails with domain.ErrNotFound unless the subgroup is a
+	// direct member of the group.
+	RemoveSubgroup(ctx context.Context, groupID, subgroupID int64) error
+	// Members returns the direct members of the group or, if effective, also
+	// those of every group nested in it, at any depth. User IDs are in
+	// ascending order and may include users since deleted.
+	Members(ctx context.Context, id int64, effective bool) (Membership, error)
+	// UserGroups returns the groups the user is a direct member of or, if
+	// effective, also every group those are nested in, at any depth.
+	UserGroups(ctx context.Context, userID int64, effective bool) ([]Group, error)
+	// WithTx runs fn as a unit of work against a repository scoped to one
+	// transaction, as for user.Repository.
+	WithTx(ctx context.Context, fn func(Repository) error) error
+}
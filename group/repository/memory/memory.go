@@ -0,0 +1,279 @@
+// Package memory is a concurrency-safe, in-memory group repository for tests
+// and demos. Nothing is persisted between runs. Contexts are accepted to
+// satisfy the repository interface but ignored, as no operation blocks.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/group"
+)
+
+// Repository keeps the members of each group as sets of IDs.
+type Repository struct {
+	mu        sync.RWMutex
+	groups    map[int64]group.Group
+	lastID    int64
+	users     map[int64]map[int64]bool
+	subgroups map[int64]map[int64]bool
+}
+
+func NewRepository() *Repository {
+	return &Repository{
+		groups:    map[int64]group.Group{},
+		users:     map[int64]map[int64]bool{},
+		subgroups: map[int64]map[int64]bool{},
+	}
+}
+
+func (r *Repository) CreateGroup(_ context.Context, g group.Group) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g.ID = 0
+	if err := r.checkUnique(g); err != nil {
+		return 0, err
+	}
+	r.lastID++
+	g.ID = r.lastID
+	r.groups[g.ID] = g
+	return g.ID, nil
+}
+
+func (r *Repository) GetAllGroups(_ context.Context) ([]group.Group, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	groups := make([]group.Group, 0, len(r.groups))
+	for _, g := range r.groups {
+		groups = append(groups, g)
+	}
+	sortGroups(groups)
+	return groups, nil
+}
+
+func (r *Repository) GetGroup(_ context.Context, id int64) (group.Group, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	g, ok := r.groups[id]
+	if !ok {
+		return group.Group{}, notFound(id)
+	}
+	return g, nil
+}
+
+func (r *Repository) UpdateGroup(_ context.Context, g group.Group) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.groups[g.ID]; !ok {
+		return notFound(g.ID)
+	}
+	if err := r.checkUnique(g); err != nil {
+		return err
+	}
+	r.groups[g.ID] = g
+	return nil
+}
+
+func (r *Repository) DeleteGroup(_ context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.groups[id]; !ok {
+		return notFound(id)
+	}
+	delete(r.groups, id)
+	delete(r.users, id)
+	delete(r.subgroups, id)
+	for _, subgroups := range r.subgroups {
+		delete(subgroups, id)
+	}
+	return nil
+}
+
+func (r *Repository) AddUser(_ context.Context, groupID, userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.add(r.users, groupID, userID)
+}
+
+func (r *Repository) RemoveUser(_ context.Context, groupID, userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.users[groupID][userID] {
+		return fmt.Errorf("user #%d is not a member of group #%d: %w", userID, groupID, domain.ErrNotFound)
+	}
+	delete(r.users[groupID], userID)
+	return nil
+}
+
+func (r *Repository) AddSubgroup(_ context.Context, groupID, subgroupID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.groups[subgroupID]; !ok {
+		return notFound(subgroupID)
+	}
+	return r.add(r.subgroups, groupID, subgroupID)
+}
+
+func (r *Repository) RemoveSubgroup(_ context.Context, groupID, subgroupID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.subgroups[groupID][subgroupID] {
+		return fmt.Errorf("group #%d is not a member of group #%d: %w", subgroupID, groupID, domain.ErrNotFound)
+	}
+	delete(r.subgroups[groupID], subgroupID)
+	return nil
+}
+
+// Members walks down the nested groups breadth first, visiting each once.
+func (r *Repository) Members(_ context.Context, id int64, effective bool) (group.Membership, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.groups[id]; !ok {
+		return group.Membership{}, notFound(id)
+	}
+	visited := map[int64]bool{id: true}
+	userIDs := map[int64]bool{}
+	var membership group.Membership
+	queue := []int64{id}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for userID := range r.users[current] {
+			userIDs[userID] = true
+		}
+		for subgroupID := range r.subgroups[current] {
+			if visited[subgroupID] {
+				continue
+			}
+			visited[subgroupID] = true
+			membership.Groups = append(membership.Groups, r.groups[subgroupID])
+			if effective {
+				queue = append(queue, subgroupID)
+			}
+		}
+	}
+	for userID := range userIDs {
+		membership.UserIDs = append(membership.UserIDs, userID)
+	}
+	sort.Slice(membership.UserIDs, func(i, j int) bool { return membership.UserIDs[i] < membership.UserIDs[j] })
+	sortGroups(membership.Groups)
+	return membership, nil
+}
+
+// UserGroups walks up from the user's own groups, visiting each group once.
+func (r *Repository) UserGroups(_ context.Context, userID int64, effective bool) ([]group.Group, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	found := map[int64]bool{}
+	var queue []int64
+	for groupID, users := range r.users {
+		if users[userID] {
+			found[groupID] = true
+			queue = append(queue, groupID)
+		}
+	}
+	for effective && len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for groupID, subgroups := range r.subgroups {
+			if subgroups[current] && !found[groupID] {
+				found[groupID] = true
+				queue = append(queue, groupID)
+			}
+		}
+	}
+
+	groups := []group.Group{}
+	for groupID := range found {
+		groups = append(groups, r.groups[groupID])
+	}
+	sortGroups(groups)
+	return groups, nil
+}
+
+// WithTx runs fn against a copy of the store while holding the write lock,
+// and keeps the copy only if fn succeeds. fn must only use the repository it
+// is given; calling r would deadlock.
+func (r *Repository) WithTx(_ context.Context, fn func(group.Repository) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx := &Repository{
+		groups:    make(map[int64]group.Group, len(r.groups)),
+		lastID:    r.lastID,
+		users:     copySets(r.users),
+		subgroups: copySets(r.subgroups),
+	}
+	for id, g := range r.groups {
+		tx.groups[id] = g
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	r.groups, r.lastID, r.users, r.subgroups = tx.groups, tx.lastID, tx.users, tx.subgroups
+	return nil
+}
+
+// add puts memberID in the set of groupID's members. The caller must hold
+// the write lock.
+func (r *Repository) add(members map[int64]map[int64]bool, groupID, memberID int64) error {
+	if _, ok := r.groups[groupID]; !ok {
+		return notFound(groupID)
+	}
+	if members[groupID] == nil {
+		members[groupID] = map[int64]bool{}
+	}
+	members[groupID][memberID] = true
+	return nil
+}
+
+// checkUnique rejects g if another group has its name, ignoring case. The
+// caller must hold the lock.
+func (r *Repository) checkUnique(g group.Group) error {
+	for id, other := range r.groups {
+		if id != g.ID && strings.EqualFold(other.Name, g.Name) {
+			return &domain.ConflictError{Field: "name", Value: g.Name, ID: id}
+		}
+	}
+	return nil
+}
+
+func copySets(sets map[int64]map[int64]bool) map[int64]map[int64]bool {
+	copied := make(map[int64]map[int64]bool, len(sets))
+	for id, set := range sets {
+		copied[id] = make(map[int64]bool, len(set))
+		for member := range set {
+			copied[id][member] = true
+		}
+	}
+	return copied
+}
+
+func sortGroups(groups []group.Group) {
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := strings.ToLower(groups[i].Name), strings.ToLower(groups[j].Name)
+		if a != b {
+			return a < b
+		}
+		return groups[i].ID < groups[j].ID
+	})
+}
+
+func notFound(id int64) error {
+	return fmt.Errorf("group #%d: %w", id, domain.ErrNotFound)
+}
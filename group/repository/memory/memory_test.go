@@ -0,0 +1,15 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/pmaterer/peopler/group/repository/repositorytest"
+	"github.com/pmaterer/peopler/user"
+	usermemory "github.com/pmaterer/peopler/user/repository/memory"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) (repositorytest.Repository, user.Repository) {
+		return NewRepository(), usermemory.NewRepository()
+	})
+}
@@ -0,0 +1,281 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/group"
+	"github.com/pmaterer/peopler/internal/dialect"
+	"github.com/pmaterer/peopler/internal/postgres"
+	"github.com/pmaterer/peopler/internal/sqlite"
+)
+
+// Repository stores groups in the same database as the users they contain.
+type Repository struct {
+	db *sql.DB
+	// conn runs the queries: db, or tx within WithTx.
+	conn    querier
+	tx      *sql.Tx
+	dialect dialect.Dialect
+	// retryable reports whether a failed transaction is worth retrying.
+	retryable func(error) bool
+	// uniqueViolation reports whether a statement failed on a unique index.
+	uniqueViolation func(error) bool
+}
+
+// NewRepository returns a repository backed by a SQLite database.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{
+		db:              db,
+		conn:            db,
+		dialect:         dialect.SQLite,
+		retryable:       sqlite.IsBusy,
+		uniqueViolation: sqlite.IsUniqueViolation,
+	}
+}
+
+// NewPostgresRepository returns a repository backed by a PostgreSQL database.
+func NewPostgresRepository(db *sql.DB) *Repository {
+	return &Repository{
+		db:              db,
+		conn:            db,
+		dialect:         dialect.Postgres,
+		uniqueViolation: postgres.IsUniqueViolation,
+	}
+}
+
+const groupColumns = `groups.id, groups.name, groups.description`
+
+// groupOrder sorts groups by name, ignoring case, then by ID.
+const groupOrder = ` ORDER BY lower(groups.name), groups.id`
+
+func (r *Repository) CreateGroup(ctx context.Context, g group.Group) (int64, error) {
+	var id int64
+	g.ID = 0
+	err := r.inTx(ctx, func(tx *Repository) error {
+		if err := tx.checkConflict(ctx, g); err != nil {
+			return err
+		}
+		query := `INSERT INTO groups(name, description) VALUES (?, ?)`
+		if tx.dialect == dialect.Postgres {
+			err := tx.conn.QueryRowContext(ctx, tx.dialect.Rebind(query+` RETURNING id`), g.Name, g.Description).Scan(&id)
+			return tx.checkUnique(err, g)
+		}
+		result, err := tx.conn.ExecContext(ctx, query, g.Name, g.Description)
+		if err != nil {
+			return tx.checkUnique(err, g)
+		}
+		id, err = result.LastInsertId()
+		return err
+	})
+	return id, err
+}
+
+func (r *Repository) GetAllGroups(ctx context.Context) ([]group.Group, error) {
+	return r.queryGroups(ctx, `SELECT `+groupColumns+` FROM groups`+groupOrder)
+}
+
+func (r *Repository) GetGroup(ctx context.Context, id int64) (group.Group, error) {
+	query := r.dialect.Rebind(`SELECT ` + groupColumns + ` FROM groups WHERE id = ?`)
+	var g group.Group
+	err := r.conn.QueryRowContext(ctx, query, id).Scan(&g.ID, &g.Name, &g.Description)
+	if errors.Is(err, sql.ErrNoRows) {
+		return g, notFound(id)
+	}
+	return g, err
+}
+
+func (r *Repository) UpdateGroup(ctx context.Context, g group.Group) error {
+	return r.inTx(ctx, func(tx *Repository) error {
+		if err := tx.checkConflict(ctx, g); err != nil {
+			return err
+		}
+		query := tx.dialect.Rebind(`UPDATE groups SET name = ?, description = ? WHERE id = ?`)
+		result, err := tx.conn.ExecContext(ctx, query, g.Name, g.Description, g.ID)
+		if err != nil {
+			return tx.checkUnique(err, g)
+		}
+		return mustAffect(result, notFound(g.ID))
+	})
+}
+
+func (r *Repository) DeleteGroup(ctx context.Context, id int64) error {
+	return r.inTx(ctx, func(tx *Repository) error {
+		query := tx.dialect.Rebind(`DELETE FROM group_users WHERE group_id = ?`)
+		if _, err := tx.conn.ExecContext(ctx, query, id); err != nil {
+			return err
+		}
+		query = tx.dialect.Rebind(`DELETE FROM group_subgroups WHERE group_id = ? OR subgroup_id = ?`)
+		if _, err := tx.conn.ExecContext(ctx, query, id, id); err != nil {
+			return err
+		}
+		result, err := tx.conn.ExecContext(ctx, tx.dialect.Rebind(`DELETE FROM groups WHERE id = ?`), id)
+		if err != nil {
+			return err
+		}
+		return mustAffect(result, notFound(id))
+	})
+}
+
+func (r *Repository) AddUser(ctx context.Context, groupID, userID int64) error {
+	return r.inTx(ctx, func(tx *Repository) error {
+		if _, err := tx.GetGroup(ctx, groupID); err != nil {
+			return err
+		}
+		query := tx.dialect.Rebind(`INSERT INTO group_users(group_id, user_id) VALUES (?, ?) ON CONFLICT DO NOTHING`)
+		_, err := tx.conn.ExecContext(ctx, query, groupID, userID)
+		return err
+	})
+}
+
+func (r *Repository) RemoveUser(ctx context.Context, groupID, userID int64) error {
+	query := r.dialect.Rebind(`DELETE FROM group_users WHERE group_id = ? AND user_id = ?`)
+	result, err := r.conn.ExecContext(ctx, query, groupID, userID)
+	if err != nil {
+		return err
+	}
+	return mustAffect(result, fmt.Errorf("user #%d is not a member of group #%d: %w", userID, groupID, domain.ErrNotFound))
+}
+
+func (r *Repository) AddSubgroup(ctx context.Context, groupID, subgroupID int64) error {
+	return r.inTx(ctx, func(tx *Repository) error {
+		for _, id := range []int64{groupID, subgroupID} {
+			if _, err := tx.GetGroup(ctx, id); err != nil {
+				return err
+			}
+		}
+		query := tx.dialect.Rebind(`INSERT INTO group_subgroups(group_id, subgroup_id) VALUES (?, ?) ON CONFLICT DO NOTHING`)
+		_, err := tx.conn.ExecContext(ctx, query, groupID, subgroupID)
+		return err
+	})
+}
+
+func (r *Repository) RemoveSubgroup(ctx context.Context, groupID, subgroupID int64) error {
+	query := r.dialect.Rebind(`DELETE FROM group_subgroups WHERE group_id = ? AND subgroup_id = ?`)
+	result, err := r.conn.ExecContext(ctx, query, groupID, subgroupID)
+	if err != nil {
+		return err
+	}
+	return mustAffect(result, fmt.Errorf("group #%d is not a member of group #%d: %w", subgroupID, groupID, domain.ErrNotFound))
+}
+
+// Members finds the nested groups with a recursive query. UNION rather than
+// UNION ALL visits each group once, so it ends even if the groups contain
+// each other.
+func (r *Repository) Members(ctx context.Context, id int64, effective bool) (group.Membership, error) {
+	var membership group.Membership
+	if _, err := r.GetGroup(ctx, id); err != nil {
+		return membership, err
+	}
+	nested := `WITH nested(id) AS (SELECT subgroup_id FROM group_subgroups WHERE group_id = ?) `
+	users := `SELECT user_id FROM group_users WHERE group_id = ?`
+	if effective {
+		nested = `WITH RECURSIVE nested(id) AS (
+				SELECT subgroup_id FROM group_subgroups WHERE group_id = ?
+				UNION
+				SELECT group_subgroups.subgroup_id FROM group_subgroups
+				JOIN nested ON group_subgroups.group_id = nested.id
+			) `
+		users = `SELECT DISTINCT user_id FROM group_users
+			WHERE group_id = ? OR group_id IN (SELECT id FROM nested)`
+	}
+
+	rows, err := r.conn.QueryContext(ctx, r.dialect.Rebind(nested+users+` ORDER BY user_id`), id, id)
+	if err != nil {
+		return membership, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return membership, err
+		}
+		membership.UserIDs = append(membership.UserIDs, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return membership, err
+	}
+	rows.Close()
+
+	// The group itself is left out should it be nested in itself.
+	membership.Groups, err = r.queryGroups(ctx, nested+`SELECT `+groupColumns+` FROM groups
+		WHERE id IN (SELECT id FROM nested) AND id <> ?`+groupOrder, id, id)
+	return membership, err
+}
+
+// UserGroups finds the groups containing the user's groups with a recursive
+// query, visiting each group once as Members does.
+func (r *Repository) UserGroups(ctx context.Context, userID int64, effective bool) ([]group.Group, error) {
+	containing := `SELECT group_id FROM group_users WHERE user_id = ?`
+	if effective {
+		containing = `WITH RECURSIVE containing(id) AS (
+				SELECT group_id FROM group_users WHERE user_id = ?
+				UNION
+				SELECT group_subgroups.group_id FROM group_subgroups
+				JOIN containing ON group_subgroups.subgroup_id = containing.id
+			)
+			SELECT id FROM containing`
+	}
+	return r.queryGroups(ctx, `SELECT `+groupColumns+` FROM groups WHERE id IN (`+containing+`)`+groupOrder, userID)
+}
+
+func (r *Repository) queryGroups(ctx context.Context, query string, args ...interface{}) ([]group.Group, error) {
+	rows, err := r.conn.QueryContext(ctx, r.dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []group.Group{}
+	for rows.Next() {
+		var g group.Group
+		if err := rows.Scan(&g.ID, &g.Name, &g.Description); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// checkConflict looks for another group with the name of g, ignoring case,
+// and reports it as a *domain.ConflictError naming that group.
+func (r *Repository) checkConflict(ctx context.Context, g group.Group) error {
+	var id int64
+	query := r.dialect.Rebind(`SELECT id FROM groups WHERE lower(name) = lower(?) AND id <> ?`)
+	err := r.conn.QueryRowContext(ctx, query, g.Name, g.ID).Scan(&id)
+	switch {
+	case err == nil:
+		return &domain.ConflictError{Field: "name", Value: g.Name, ID: id}
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	}
+	return err
+}
+
+// checkUnique turns the violation of the unique index on names, which a
+// concurrent write can still cause after checkConflict, into a conflict.
+func (r *Repository) checkUnique(err error, g group.Group) error {
+	if err != nil && r.uniqueViolation != nil && r.uniqueViolation(err) {
+		return &domain.ConflictError{Field: "name", Value: g.Name}
+	}
+	return err
+}
+
+// mustAffect returns notFound unless the statement changed a row.
+func mustAffect(result sql.Result, notFound error) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+func notFound(id int64) error {
+	return fmt.Errorf("group #%d: %w", id, domain.ErrNotFound)
+}
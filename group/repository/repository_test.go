@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/group"
+	"github.com/pmaterer/peopler/group/repository/repositorytest"
+	"github.com/pmaterer/peopler/internal/postgres"
+	"github.com/pmaterer/peopler/internal/sqlite"
+	"github.com/pmaterer/peopler/user"
+	userrepository "github.com/pmaterer/peopler/user/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+// postgresDSNEnv names the environment variable holding a connection string
+// for a disposable PostgreSQL database, as for the user repository tests.
+const postgresDSNEnv = "PEOPLER_TEST_POSTGRES_DSN"
+
+func TestSQLiteRepository(t *testing.T) {
+	skipWithoutFTS5(t)
+
+	repositorytest.Run(t, func(t *testing.T) (repositorytest.Repository, user.Repository) {
+		db, err := sqlite.NewSQLiteHandler(filepath.Join(t.TempDir(), "peopler.db"))
+		assert.Nil(t, err)
+		t.Cleanup(func() { db.Close() })
+		return NewRepository(db), userrepository.NewRepository(db)
+	})
+}
+
+func TestPostgresRepository(t *testing.T) {
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", postgresDSNEnv)
+	}
+
+	repositorytest.Run(t, func(t *testing.T) (repositorytest.Repository, user.Repository) {
+		db, err := postgres.NewPostgresHandler(dsn)
+		assert.Nil(t, err)
+		t.Cleanup(func() { db.Close() })
+		_, err = db.Exec(`TRUNCATE groups, users, audit_events RESTART IDENTITY CASCADE`)
+		assert.Nil(t, err)
+		return NewPostgresRepository(db), userrepository.NewPostgresRepository(db)
+	})
+}
+
+// TestSQLitePurgedUsersLeaveGroups checks that purging a user removes their
+// memberships, which SQLite would keep as it does not enforce foreign keys.
+func TestSQLitePurgedUsersLeaveGroups(t *testing.T) {
+	skipWithoutFTS5(t)
+
+	ctx := context.Background()
+	db, err := sqlite.NewSQLiteHandler(filepath.Join(t.TempDir(), "peopler.db"))
+	assert.Nil(t, err)
+	defer db.Close()
+	r, users := NewRepository(db), userrepository.NewRepository(db)
+
+	groupID, err := r.CreateGroup(ctx, group.Group{Name: "Platform"})
+	assert.Nil(t, err)
+	userID, err := users.CreateUser(ctx, user.User{FirstName: "Shane", LastName: "Glass"}, audit.System)
+	assert.Nil(t, err)
+	assert.Nil(t, r.AddUser(ctx, groupID, userID))
+	_, err = users.DeleteUser(ctx, userID, 0, audit.System)
+	assert.Nil(t, err)
+
+	members, err := r.Members(ctx, groupID, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{userID}, members.UserIDs, "deleted users stay in their groups until purged")
+
+	_, err = users.PurgeUsers(ctx, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	members, err = r.Members(ctx, groupID, false)
+	assert.Nil(t, err)
+	assert.Empty(t, members.UserIDs)
+}
+
+// skipWithoutFTS5 skips tests needing the schema, which needs FTS5 for user
+// search.
+func skipWithoutFTS5(t *testing.T) {
+	t.Helper()
+	db, err := sqlite.Open(":memory:")
+	assert.Nil(t, err)
+	enabled, err := sqlite.FTS5Enabled(db)
+	db.Close()
+	assert.Nil(t, err)
+	if !enabled {
+		t.Skip(sqlite.ErrFTS5Unavailable)
+	}
+}
@@ -0,0 +1,265 @@
+// Package repositorytest is a conformance suite shared by every group
+// repository backend.
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/group"
+	"github.com/pmaterer/peopler/user"
+	"github.com/stretchr/testify/assert"
+)
+
+// Repository is the storage contract the group service depends on.
+type Repository = group.Repository
+
+// Run exercises the behaviours every backend must share. newRepository must
+// return a group repository over an empty store each time it is called,
+// along with a user repository over the same store for the members.
+func Run(t *testing.T, newRepository func(t *testing.T) (Repository, user.Repository)) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, r Repository, users user.Repository)
+	}{
+		{"CreateGroup", testCreateGroup},
+		{"UpdateGroup", testUpdateGroup},
+		{"DeleteGroup", testDeleteGroup},
+		{"UniqueName", testUniqueName},
+		{"Members", testMembers},
+		{"NestedGroups", testNestedGroups},
+		{"UserGroups", testUserGroups},
+		{"WithTx", testWithTx},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, users := newRepository(t)
+			tt.test(t, r, users)
+		})
+	}
+}
+
+func createGroups(t *testing.T, r Repository, names ...string) []int64 {
+	t.Helper()
+	var ids []int64
+	for _, name := range names {
+		id, err := r.CreateGroup(context.Background(), group.Group{Name: name})
+		assert.Nil(t, err)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func createUsers(t *testing.T, users user.Repository, n int) []int64 {
+	t.Helper()
+	var ids []int64
+	for i := 0; i < n; i++ {
+		id, err := users.CreateUser(context.Background(), user.User{FirstName: "Member", LastName: string(rune('A' + i))}, audit.System)
+		assert.Nil(t, err)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func groupIDs(groups []group.Group) []int64 {
+	var ids []int64
+	for _, g := range groups {
+		ids = append(ids, g.ID)
+	}
+	return ids
+}
+
+func testCreateGroup(t *testing.T, r Repository, _ user.Repository) {
+	ctx := context.Background()
+	id, err := r.CreateGroup(ctx, group.Group{ID: 42, Name: "Platform", Description: "Runs the platform"})
+	assert.Nil(t, err)
+	assert.NotEqual(t, int64(42), id, "the ID is assigned")
+
+	g, err := r.GetGroup(ctx, id)
+	assert.Nil(t, err)
+	assert.Equal(t, group.Group{ID: id, Name: "Platform", Description: "Runs the platform"}, g)
+
+	_, err = r.GetGroup(ctx, id+100)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+
+	ids := createGroups(t, r, "design", "Backend")
+	groups, err := r.GetAllGroups(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{ids[1], ids[0], id}, groupIDs(groups), "sorted by name ignoring case")
+}
+
+func testUpdateGroup(t *testing.T, r Repository, _ user.Repository) {
+	ctx := context.Background()
+	ids := createGroups(t, r, "Platform")
+
+	err := r.UpdateGroup(ctx, group.Group{ID: ids[0], Name: "Infrastructure", Description: "Was Platform"})
+	assert.Nil(t, err)
+	g, err := r.GetGroup(ctx, ids[0])
+	assert.Nil(t, err)
+	assert.Equal(t, "Infrastructure", g.Name)
+	assert.Equal(t, "Was Platform", g.Description)
+
+	err = r.UpdateGroup(ctx, group.Group{ID: ids[0] + 100, Name: "Nobody"})
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+}
+
+func testDeleteGroup(t *testing.T, r Repository, users user.Repository) {
+	ctx := context.Background()
+	ids := createGroups(t, r, "Engineering", "Platform", "Backend")
+	userIDs := createUsers(t, users, 1)
+	assert.Nil(t, r.AddSubgroup(ctx, ids[0], ids[1]))
+	assert.Nil(t, r.AddSubgroup(ctx, ids[1], ids[2]))
+	assert.Nil(t, r.AddUser(ctx, ids[1], userIDs[0]))
+
+	assert.Nil(t, r.DeleteGroup(ctx, ids[1]))
+	_, err := r.GetGroup(ctx, ids[1])
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+	err = r.DeleteGroup(ctx, ids[1])
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+
+	members, err := r.Members(ctx, ids[0], true)
+	assert.Nil(t, err)
+	assert.Empty(t, members.Groups, "a deleted group leaves the groups it was in")
+	assert.Empty(t, members.UserIDs)
+	groups, err := r.UserGroups(ctx, userIDs[0], true)
+	assert.Nil(t, err)
+	assert.Empty(t, groups)
+	_, err = r.GetGroup(ctx, ids[2])
+	assert.Nil(t, err, "nested groups are kept")
+}
+
+func testUniqueName(t *testing.T, r Repository, _ user.Repository) {
+	ctx := context.Background()
+	ids := createGroups(t, r, "Platform", "Design")
+
+	_, err := r.CreateGroup(ctx, group.Group{Name: "PLATFORM"})
+	var conflictErr *domain.ConflictError
+	if assert.True(t, errors.As(err, &conflictErr), "got %v", err) {
+		assert.Equal(t, "name", conflictErr.Field)
+		assert.Equal(t, ids[0], conflictErr.ID)
+	}
+	err = r.UpdateGroup(ctx, group.Group{ID: ids[1], Name: "platform"})
+	assert.True(t, errors.Is(err, domain.ErrConflict))
+
+	assert.Nil(t, r.UpdateGroup(ctx, group.Group{ID: ids[0], Name: "platform"}), "a group keeps its own name")
+}
+
+func testMembers(t *testing.T, r Repository, users user.Repository) {
+	ctx := context.Background()
+	ids := createGroups(t, r, "Platform")
+	userIDs := createUsers(t, users, 3)
+
+	for _, id := range []int64{userIDs[2], userIDs[0], userIDs[2]} {
+		assert.Nil(t, r.AddUser(ctx, ids[0], id), "adding twice is not an error")
+	}
+	members, err := r.Members(ctx, ids[0], false)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{userIDs[0], userIDs[2]}, members.UserIDs)
+	assert.Empty(t, members.Groups)
+
+	assert.Nil(t, r.RemoveUser(ctx, ids[0], userIDs[0]))
+	err = r.RemoveUser(ctx, ids[0], userIDs[0])
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+	members, err = r.Members(ctx, ids[0], false)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{userIDs[2]}, members.UserIDs)
+
+	err = r.AddUser(ctx, ids[0]+100, userIDs[1])
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+	_, err = r.Members(ctx, ids[0]+100, false)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+}
+
+// testNestedGroups builds Engineering > Platform > Backend, with Design also
+// in Engineering, and a user in each group.
+func testNestedGroups(t *testing.T, r Repository, users user.Repository) {
+	ctx := context.Background()
+	ids := createGroups(t, r, "Engineering", "Platform", "Backend", "Design")
+	engineering, platform, backend, design := ids[0], ids[1], ids[2], ids[3]
+	userIDs := createUsers(t, users, 4)
+	for i, id := range ids {
+		assert.Nil(t, r.AddUser(ctx, id, userIDs[i]))
+	}
+	assert.Nil(t, r.AddSubgroup(ctx, engineering, platform))
+	assert.Nil(t, r.AddSubgroup(ctx, engineering, design))
+	assert.Nil(t, r.AddSubgroup(ctx, platform, backend))
+	assert.Nil(t, r.AddSubgroup(ctx, engineering, platform), "adding twice is not an error")
+	// Backend is reachable twice now, and must be counted once.
+	assert.Nil(t, r.AddSubgroup(ctx, design, backend))
+
+	members, err := r.Members(ctx, engineering, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{userIDs[0]}, members.UserIDs)
+	assert.Equal(t, []int64{design, platform}, groupIDs(members.Groups))
+
+	members, err = r.Members(ctx, engineering, true)
+	assert.Nil(t, err)
+	assert.Equal(t, userIDs, members.UserIDs)
+	assert.Equal(t, []int64{backend, design, platform}, groupIDs(members.Groups))
+
+	assert.Nil(t, r.RemoveSubgroup(ctx, engineering, platform))
+	err = r.RemoveSubgroup(ctx, engineering, platform)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+	members, err = r.Members(ctx, engineering, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{userIDs[0], userIDs[2], userIDs[3]}, members.UserIDs)
+	assert.Equal(t, []int64{backend, design}, groupIDs(members.Groups))
+
+	err = r.AddSubgroup(ctx, engineering, backend+100)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+}
+
+func testUserGroups(t *testing.T, r Repository, users user.Repository) {
+	ctx := context.Background()
+	ids := createGroups(t, r, "Engineering", "Platform", "Backend", "Social")
+	engineering, platform, backend, social := ids[0], ids[1], ids[2], ids[3]
+	userIDs := createUsers(t, users, 2)
+	assert.Nil(t, r.AddSubgroup(ctx, engineering, platform))
+	assert.Nil(t, r.AddSubgroup(ctx, platform, backend))
+	assert.Nil(t, r.AddUser(ctx, backend, userIDs[0]))
+	assert.Nil(t, r.AddUser(ctx, social, userIDs[0]))
+
+	groups, err := r.UserGroups(ctx, userIDs[0], false)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{backend, social}, groupIDs(groups))
+	groups, err = r.UserGroups(ctx, userIDs[0], true)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{backend, engineering, platform, social}, groupIDs(groups))
+
+	groups, err = r.UserGroups(ctx, userIDs[1], true)
+	assert.Nil(t, err)
+	assert.Empty(t, groups)
+}
+
+func testWithTx(t *testing.T, r Repository, users user.Repository) {
+	ctx := context.Background()
+	userIDs := createUsers(t, users, 1)
+	errRollback := errors.New("roll back")
+
+	err := r.WithTx(ctx, func(tx Repository) error {
+		id, err := tx.CreateGroup(ctx, group.Group{Name: "Platform"})
+		assert.Nil(t, err)
+		assert.Nil(t, tx.AddUser(ctx, id, userIDs[0]))
+		return errRollback
+	})
+	assert.Equal(t, errRollback, err)
+	groups, err := r.GetAllGroups(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, groups)
+
+	err = r.WithTx(ctx, func(tx Repository) error {
+		id, err := tx.CreateGroup(ctx, group.Group{Name: "Platform"})
+		if err != nil {
+			return err
+		}
+		return tx.AddUser(ctx, id, userIDs[0])
+	})
+	assert.Nil(t, err)
+	groups, err = r.UserGroups(ctx, userIDs[0], false)
+	assert.Nil(t, err)
+	assert.Len(t, groups, 1)
+}
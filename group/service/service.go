@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/group"
+	"github.com/pmaterer/peopler/user"
+)
+
+type Service struct {
+	repository group.Repository
+	// users looks up the users that groups refer to by ID.
+	users user.Repository
+}
+
+func NewService(r group.Repository, users user.Repository) *Service {
+	return &Service{
+		repository: r,
+		users:      users,
+	}
+}
+
+// CreateGroup stores a new group and returns it with its assigned ID.
+func (s *Service) CreateGroup(ctx context.Context, g group.Group) (group.Group, error) {
+	g.Normalize()
+	if err := g.Validate(); err != nil {
+		return group.Group{}, err
+	}
+	id, err := s.repository.CreateGroup(ctx, g)
+	if err != nil {
+		return group.Group{}, err
+	}
+	g.ID = id
+	log.Printf("Created new group #%d\n", id)
+	return g, nil
+}
+
+func (s *Service) GetGroup(ctx context.Context, id int64) (group.Group, error) {
+	return s.repository.GetGroup(ctx, id)
+}
+
+func (s *Service) GetAllGroups(ctx context.Context) ([]group.Group, error) {
+	return s.repository.GetAllGroups(ctx)
+}
+
+// UpdateGroup replaces the group's name and description.
+func (s *Service) UpdateGroup(ctx context.Context, g group.Group) (group.Group, error) {
+	g.Normalize()
+	if err := g.Validate(); err != nil {
+		return group.Group{}, err
+	}
+	if err := s.repository.UpdateGroup(ctx, g); err != nil {
+		return group.Group{}, err
+	}
+	log.Printf("Updated group #%d\n", g.ID)
+	return g, nil
+}
+
+// DeleteGroup removes the group. Its members are not deleted, but leave the
+// groups it was in.
+func (s *Service) DeleteGroup(ctx context.Context, id int64) error {
+	if err := s.repository.DeleteGroup(ctx, id); err != nil {
+		return err
+	}
+	log.Printf("Deleted group #%d\n", id)
+	return nil
+}
+
+// AddUser makes the user, who must not be deleted, a member of the group.
+func (s *Service) AddUser(ctx context.Context, groupID, userID int64) error {
+	if _, err := s.users.GetUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := s.repository.AddUser(ctx, groupID, userID); err != nil {
+		return err
+	}
+	log.Printf("Added user #%d to group #%d\n", userID, groupID)
+	return nil
+}
+
+func (s *Service) RemoveUser(ctx context.Context, groupID, userID int64) error {
+	if err := s.repository.RemoveUser(ctx, groupID, userID); err != nil {
+		return err
+	}
+	log.Printf("Removed user #%d from group #%d\n", userID, groupID)
+	return nil
+}
+
+// AddSubgroup nests one group in another, unless the other is already nested
+// in it, at any depth, which would make a cycle.
+func (s *Service) AddSubgroup(ctx context.Context, groupID, subgroupID int64) error {
+	err := s.repository.WithTx(ctx, func(repo group.Repository) error {
+		if groupID == subgroupID {
+			return fmt.Errorf("group #%d cannot contain itself: %w", groupID, domain.ErrConflict)
+		}
+		nested, err := repo.Members(ctx, subgroupID, true)
+		if err != nil {
+			return err
+		}
+		for _, g := range nested.Groups {
+			if g.ID == groupID {
+				return fmt.Errorf("group #%d already contains group #%d: %w", subgroupID, groupID, domain.ErrConflict)
+			}
+		}
+		return repo.AddSubgroup(ctx, groupID, subgroupID)
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Added group #%d to group #%d\n", subgroupID, groupID)
+	return nil
+}
+
+func (s *Service) RemoveSubgroup(ctx context.Context, groupID, subgroupID int64) error {
+	if err := s.repository.RemoveSubgroup(ctx, groupID, subgroupID); err != nil {
+		return err
+	}
+	log.Printf("Removed group #%d from group #%d\n", subgroupID, groupID)
+	return nil
+}
+
+// GetMembers returns the users and groups in the group or, if effective,
+// also those in the groups nested in it, at any depth.
+func (s *Service) GetMembers(ctx context.Context, id int64, effective bool) (group.Members, error) {
+	membership, err := s.repository.Members(ctx, id, effective)
+	if err != nil {
+		return group.Members{}, err
+	}
+	users, err := s.users.GetUsers(ctx, membership.UserIDs)
+	if err != nil {
+		return group.Members{}, err
+	}
+	members := group.Members{Users: users, Groups: membership.Groups}
+	if members.Users == nil {
+		members.Users = []user.User{}
+	}
+	if members.Groups == nil {
+		members.Groups = []group.Group{}
+	}
+	return members, nil
+}
+
+// GetUserGroups returns the groups the user, who must not be deleted, is a
+// member of or, if effective, belongs to through nested groups too.
+func (s *Service) GetUserGroups(ctx context.Context, userID int64, effective bool) ([]group.Group, error) {
+	if _, err := s.users.GetUser(ctx, userID); err != nil {
+		return nil, err
+	}
+	return s.repository.UserGroups(ctx, userID, effective)
+}
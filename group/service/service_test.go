@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/group"
+	"github.com/pmaterer/peopler/group/repository/memory"
+	"github.com/pmaterer/peopler/user"
+	usermemory "github.com/pmaterer/peopler/user/repository/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestService returns a service over in-memory repositories holding
+// testUsers, with IDs 1 to 3.
+func newTestService(t *testing.T) (*Service, user.Repository) {
+	users := usermemory.NewRepository()
+	for _, u := range testUsers {
+		_, err := users.CreateUser(context.Background(), u, audit.System)
+		assert.Nil(t, err)
+	}
+	return NewService(memory.NewRepository(), users), users
+}
+
+var testUsers = []user.User{
+	{FirstName: "Stephen", LastName: "King"},
+	{FirstName: "Herman", LastName: "Melville"},
+	{FirstName: "Haruki", LastName: "Murakami"},
+}
+
+func TestCreateGroup(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+
+	created, err := s.CreateGroup(ctx, group.Group{Name: "  Platform ", Description: "Runs things\nquietly"})
+	assert.Nil(t, err)
+	assert.Equal(t, group.Group{ID: 1, Name: "Platform", Description: "Runs things\nquietly"}, created)
+
+	_, err = s.CreateGroup(ctx, group.Group{Name: " "})
+	assert.True(t, errors.Is(err, domain.ErrValidation))
+	_, err = s.CreateGroup(ctx, group.Group{Name: "platform"})
+	assert.True(t, errors.Is(err, domain.ErrConflict))
+
+	updated, err := s.UpdateGroup(ctx, group.Group{ID: 1, Name: "Infrastructure "})
+	assert.Nil(t, err)
+	assert.Equal(t, "Infrastructure", updated.Name)
+	_, err = s.UpdateGroup(ctx, group.Group{ID: 2, Name: "Design"})
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+}
+
+func TestMembers(t *testing.T) {
+	s, users := newTestService(t)
+	ctx := context.Background()
+	for _, name := range []string{"Engineering", "Platform"} {
+		_, err := s.CreateGroup(ctx, group.Group{Name: name})
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, s.AddUser(ctx, 1, 1))
+	assert.Nil(t, s.AddUser(ctx, 2, 2))
+	assert.Nil(t, s.AddUser(ctx, 2, 3))
+	assert.Nil(t, s.AddSubgroup(ctx, 1, 2))
+	err := s.AddUser(ctx, 1, 99)
+	assert.True(t, errors.Is(err, domain.ErrNotFound), "only existing users can join")
+
+	members, err := s.GetMembers(ctx, 1, false)
+	assert.Nil(t, err)
+	if assert.Len(t, members.Users, 1) {
+		assert.Equal(t, "King", members.Users[0].LastName)
+	}
+	assert.Len(t, members.Groups, 1)
+
+	_, err = users.DeleteUser(ctx, 3, 0, audit.System)
+	assert.Nil(t, err)
+	members, err = s.GetMembers(ctx, 1, true)
+	assert.Nil(t, err)
+	assert.Len(t, members.Users, 2, "deleted users are left out")
+
+	groups, err := s.GetUserGroups(ctx, 2, true)
+	assert.Nil(t, err)
+	assert.Len(t, groups, 2)
+	groups, err = s.GetUserGroups(ctx, 2, false)
+	assert.Nil(t, err)
+	assert.Len(t, groups, 1)
+	_, err = s.GetUserGroups(ctx, 3, true)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+
+	assert.Nil(t, s.RemoveSubgroup(ctx, 1, 2))
+	assert.Nil(t, s.RemoveUser(ctx, 1, 1))
+	members, err = s.GetMembers(ctx, 1, true)
+	assert.Nil(t, err)
+	assert.Equal(t, group.Members{Users: []user.User{}, Groups: []group.Group{}}, members)
+}
+
+func TestNestedGroupCycles(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	for _, name := range []string{"Engineering", "Platform", "Backend"} {
+		_, err := s.CreateGroup(ctx, group.Group{Name: name})
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, s.AddSubgroup(ctx, 1, 2))
+	assert.Nil(t, s.AddSubgroup(ctx, 2, 3))
+
+	for _, pair := range [][2]int64{{3, 1}, {2, 1}, {1, 1}} {
+		err := s.AddSubgroup(ctx, pair[0], pair[1])
+		assert.True(t, errors.Is(err, domain.ErrConflict), "group #%d in group #%d: got %v", pair[1], pair[0], err)
+	}
+	err := s.AddSubgroup(ctx, 1, 99)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+	assert.Nil(t, s.AddSubgroup(ctx, 1, 3), "nesting a group twice is no cycle")
+}
@@ -0,0 +1,58 @@
+package group
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pmaterer/peopler/domain"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// MaxNameLength is the longest group name accepted, in characters.
+	MaxNameLength = 100
+	// MaxDescriptionLength is the longest description accepted, in
+	// characters.
+	MaxDescriptionLength = 500
+)
+
+// Normalize trims surrounding whitespace from the name and description and
+// converts them to Unicode NFC, as user.User.Normalize does for names.
+func (g *Group) Normalize() {
+	g.Name = norm.NFC.String(strings.TrimSpace(g.Name))
+	g.Description = norm.NFC.String(strings.TrimSpace(g.Description))
+}
+
+// Validate reports every field that breaks the rules as a
+// *domain.ValidationError. Call Normalize first.
+func (g Group) Validate() error {
+	var fields []domain.FieldError
+	if g.Name == "" {
+		fields = append(fields, domain.FieldError{Field: "name", Message: "is required"})
+	} else {
+		fields = appendTextErrors(fields, "name", g.Name, MaxNameLength, false)
+	}
+	fields = appendTextErrors(fields, "description", g.Description, MaxDescriptionLength, true)
+	if len(fields) > 0 {
+		return &domain.ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// appendTextErrors checks a line of text, or several if multiline.
+func appendTextErrors(fields []domain.FieldError, field, value string, max int, multiline bool) []domain.FieldError {
+	control := func(r rune) bool {
+		return unicode.IsControl(r) && !(multiline && (r == '\n' || r == '\r' || r == '\t'))
+	}
+	switch {
+	case !utf8.ValidString(value):
+		return append(fields, domain.FieldError{Field: field, Message: "must be valid UTF-8"})
+	case utf8.RuneCountInString(value) > max:
+		return append(fields, domain.FieldError{Field: field, Message: fmt.Sprintf("must be at most %d characters", max)})
+	case strings.IndexFunc(value, control) >= 0:
+		return append(fields, domain.FieldError{Field: field, Message: "must not contain control characters"})
+	}
+	return fields
+}
@@ -0,0 +1,51 @@
+package group
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		group  Group
+		fields []domain.FieldError
+	}{
+		{
+			name:  "Valid group",
+			group: Group{Name: "Platform", Description: "Runs the platform.\nAsk in #platform."},
+		},
+		{
+			name:   "Missing name",
+			group:  Group{},
+			fields: []domain.FieldError{{Field: "name", Message: "is required"}},
+		},
+		{
+			name:  "Too long",
+			group: Group{Name: strings.Repeat("é", MaxNameLength+1), Description: strings.Repeat("x", MaxDescriptionLength+1)},
+			fields: []domain.FieldError{
+				{Field: "name", Message: "must be at most 100 characters"},
+				{Field: "description", Message: "must be at most 500 characters"},
+			},
+		},
+		{
+			name:   "Line break in name",
+			group:  Group{Name: "Plat\nform"},
+			fields: []domain.FieldError{{Field: "name", Message: "must not contain control characters"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.group.Validate()
+			if tt.fields == nil {
+				assert.Nil(t, err)
+				return
+			}
+			assert.Equal(t, &domain.ValidationError{Fields: tt.fields}, err)
+		})
+	}
+}
@@ -0,0 +1,24 @@
+// Package deadline bounds how long a request may keep the database busy.
+package deadline
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Middleware cancels the context of every request after timeout, which
+// aborts any query still running for it. A zero timeout disables it; the
+// request is then only cancelled when the client disconnects.
+func Middleware(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package deadline
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name        string
+		timeout     time.Duration
+		hasDeadline bool
+	}{
+		{"Timeout", time.Minute, true},
+		{"Disabled", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctx context.Context
+			handler := Middleware(tt.timeout)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx = r.Context()
+			}))
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+
+			deadline, ok := ctx.Deadline()
+			assert.Equal(t, tt.hasDeadline, ok)
+			if tt.hasDeadline {
+				assert.WithinDuration(t, time.Now().Add(tt.timeout), deadline, time.Second)
+				assert.Error(t, ctx.Err(), "the context is released once the handler returns")
+			}
+		})
+	}
+}
+
+func TestMiddlewareCancelsSlowHandlers(t *testing.T) {
+	handler := Middleware(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			assert.Equal(t, context.DeadlineExceeded, r.Context().Err())
+		case <-time.After(time.Second):
+			t.Error("request was not cancelled")
+		}
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+}
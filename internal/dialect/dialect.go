@@ -0,0 +1,36 @@
+package dialect
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect identifies the SQL flavour spoken by a database backend.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// Rebind rewrites the ? placeholders in query into the bind variable syntax
+// of the dialect. Queries are written once using ? and rebound at the call site.
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 10)
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,14 @@
+package dialect
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRebind(t *testing.T) {
+	query := `UPDATE users SET first_name=?, last_name=? WHERE id=?`
+
+	assert.Equal(t, query, SQLite.Rebind(query))
+	assert.Equal(t, `UPDATE users SET first_name=$1, last_name=$2 WHERE id=$3`, Postgres.Rebind(query))
+}
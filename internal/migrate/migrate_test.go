@@ -0,0 +1,124 @@
+package migrate
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pmaterer/peopler/internal/dialect"
+	"github.com/stretchr/testify/assert"
+)
+
+var testMigrations = fstest.MapFS{
+	"0001_create_people.up.sql":   {Data: []byte(`CREATE TABLE people (id INTEGER PRIMARY KEY);`)},
+	"0001_create_people.down.sql": {Data: []byte(`DROP TABLE people;`)},
+	"0002_create_teams.up.sql":    {Data: []byte(`CREATE TABLE teams (id INTEGER PRIMARY KEY);`)},
+	"0002_create_teams.down.sql":  {Data: []byte(`DROP TABLE teams;`)},
+	"README.md":                   {Data: []byte(`ignored`)},
+}
+
+func newTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	assert.Nil(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&count)
+	assert.Nil(t, err)
+	return count == 1
+}
+
+func TestUp(t *testing.T) {
+	db := newTestDB(t)
+	m, err := NewMigrator(db, dialect.SQLite, testMigrations)
+	assert.Nil(t, err)
+
+	count, err := m.Up()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	assert.True(t, tableExists(t, db, "people"))
+	assert.True(t, tableExists(t, db, "teams"))
+
+	count, err = m.Up()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestDown(t *testing.T) {
+	db := newTestDB(t)
+	m, err := NewMigrator(db, dialect.SQLite, testMigrations)
+	assert.Nil(t, err)
+
+	_, err = m.Up()
+	assert.Nil(t, err)
+
+	for i := 0; i < 2; i++ {
+		rolledBack, err := m.Down()
+		assert.Nil(t, err)
+		assert.True(t, rolledBack)
+	}
+	assert.False(t, tableExists(t, db, "people"))
+	assert.False(t, tableExists(t, db, "teams"))
+
+	rolledBack, err := m.Down()
+	assert.Nil(t, err)
+	assert.False(t, rolledBack)
+}
+
+func TestStatus(t *testing.T) {
+	db := newTestDB(t)
+	m, err := NewMigrator(db, dialect.SQLite, testMigrations)
+	assert.Nil(t, err)
+
+	statuses, err := m.Status()
+	assert.Nil(t, err)
+	assert.Len(t, statuses, 2)
+	for _, s := range statuses {
+		assert.False(t, s.Applied)
+	}
+
+	_, err = m.Up()
+	assert.Nil(t, err)
+	_, err = m.Down()
+	assert.Nil(t, err)
+
+	statuses, err = m.Status()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), statuses[0].Version)
+	assert.Equal(t, "create_people", statuses[0].Name)
+	assert.True(t, statuses[0].Applied)
+	assert.False(t, statuses[0].AppliedAt.IsZero())
+	assert.False(t, statuses[1].Applied)
+}
+
+func TestFailedMigrationIsNotRecorded(t *testing.T) {
+	db := newTestDB(t)
+	source := fstest.MapFS{
+		"0001_broken.up.sql":   {Data: []byte(`CREATE TABLE ok (id INTEGER); NOT SQL;`)},
+		"0001_broken.down.sql": {Data: []byte(`DROP TABLE ok;`)},
+	}
+	m, err := NewMigrator(db, dialect.SQLite, source)
+	assert.Nil(t, err)
+
+	_, err = m.Up()
+	assert.Error(t, err)
+	assert.False(t, tableExists(t, db, "ok"))
+
+	statuses, err := m.Status()
+	assert.Nil(t, err)
+	assert.False(t, statuses[0].Applied)
+}
+
+func TestMissingDownFile(t *testing.T) {
+	db := newTestDB(t)
+	source := fstest.MapFS{
+		"0001_create_people.up.sql": {Data: []byte(`CREATE TABLE people (id INTEGER PRIMARY KEY);`)},
+	}
+	_, err := NewMigrator(db, dialect.SQLite, source)
+	assert.Error(t, err)
+}
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS users (
+    id BIGSERIAL NOT NULL PRIMARY KEY,
+    first_name TEXT NOT NULL,
+    last_name TEXT NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP INDEX users_last_name_idx;
+DROP INDEX users_first_name_idx;
@@ -0,0 +1,20 @@
+-- Full-text search over user names. unaccent makes matching
+-- diacritic-insensitive; it is not immutable, so the vector is maintained by
+-- a trigger rather than a generated column.
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+ALTER TABLE users ADD COLUMN search tsvector;
+
+CREATE FUNCTION users_search_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search := to_tsvector('simple', unaccent(NEW.first_name || ' ' || NEW.last_name));
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_search_update BEFORE INSERT OR UPDATE ON users
+    FOR EACH ROW EXECUTE PROCEDURE users_search_update();
+
+UPDATE users SET search = to_tsvector('simple', unaccent(first_name || ' ' || last_name));
+
+CREATE INDEX users_search_idx ON users USING GIN (search);
@@ -0,0 +1,23 @@
+-- Append-only history of user changes. There is no foreign key to users so
+-- that the history outlives purged users.
+CREATE TABLE audit_events (
+    id BIGSERIAL NOT NULL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    operation TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    request_id TEXT NOT NULL,
+    occurred_at TIMESTAMPTZ NOT NULL,
+    changes JSONB NOT NULL
+);
+
+CREATE INDEX audit_events_user_id_idx ON audit_events (user_id, id);
+CREATE INDEX audit_events_occurred_at_idx ON audit_events (occurred_at, id);
+
+CREATE FUNCTION audit_events_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit events are immutable';
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_immutable BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE PROCEDURE audit_events_immutable();
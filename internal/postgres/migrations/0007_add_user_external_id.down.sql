@@ -0,0 +1,2 @@
+DROP INDEX users_external_id_idx;
+ALTER TABLE users DROP COLUMN external_id;
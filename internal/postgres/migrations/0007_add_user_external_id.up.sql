@@ -0,0 +1,5 @@
+-- Key of the user in an external system, used to upsert imports. Deleted
+-- users keep theirs until purged.
+ALTER TABLE users ADD COLUMN external_id TEXT;
+
+CREATE UNIQUE INDEX users_external_id_idx ON users (external_id) WHERE external_id IS NOT NULL;
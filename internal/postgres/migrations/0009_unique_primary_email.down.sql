@@ -0,0 +1 @@
+DROP INDEX user_emails_primary_address_idx;
@@ -0,0 +1,5 @@
+-- The user each user reports to. Purging a manager clears the column of
+-- their reports in the same transaction.
+ALTER TABLE users ADD COLUMN manager_id BIGINT REFERENCES users (id);
+
+CREATE INDEX users_manager_id_idx ON users (manager_id);
@@ -0,0 +1,24 @@
+-- Groups of users, which may also contain other groups.
+CREATE TABLE groups (
+    id BIGSERIAL NOT NULL PRIMARY KEY,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX groups_name_idx ON groups (lower(name));
+
+CREATE TABLE group_users (
+    group_id BIGINT NOT NULL REFERENCES groups (id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX group_users_user_id_idx ON group_users (user_id);
+
+CREATE TABLE group_subgroups (
+    group_id BIGINT NOT NULL REFERENCES groups (id) ON DELETE CASCADE,
+    subgroup_id BIGINT NOT NULL REFERENCES groups (id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, subgroup_id)
+);
+
+CREATE INDEX group_subgroups_subgroup_id_idx ON group_subgroups (subgroup_id);
@@ -0,0 +1,13 @@
+-- Tags label users. Their names are stored in lower case.
+CREATE TABLE tags (
+    id BIGSERIAL NOT NULL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE user_tags (
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    tag_id BIGINT NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, tag_id)
+);
+
+CREATE INDEX user_tags_tag_id_idx ON user_tags (tag_id);
@@ -0,0 +1,65 @@
+package postgres
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"io/fs"
+
+	"github.com/lib/pq"
+	"github.com/pmaterer/peopler/internal/dialect"
+	"github.com/pmaterer/peopler/internal/migrate"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// NewPostgresHandler connects to the database and applies any pending
+// migrations.
+func NewPostgresHandler(dsn string) (*sql.DB, error) {
+	db, err := Open(dsn)
+	if err != nil {
+		return db, err
+	}
+	m, err := NewMigrator(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := m.Up(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Open connects to the database without touching its schema.
+func Open(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return db, err
+	}
+	return db, nil
+}
+
+func NewMigrator(db *sql.DB) (*migrate.Migrator, error) {
+	source, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return migrate.NewMigrator(db, dialect.Postgres, source)
+}
+
+func PingDB(db *sql.DB) error {
+	err := db.Ping()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// IsUniqueViolation reports whether err is the violation of a unique index.
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -0,0 +1,131 @@
+// Package problem renders errors as RFC 7807 application/problem+json
+// documents.
+package problem
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/pmaterer/peopler/domain"
+)
+
+const (
+	ContentType = "application/problem+json"
+
+	// RequestIDHeader carries the correlation ID between clients, logs and
+	// problem documents. An incoming value is reused, otherwise one is
+	// generated.
+	RequestIDHeader = "X-Request-ID"
+
+	maxRequestIDLength = 128
+)
+
+type Problem struct {
+	Type     string              `json:"type"`
+	Title    string              `json:"title"`
+	Status   int                 `json:"status"`
+	Detail   string              `json:"detail,omitempty"`
+	Instance string              `json:"instance,omitempty"`
+	Errors   []domain.FieldError `json:"errors,omitempty"`
+	// RequestID is an extension member clients can quote when reporting
+	// internal errors.
+	RequestID string `json:"requestId,omitempty"`
+	// ConflictingID is an extension member naming the resource that already
+	// holds a unique value the request asked for.
+	ConflictingID int64 `json:"conflictingId,omitempty"`
+}
+
+// Write renders err with the given status. The detail of server errors is
+// logged with the request ID and never sent to the client.
+func Write(w http.ResponseWriter, r *http.Request, status int, err error) {
+	id := RequestID(r)
+	p := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Instance:  r.URL.Path,
+		RequestID: id,
+	}
+
+	if status >= http.StatusInternalServerError {
+		log.Printf("request %s: %s %s: %v\n", id, r.Method, r.URL.Path, err)
+		p.Detail = "The server encountered an internal error. Quote the request ID when reporting it."
+		if status == http.StatusServiceUnavailable {
+			p.Detail = "The request did not complete in time. Retry it later, and quote the request ID if it keeps failing."
+		}
+	} else if err != nil {
+		p.Detail = err.Error()
+		var validationErr *domain.ValidationError
+		if errors.As(err, &validationErr) {
+			p.Errors = validationErr.Fields
+		}
+		var conflictErr *domain.ConflictError
+		if errors.As(err, &conflictErr) {
+			p.Errors = []domain.FieldError{{Field: conflictErr.Field, Message: "is already in use"}}
+			p.ConflictingID = conflictErr.ID
+		}
+	}
+
+	response, _ := json.Marshal(p)
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set(RequestIDHeader, id)
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
+// WriteError writes err with the status StatusOf maps it to.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	Write(w, r, StatusOf(err), err)
+}
+
+// StatusOf maps the domain error kinds to HTTP statuses. A request whose
+// context ran out or was cancelled is unavailable; anything unrecognised is
+// an internal error.
+func StatusOf(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, domain.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, domain.ErrValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, domain.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, domain.ErrNotApplied):
+		return http.StatusFailedDependency
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// AssignRequestID makes sure every request carries a request ID, generating
+// one if needed, and echoes it in the response. Handlers and Write then all
+// see the same ID.
+func AssignRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := RequestID(r)
+		r.Header.Set(RequestIDHeader, id)
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequestID returns the correlation ID sent by the client, or a new random
+// one if it sent none or an unreasonably long one.
+func RequestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); id != "" && len(id) <= maxRequestIDLength {
+		return id
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,127 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func decode(t *testing.T, rr *httptest.ResponseRecorder) Problem {
+	var p Problem
+	err := json.NewDecoder(rr.Result().Body).Decode(&p)
+	assert.Nil(t, err)
+	return p
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		statusCode int
+	}{
+		{"Not found", fmt.Errorf("user #1: %w", domain.ErrNotFound), http.StatusNotFound},
+		{"Conflict", fmt.Errorf("user #1: %w", domain.ErrConflict), http.StatusConflict},
+		{"Precondition failed", fmt.Errorf("user #1: %w", domain.ErrPreconditionFailed), http.StatusPreconditionFailed},
+		{"Validation", &domain.ValidationError{}, http.StatusUnprocessableEntity},
+		{"Not applied", fmt.Errorf("operation 2: %w", domain.ErrNotApplied), http.StatusFailedDependency},
+		{"Timeout", fmt.Errorf("listing users: %w", context.DeadlineExceeded), http.StatusServiceUnavailable},
+		{"Cancelled", context.Canceled, http.StatusServiceUnavailable},
+		{"Other", errors.New("bad stuff"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/user/1", nil)
+			rr := httptest.NewRecorder()
+
+			WriteError(rr, req, tt.err)
+
+			assert.Equal(t, tt.statusCode, rr.Result().StatusCode)
+			assert.Equal(t, ContentType, rr.Result().Header.Get("Content-Type"))
+
+			p := decode(t, rr)
+			assert.Equal(t, "about:blank", p.Type)
+			assert.Equal(t, http.StatusText(tt.statusCode), p.Title)
+			assert.Equal(t, tt.statusCode, p.Status)
+			assert.Equal(t, "/user/1", p.Instance)
+			assert.NotEmpty(t, p.RequestID)
+		})
+	}
+}
+
+func TestInternalErrorDetailIsHidden(t *testing.T) {
+	req := httptest.NewRequest("GET", "/users", nil)
+	req.Header.Set(RequestIDHeader, "abc123")
+	rr := httptest.NewRecorder()
+
+	Write(rr, req, http.StatusInternalServerError, errors.New("no such table: users"))
+
+	p := decode(t, rr)
+	assert.NotContains(t, p.Detail, "no such table")
+	assert.Equal(t, "abc123", p.RequestID)
+	assert.Equal(t, "abc123", rr.Result().Header.Get(RequestIDHeader))
+}
+
+func TestValidationErrorFields(t *testing.T) {
+	req := httptest.NewRequest("POST", "/user", nil)
+	rr := httptest.NewRecorder()
+	err := fmt.Errorf("creating user: %w", &domain.ValidationError{
+		Fields: []domain.FieldError{
+			{Field: "firstName", Message: "is required"},
+			{Field: "lastName", Message: "is required"},
+		},
+	})
+
+	WriteError(rr, req, err)
+
+	p := decode(t, rr)
+	assert.Equal(t, http.StatusUnprocessableEntity, p.Status)
+	assert.Equal(t, []domain.FieldError{
+		{Field: "firstName", Message: "is required"},
+		{Field: "lastName", Message: "is required"},
+	}, p.Errors)
+	assert.Contains(t, p.Detail, "firstName is required")
+}
+
+func TestConflictErrorFields(t *testing.T) {
+	req := httptest.NewRequest("POST", "/user", nil)
+	rr := httptest.NewRecorder()
+	err := fmt.Errorf("creating user: %w", &domain.ConflictError{Field: "emails[0].address", Value: "shane@example.com", ID: 7})
+
+	WriteError(rr, req, err)
+
+	p := decode(t, rr)
+	assert.Equal(t, http.StatusConflict, p.Status)
+	assert.Equal(t, []domain.FieldError{{Field: "emails[0].address", Message: "is already in use"}}, p.Errors)
+	assert.Equal(t, int64(7), p.ConflictingID)
+	assert.Equal(t, `creating user: emails[0].address "shane@example.com" is already in use by #7: conflict`, p.Detail)
+}
+
+func TestAssignRequestID(t *testing.T) {
+	var seen string
+	handler := AssignRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r)
+		Write(w, r, http.StatusNotFound, nil)
+	}))
+
+	req := httptest.NewRequest("GET", "/user/1", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.NotEmpty(t, seen)
+	assert.Equal(t, seen, rr.Result().Header.Get(RequestIDHeader))
+	assert.Equal(t, seen, decode(t, rr).RequestID)
+
+	req = httptest.NewRequest("GET", "/user/1", nil)
+	req.Header.Set(RequestIDHeader, "abc123")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, "abc123", seen)
+	assert.Equal(t, "abc123", rr.Result().Header.Get(RequestIDHeader))
+}
@@ -0,0 +1,2 @@
+CREATE INDEX users_first_name_idx ON users (first_name, id);
+CREATE INDEX users_last_name_idx ON users (last_name, id);
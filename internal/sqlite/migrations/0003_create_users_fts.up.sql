@@ -0,0 +1,24 @@
+-- Full-text index over user names. Requires a driver built with FTS5
+-- (go build -tags sqlite_fts5).
+CREATE VIRTUAL TABLE users_fts USING fts5(
+    first_name,
+    last_name,
+    content='users',
+    content_rowid='id',
+    tokenize='unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER users_fts_insert AFTER INSERT ON users BEGIN
+    INSERT INTO users_fts(rowid, first_name, last_name) VALUES (new.id, new.first_name, new.last_name);
+END;
+
+CREATE TRIGGER users_fts_delete AFTER DELETE ON users BEGIN
+    INSERT INTO users_fts(users_fts, rowid, first_name, last_name) VALUES ('delete', old.id, old.first_name, old.last_name);
+END;
+
+CREATE TRIGGER users_fts_update AFTER UPDATE ON users BEGIN
+    INSERT INTO users_fts(users_fts, rowid, first_name, last_name) VALUES ('delete', old.id, old.first_name, old.last_name);
+    INSERT INTO users_fts(rowid, first_name, last_name) VALUES (new.id, new.first_name, new.last_name);
+END;
+
+INSERT INTO users_fts(users_fts) VALUES ('rebuild');
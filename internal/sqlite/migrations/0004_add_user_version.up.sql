@@ -0,0 +1,2 @@
+-- Incremented by every update, for optimistic concurrency control.
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
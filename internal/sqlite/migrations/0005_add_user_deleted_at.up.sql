@@ -0,0 +1,4 @@
+-- Deleted users keep their row until purged; see Repository.PurgeUsers.
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
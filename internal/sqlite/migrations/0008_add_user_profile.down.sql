@@ -0,0 +1,6 @@
+DROP TABLE user_phones;
+DROP TABLE user_emails;
+ALTER TABLE users DROP COLUMN start_date;
+ALTER TABLE users DROP COLUMN employee_number;
+ALTER TABLE users DROP COLUMN department;
+ALTER TABLE users DROP COLUMN title;
@@ -0,0 +1,25 @@
+-- Profile fields, and the user's emails and phones in the order given. The
+-- foreign keys document the relationship; SQLite does not enforce them
+-- unless asked to, so purges delete the contacts themselves.
+ALTER TABLE users ADD COLUMN title TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN department TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN employee_number TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN start_date TEXT;
+
+CREATE TABLE user_emails (
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    address TEXT NOT NULL,
+    type TEXT NOT NULL,
+    is_primary BOOLEAN NOT NULL,
+    PRIMARY KEY (user_id, position)
+);
+
+CREATE TABLE user_phones (
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    number TEXT NOT NULL,
+    type TEXT NOT NULL,
+    is_primary BOOLEAN NOT NULL,
+    PRIMARY KEY (user_id, position)
+);
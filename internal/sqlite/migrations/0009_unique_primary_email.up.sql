@@ -0,0 +1,3 @@
+-- No two users, deleted or not, may share a primary email, whatever its
+-- case. Duplicates have to be resolved before this migration can apply.
+CREATE UNIQUE INDEX user_emails_primary_address_idx ON user_emails (lower(address)) WHERE is_primary;
@@ -0,0 +1,6 @@
+-- The user each user reports to. Purging a manager clears the column of
+-- their reports in the same transaction, as SQLite does not enforce the
+-- foreign key.
+ALTER TABLE users ADD COLUMN manager_id INTEGER REFERENCES users (id);
+
+CREATE INDEX users_manager_id_idx ON users (manager_id);
@@ -0,0 +1,26 @@
+-- Groups of users, which may also contain other groups. As for contacts,
+-- SQLite does not enforce the foreign keys, so deletes and purges remove the
+-- memberships themselves.
+CREATE TABLE groups (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX groups_name_idx ON groups (lower(name));
+
+CREATE TABLE group_users (
+    group_id INTEGER NOT NULL REFERENCES groups (id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX group_users_user_id_idx ON group_users (user_id);
+
+CREATE TABLE group_subgroups (
+    group_id INTEGER NOT NULL REFERENCES groups (id) ON DELETE CASCADE,
+    subgroup_id INTEGER NOT NULL REFERENCES groups (id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, subgroup_id)
+);
+
+CREATE INDEX group_subgroups_subgroup_id_idx ON group_subgroups (subgroup_id);
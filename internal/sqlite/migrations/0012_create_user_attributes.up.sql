@@ -0,0 +1,21 @@
+-- Attributes an administrator defines for every user, and their values. As
+-- for contacts, SQLite does not enforce the foreign key, so purges remove the
+-- values themselves. The enum values of a definition are a JSON array.
+CREATE TABLE attribute_definitions (
+    name TEXT NOT NULL PRIMARY KEY,
+    type TEXT NOT NULL,
+    required BOOLEAN NOT NULL DEFAULT FALSE,
+    enum_values TEXT NOT NULL DEFAULT '[]',
+    pattern TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE user_attributes (
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name TEXT NOT NULL REFERENCES attribute_definitions (name) ON DELETE CASCADE,
+    value TEXT NOT NULL,
+    PRIMARY KEY (user_id, name)
+);
+
+-- Listings filter by the value of an attribute.
+CREATE INDEX user_attributes_name_value_idx ON user_attributes (name, value);
@@ -0,0 +1,2 @@
+DROP TABLE user_tags;
+DROP TABLE tags;
@@ -0,0 +1,15 @@
+-- Tags label users. Their names are stored in lower case. As for contacts,
+-- SQLite does not enforce the foreign keys, so purges remove the links
+-- themselves.
+CREATE TABLE tags (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE user_tags (
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, tag_id)
+);
+
+CREATE INDEX user_tags_tag_id_idx ON user_tags (tag_id);
@@ -2,11 +2,51 @@ package sqlite
 
 import (
 	"database/sql"
+	"embed"
+	"errors"
+	"io/fs"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pmaterer/peopler/internal/dialect"
+	"github.com/pmaterer/peopler/internal/migrate"
 )
 
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// ErrFTS5Unavailable is returned when the binary was built without the FTS5
+// extension the schema depends on.
+var ErrFTS5Unavailable = errors.New("sqlite: FTS5 is not available, build with -tags sqlite_fts5")
+
+// NewSQLiteHandler opens the database and applies any pending migrations.
 func NewSQLiteHandler(dbFilename string) (*sql.DB, error) {
+	db, err := Open(dbFilename)
+	if err != nil {
+		return db, err
+	}
+	enabled, err := FTS5Enabled(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if !enabled {
+		db.Close()
+		return nil, ErrFTS5Unavailable
+	}
+	m, err := NewMigrator(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := m.Up(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Open opens the database without touching its schema.
+func Open(dbFilename string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite3", dbFilename)
 	if err != nil {
 		return db, err
@@ -14,6 +54,24 @@ func NewSQLiteHandler(dbFilename string) (*sql.DB, error) {
 	return db, nil
 }
 
+// FTS5Enabled reports whether the linked SQLite was compiled with FTS5.
+func FTS5Enabled(db *sql.DB) (bool, error) {
+	var enabled bool
+	err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&enabled)
+	if err != nil {
+		return false, err
+	}
+	return enabled, nil
+}
+
+func NewMigrator(db *sql.DB) (*migrate.Migrator, error) {
+	source, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return migrate.NewMigrator(db, dialect.SQLite, source)
+}
+
 func PingDB(db *sql.DB) error {
 	err := db.Ping()
 	if err != nil {
@@ -21,3 +79,19 @@ func PingDB(db *sql.DB) error {
 	}
 	return nil
 }
+
+// IsBusy reports whether err means another connection held a lock the
+// statement needed. Retrying the whole transaction may then succeed.
+func IsBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+}
+
+// IsUniqueViolation reports whether err is the violation of a unique index.
+func IsUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
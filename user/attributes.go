@@ -0,0 +1,307 @@
+package user
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pmaterer/peopler/domain"
+)
+
+// Attribute types, named as in the JSON contract. Attribute values are
+// strings whatever their type, which decides the strings allowed.
+const (
+	AttributeString  = "string"
+	AttributeInteger = "integer"
+	AttributeNumber  = "number"
+	AttributeBoolean = "boolean"
+	// AttributeDate values are dates such as 2021-03-15.
+	AttributeDate = "date"
+)
+
+const (
+	// MaxAttributeNameLength is the longest attribute name accepted, in
+	// characters.
+	MaxAttributeNameLength = 64
+	// MaxAttributeValueLength is the longest attribute value accepted, in
+	// characters.
+	MaxAttributeValueLength = 500
+	// MaxAttributeEnumValues is the most values an attribute may be limited
+	// to.
+	MaxAttributeEnumValues = 100
+	// MaxAttributePatternLength is the longest attribute pattern accepted, in
+	// characters.
+	MaxAttributePatternLength = 500
+	// MaxAttributeDescriptionLength is the longest attribute description
+	// accepted, in characters.
+	MaxAttributeDescriptionLength = 500
+)
+
+// attributeName matches the names attributes may have, which have to stand
+// in query parameters and CSV headers as attr.<name>.
+var attributeName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+// AttributeDefinition declares a custom attribute of users, which an
+// administrator defines for the deployment. The values of the attribute must
+// suit Type and, if set, be one of Enum and match Pattern.
+type AttributeDefinition struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+	// Enum lists the only values allowed, if any.
+	Enum []string `json:"enum,omitempty"`
+	// Pattern is a regular expression, in RE2 syntax, that must match the
+	// whole value.
+	Pattern     string `json:"pattern,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Normalize trims the definition, makes string the default type and puts the
+// enum values in the canonical form of the type, as for user attributes.
+func (d *AttributeDefinition) Normalize() {
+	d.Name = strings.TrimSpace(d.Name)
+	d.Type = strings.ToLower(strings.TrimSpace(d.Type))
+	if d.Type == "" {
+		d.Type = AttributeString
+	}
+	d.Description = normalizeString(d.Description)
+	for i, value := range d.Enum {
+		d.Enum[i] = canonicalValue(d.Type, value)
+	}
+	if len(d.Enum) == 0 {
+		d.Enum = nil
+	}
+}
+
+// Validate reports every field of the definition that breaks the rules as a
+// *domain.ValidationError. Call Normalize first.
+func (d AttributeDefinition) Validate() error {
+	var fields []domain.FieldError
+	switch {
+	case d.Name == "":
+		fields = append(fields, domain.FieldError{Field: "name", Message: "is required"})
+	case len(d.Name) > MaxAttributeNameLength:
+		fields = append(fields, domain.FieldError{Field: "name", Message: fmt.Sprintf("must be at most %d characters", MaxAttributeNameLength)})
+	case !attributeName.MatchString(d.Name):
+		fields = append(fields, domain.FieldError{Field: "name", Message: "must start with a letter and contain only letters, digits, dashes and underscores"})
+	}
+	switch d.Type {
+	case AttributeString, AttributeInteger, AttributeNumber, AttributeBoolean, AttributeDate:
+	default:
+		fields = append(fields, domain.FieldError{Field: "type", Message: "must be one of " + choices([]string{AttributeString, AttributeInteger, AttributeNumber, AttributeBoolean, AttributeDate})})
+	}
+
+	if len(d.Enum) > MaxAttributeEnumValues {
+		fields = append(fields, domain.FieldError{Field: "enum", Message: fmt.Sprintf("must have at most %d entries", MaxAttributeEnumValues)})
+	} else {
+		seen := map[string]bool{}
+		for i, value := range d.Enum {
+			field := fmt.Sprintf("enum[%d]", i)
+			if value == "" {
+				fields = append(fields, domain.FieldError{Field: field, Message: "is required"})
+				continue
+			}
+			fields = appendAttributeValueErrors(fields, field, d.Type, value)
+			if seen[value] {
+				fields = append(fields, domain.FieldError{Field: field, Message: "is listed more than once"})
+			}
+			seen[value] = true
+		}
+	}
+
+	if len(d.Pattern) > MaxAttributePatternLength {
+		fields = append(fields, domain.FieldError{Field: "pattern", Message: fmt.Sprintf("must be at most %d characters", MaxAttributePatternLength)})
+	} else if _, err := d.pattern(); err != nil {
+		fields = append(fields, domain.FieldError{Field: "pattern", Message: "must be a valid regular expression"})
+	}
+	fields = appendTextErrors(fields, "description", d.Description, MaxAttributeDescriptionLength)
+	if len(fields) > 0 {
+		return &domain.ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// pattern compiles Pattern so that it has to match the whole value. It
+// returns nil if there is no pattern.
+func (d AttributeDefinition) pattern() (*regexp.Regexp, error) {
+	if d.Pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(`^(?:` + d.Pattern + `)$`)
+}
+
+// CheckAttributes puts the attribute values of u in the canonical form of
+// their types, so that equal values are stored and filtered on identically,
+// and reports the attributes that break defs as a *domain.ValidationError.
+// Call Normalize and Validate first.
+func (u *User) CheckAttributes(defs []AttributeDefinition) error {
+	byName := make(map[string]AttributeDefinition, len(defs))
+	for _, d := range defs {
+		byName[d.Name] = d
+	}
+	fields := checkAttributeValues(byName, u.Attributes, "attributes.")
+	for _, d := range defs {
+		if _, ok := u.Attributes[d.Name]; d.Required && !ok {
+			fields = append(fields, domain.FieldError{Field: "attributes." + d.Name, Message: "is required"})
+		}
+	}
+	if len(fields) > 0 {
+		return &domain.ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// CheckAttributeFilter puts the values of a listing's attribute filter in
+// canonical form, as CheckAttributes does for users, and reports the ones
+// that could never match as a *domain.ValidationError on attr.<name>.
+func CheckAttributeFilter(defs []AttributeDefinition, filter map[string]string) error {
+	byName := make(map[string]AttributeDefinition, len(defs))
+	for _, d := range defs {
+		byName[d.Name] = d
+	}
+	if fields := checkAttributeValues(byName, filter, "attr."); len(fields) > 0 {
+		return &domain.ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// checkAttributeValues canonicalises values in place and checks them
+// against their definitions, in order of name. The errors are reported on
+// prefix followed by the name.
+func checkAttributeValues(defs map[string]AttributeDefinition, values map[string]string, prefix string) []domain.FieldError {
+	var fields []domain.FieldError
+	for _, name := range attributeNames(values) {
+		field := prefix + name
+		d, ok := defs[name]
+		if !ok {
+			fields = append(fields, domain.FieldError{Field: field, Message: "is not a defined attribute"})
+			continue
+		}
+		value := canonicalValue(d.Type, values[name])
+		values[name] = value
+		if errs := appendAttributeValueErrors(nil, field, d.Type, value); len(errs) > 0 {
+			fields = append(fields, errs...)
+			continue
+		}
+		if len(d.Enum) > 0 && !contains(d.Enum, value) {
+			fields = append(fields, domain.FieldError{Field: field, Message: "must be one of " + choices(d.Enum)})
+			continue
+		}
+		// A stored pattern always compiles, as it was validated.
+		if pattern, _ := d.pattern(); pattern != nil && !pattern.MatchString(value) {
+			fields = append(fields, domain.FieldError{Field: field, Message: fmt.Sprintf("must match %s", d.Pattern)})
+		}
+	}
+	return fields
+}
+
+// normalizeAttributes trims the attributes of a user and drops the empty
+// ones, as an empty value leaves the attribute unset.
+func normalizeAttributes(attributes map[string]string) map[string]string {
+	if len(attributes) == 0 {
+		return nil
+	}
+	normalized := make(map[string]string, len(attributes))
+	for name, value := range attributes {
+		if value = normalizeString(value); value != "" {
+			normalized[strings.TrimSpace(name)] = value
+		}
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+	return normalized
+}
+
+// appendAttributeErrors checks the syntax of the attributes of a user, which
+// CheckAttributes then checks against their definitions.
+func appendAttributeErrors(fields []domain.FieldError, attributes map[string]string) []domain.FieldError {
+	for _, name := range attributeNames(attributes) {
+		field := "attributes." + name
+		if !attributeName.MatchString(name) || len(name) > MaxAttributeNameLength {
+			fields = append(fields, domain.FieldError{Field: field, Message: "is not a valid attribute name"})
+			continue
+		}
+		fields = appendTextErrors(fields, field, attributes[name], MaxAttributeValueLength)
+	}
+	return fields
+}
+
+// appendAttributeValueErrors checks that value, in canonical form, is of the
+// type.
+func appendAttributeValueErrors(fields []domain.FieldError, field, typ, value string) []domain.FieldError {
+	var message string
+	switch typ {
+	case AttributeInteger:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			message = "must be an integer"
+		}
+	case AttributeNumber:
+		if f, err := strconv.ParseFloat(value, 64); err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			message = "must be a number"
+		}
+	case AttributeBoolean:
+		if value != "true" && value != "false" {
+			message = "must be true or false"
+		}
+	case AttributeDate:
+		if _, err := time.Parse(dateLayout, value); err != nil {
+			message = "must be a date such as 2021-03-15"
+		}
+	}
+	if message != "" {
+		return append(fields, domain.FieldError{Field: field, Message: message})
+	}
+	return fields
+}
+
+// canonicalValue writes the value the one way its type allows, leaving it
+// as it is if it is not of the type.
+func canonicalValue(typ, value string) string {
+	value = normalizeString(value)
+	switch typ {
+	case AttributeInteger:
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return strconv.FormatInt(i, 10)
+		}
+	case AttributeNumber:
+		if f, err := strconv.ParseFloat(value, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+	case AttributeBoolean:
+		if b, err := strconv.ParseBool(strings.ToLower(value)); err == nil {
+			return strconv.FormatBool(b)
+		}
+	}
+	return value
+}
+
+func attributeNames(attributes map[string]string) []string {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// choices lists values as "a, b or c".
+func choices(values []string) string {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return strings.Join(values[:len(values)-1], ", ") + " or " + values[len(values)-1]
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
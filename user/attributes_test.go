@@ -0,0 +1,111 @@
+package user
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttributeDefinitionValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition AttributeDefinition
+		fields     []domain.FieldError
+	}{
+		{
+			name:       "Valid definition",
+			definition: AttributeDefinition{Name: "desk", Pattern: "[0-9]+[A-Z]", Description: "Where to find them"},
+		},
+		{
+			name:       "Enum of integers",
+			definition: AttributeDefinition{Name: "floor", Type: "Integer", Enum: []string{" 1", "+2", "03"}},
+		},
+		{
+			name:       "Missing name",
+			definition: AttributeDefinition{},
+			fields: []domain.FieldError{
+				{Field: "name", Message: "is required"},
+			},
+		},
+		{
+			name:       "Malformed definition",
+			definition: AttributeDefinition{Name: "desk number", Type: "text", Pattern: "[0-9"},
+			fields: []domain.FieldError{
+				{Field: "name", Message: "must start with a letter and contain only letters, digits, dashes and underscores"},
+				{Field: "type", Message: "must be one of string, integer, number, boolean or date"},
+				{Field: "pattern", Message: "must be a valid regular expression"},
+			},
+		},
+		{
+			name:       "Bad enum values",
+			definition: AttributeDefinition{Name: "floor", Type: AttributeInteger, Enum: []string{"1", "one", "01", ""}},
+			fields: []domain.FieldError{
+				{Field: "enum[1]", Message: "must be an integer"},
+				{Field: "enum[2]", Message: "is listed more than once"},
+				{Field: "enum[3]", Message: "is required"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.definition.Normalize()
+			err := tt.definition.Validate()
+			if tt.fields == nil {
+				assert.Nil(t, err)
+				return
+			}
+			var validationErr *domain.ValidationError
+			assert.True(t, errors.As(err, &validationErr))
+			assert.Equal(t, tt.fields, validationErr.Fields)
+		})
+	}
+}
+
+func TestCheckAttributes(t *testing.T) {
+	defs := []AttributeDefinition{
+		{Name: "desk", Type: AttributeString, Pattern: "[0-9]+[A-Z]"},
+		{Name: "floor", Type: AttributeInteger},
+		{Name: "height", Type: AttributeNumber},
+		{Name: "remote", Type: AttributeBoolean},
+		{Name: "reviewed", Type: AttributeDate},
+		{Name: "shirtSize", Type: AttributeString, Required: true, Enum: []string{"S", "M", "L"}},
+	}
+
+	u := User{
+		FirstName: "Herman", LastName: "Melville",
+		Attributes: map[string]string{"desk": " 4B ", "floor": "+04", "height": "1.80", "remote": "TRUE", "shirtSize": "M", "notes": ""},
+	}
+	u.Normalize()
+	assert.Nil(t, u.Validate())
+	assert.Nil(t, u.CheckAttributes(defs))
+	assert.Equal(t, map[string]string{"desk": "4B", "floor": "4", "height": "1.8", "remote": "true", "shirtSize": "M"}, u.Attributes)
+
+	u = User{Attributes: map[string]string{"desk": "B4", "floor": "four", "height": "tall", "remote": "yes", "reviewed": "last week", "github": "hmelville"}}
+	u.Normalize()
+	var validationErr *domain.ValidationError
+	assert.True(t, errors.As(u.CheckAttributes(defs), &validationErr))
+	assert.Equal(t, []domain.FieldError{
+		{Field: "attributes.desk", Message: "must match [0-9]+[A-Z]"},
+		{Field: "attributes.floor", Message: "must be an integer"},
+		{Field: "attributes.github", Message: "is not a defined attribute"},
+		{Field: "attributes.height", Message: "must be a number"},
+		{Field: "attributes.remote", Message: "must be true or false"},
+		{Field: "attributes.reviewed", Message: "must be a date such as 2021-03-15"},
+		{Field: "attributes.shirtSize", Message: "is required"},
+	}, validationErr.Fields)
+
+	u = User{Attributes: map[string]string{"shirtSize": "XL"}}
+	assert.True(t, errors.As(u.CheckAttributes(defs), &validationErr))
+	assert.Equal(t, []domain.FieldError{
+		{Field: "attributes.shirtSize", Message: "must be one of S, M or L"},
+	}, validationErr.Fields)
+
+	filter := map[string]string{"remote": "1"}
+	assert.Nil(t, CheckAttributeFilter(defs, filter))
+	assert.Equal(t, map[string]string{"remote": "true"}, filter)
+	assert.True(t, errors.As(CheckAttributeFilter(defs, map[string]string{"floor": "top"}), &validationErr))
+	assert.Equal(t, []domain.FieldError{{Field: "attr.floor", Message: "must be an integer"}}, validationErr.Fields)
+}
@@ -0,0 +1,355 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/domain"
+)
+
+const (
+	// attributeFieldPrefix starts the names of attributes among the Fields
+	// of a BatchOperation, as in attr.desk.
+	attributeFieldPrefix = "attr."
+
+	// MaxBatchSize is the most operations a single batch may contain.
+	MaxBatchSize = 1000
+	// MaxImportSize is the most users a single CSV import may contain. NDJSON
+	// imports are streamed and have no limit unless they are atomic.
+	MaxImportSize = 10000
+	// MaxAtomicImportSize is the most users an atomic NDJSON import may
+	// contain, since it is read in full before anything is written.
+	MaxAtomicImportSize = 100000
+)
+
+// Batch operation kinds, named as in the JSON contract.
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+	// BatchUpsert updates the user with the same external ID as User, or
+	// creates it if there is none.
+	BatchUpsert = "upsert"
+)
+
+// BatchOperation is one item of a batch. ID and Version only apply to
+// updates and deletes, and User to everything but deletes. A non-zero
+// Version makes the operation conditional, like If-Match.
+type BatchOperation struct {
+	Op      string `json:"op"`
+	ID      int64  `json:"id,omitempty"`
+	Version int64  `json:"version,omitempty"`
+	User    User   `json:"user"`
+	// Fields, if set, names the only fields User holds, as for a CSV file
+	// with a column for some of them. An update, or an upsert that finds
+	// the user, then changes those fields and keeps the others; see Merge.
+	Fields []string `json:"-"`
+}
+
+// BatchResult is the outcome of one BatchOperation. Err is nil if it was
+// applied, and wraps domain.ErrNotApplied if it was rolled back because
+// another operation of an atomic batch failed.
+type BatchResult struct {
+	ID int64
+	// Created is set if the operation created the user.
+	Created bool
+	// Version is the version of the created or updated user.
+	Version int64
+	Err     error
+}
+
+// Normalize prepares the operation's user like User.Normalize.
+func (op *BatchOperation) Normalize() {
+	if op.Op != BatchDelete {
+		op.User.Normalize()
+	}
+}
+
+// Validate reports a malformed operation as a *domain.ValidationError. Call
+// Normalize first.
+func (op BatchOperation) Validate() error {
+	switch op.Op {
+	case BatchCreate, BatchUpsert:
+		return op.User.Validate()
+	case BatchUpdate:
+		if op.ID < 1 {
+			return &domain.ValidationError{Fields: []domain.FieldError{{Field: "id", Message: "is required"}}}
+		}
+		return op.User.Validate()
+	case BatchDelete:
+		if op.ID < 1 {
+			return &domain.ValidationError{Fields: []domain.FieldError{{Field: "id", Message: "is required"}}}
+		}
+		return nil
+	}
+	return &domain.ValidationError{Fields: []domain.FieldError{
+		{Field: "op", Message: fmt.Sprintf("must be one of %s, %s, %s or %s", BatchCreate, BatchUpdate, BatchDelete, BatchUpsert)},
+	}}
+}
+
+// Apply performs a validated operation on r, checking the manager of the
+// user as CheckManager does when it is set or changed. Repositories use it
+// to run each item of a batch within their transaction.
+func (op BatchOperation) Apply(ctx context.Context, r Repository, origin audit.Origin) BatchResult {
+	switch op.Op {
+	case BatchCreate:
+		if op.Fields != nil {
+			// The attributes of a partial user are left for here, where it
+			// is known whether they are all of the user's.
+			defs, err := r.AttributeDefinitions(ctx)
+			if err != nil {
+				return BatchResult{Err: err}
+			}
+			if err := op.User.CheckAttributes(defs); err != nil {
+				return BatchResult{Err: err}
+			}
+		}
+		if err := CheckManager(ctx, r, 0, op.User.ManagerID); err != nil {
+			return BatchResult{Err: err}
+		}
+		id, err := r.CreateUser(ctx, op.User, origin)
+		if err != nil {
+			return BatchResult{Err: err}
+		}
+		return BatchResult{ID: id, Created: true, Version: 1}
+	case BatchUpdate:
+		if op.Fields == nil || hasField(op.Fields, "managerId") {
+			current, err := r.GetUser(ctx, op.ID)
+			if err != nil {
+				return BatchResult{ID: op.ID, Err: err}
+			}
+			if !SameManager(current.ManagerID, op.User.ManagerID) {
+				if err := CheckManager(ctx, r, op.ID, op.User.ManagerID); err != nil {
+					return BatchResult{ID: op.ID, Err: err}
+				}
+			}
+		}
+		var defs []AttributeDefinition
+		if op.Fields != nil {
+			var err error
+			if defs, err = r.AttributeDefinitions(ctx); err != nil {
+				return BatchResult{ID: op.ID, Err: err}
+			}
+		}
+		updated, err := r.ModifyUser(ctx, op.ID, origin, func(current User) (User, error) {
+			if op.Version != 0 && op.Version != current.Version {
+				return current, fmt.Errorf("user #%d is no longer at version %d: %w", op.ID, op.Version, domain.ErrPreconditionFailed)
+			}
+			if op.Fields == nil {
+				return op.User, nil
+			}
+			merged := op.User.Merge(current, op.Fields)
+			merged.Normalize()
+			if err := merged.Validate(); err != nil {
+				return current, err
+			}
+			if err := merged.CheckAttributes(defs); err != nil {
+				return current, err
+			}
+			return merged, nil
+		})
+		if err != nil {
+			return BatchResult{ID: op.ID, Err: err}
+		}
+		return BatchResult{ID: op.ID, Version: updated.Version}
+	case BatchDelete:
+		_, err := r.DeleteUser(ctx, op.ID, op.Version, origin)
+		return BatchResult{ID: op.ID, Err: err}
+	case BatchUpsert:
+		if op.User.ExternalID != "" {
+			current, err := r.GetUserByExternalID(ctx, op.User.ExternalID)
+			if err == nil {
+				update := BatchOperation{Op: BatchUpdate, ID: current.ID, Version: op.Version, User: op.User, Fields: op.Fields}
+				return update.Apply(ctx, r, origin)
+			}
+			if !errors.Is(err, domain.ErrNotFound) {
+				return BatchResult{Err: err}
+			}
+		}
+		create := BatchOperation{Op: BatchCreate, User: op.User, Fields: op.Fields}
+		return create.Apply(ctx, r, origin)
+	}
+	return BatchResult{Err: op.Validate()}
+}
+
+// Merge returns current with the fields named in fields taken from u. The
+// fields are named as in JSON, except that email and phone stand for the
+// primary email and phone, whose other emails and phones are kept, and
+// attr.<name> stands for a single attribute. A field u leaves empty is
+// cleared.
+func (u User) Merge(current User, fields []string) User {
+	merged := current
+	merged.Attributes = make(map[string]string, len(current.Attributes))
+	for name, value := range current.Attributes {
+		merged.Attributes[name] = value
+	}
+	for _, field := range fields {
+		switch field {
+		case "externalId":
+			merged.ExternalID = u.ExternalID
+		case "firstName":
+			merged.FirstName = u.FirstName
+		case "lastName":
+			merged.LastName = u.LastName
+		case "title":
+			merged.Title = u.Title
+		case "department":
+			merged.Department = u.Department
+		case "employeeNumber":
+			merged.EmployeeNumber = u.EmployeeNumber
+		case "startDate":
+			merged.StartDate = u.StartDate
+		case "managerId":
+			merged.ManagerID = u.ManagerID
+		case "email":
+			primary, _ := u.PrimaryEmail()
+			merged.Emails = mergePrimaryEmail(current.Emails, primary.Address)
+		case "phone":
+			var number string
+			for _, p := range u.Phones {
+				if p.Primary {
+					number = p.Number
+				}
+			}
+			merged.Phones = mergePrimaryPhone(current.Phones, number)
+		default:
+			name := strings.TrimPrefix(field, attributeFieldPrefix)
+			if name == field {
+				continue
+			}
+			if value, ok := u.Attributes[name]; ok {
+				merged.Attributes[name] = value
+			} else {
+				delete(merged.Attributes, name)
+			}
+		}
+	}
+	if len(merged.Attributes) == 0 {
+		merged.Attributes = nil
+	}
+	return merged
+}
+
+// mergePrimaryEmail makes address the primary one of emails, in place of
+// the current primary, which keeps its position and type. An address that
+// is already there becomes primary where it is. An empty address removes
+// the primary.
+func mergePrimaryEmail(emails []Email, address string) []Email {
+	var exists bool
+	for _, e := range emails {
+		exists = exists || (address != "" && strings.EqualFold(e.Address, address))
+	}
+	var merged []Email
+	var placed bool
+	for _, e := range emails {
+		switch {
+		case address != "" && strings.EqualFold(e.Address, address):
+			e.Primary, placed = true, true
+		case e.Primary && (exists || address == ""):
+			continue
+		case e.Primary:
+			e.Address, placed = address, true
+		}
+		merged = append(merged, e)
+	}
+	if !placed && address != "" {
+		merged = append(merged, Email{Address: address, Primary: true})
+	}
+	return merged
+}
+
+// mergePrimaryPhone does for phones what mergePrimaryEmail does for emails.
+func mergePrimaryPhone(phones []Phone, number string) []Phone {
+	var exists bool
+	for _, p := range phones {
+		exists = exists || (number != "" && p.Number == number)
+	}
+	var merged []Phone
+	var placed bool
+	for _, p := range phones {
+		switch {
+		case number != "" && p.Number == number:
+			p.Primary, placed = true, true
+		case p.Primary && (exists || number == ""):
+			continue
+		case p.Primary:
+			p.Number, placed = number, true
+		}
+		merged = append(merged, p)
+	}
+	if !placed && number != "" {
+		merged = append(merged, Phone{Number: number, Primary: true})
+	}
+	return merged
+}
+
+// hasField reports whether fields names field.
+func hasField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// NotAppliedError is the error of an operation rolled back because another
+// one of its atomic batch failed. It matches domain.ErrNotApplied with
+// errors.Is.
+type NotAppliedError struct {
+	// Failed is the index of the operation that failed, from 0.
+	Failed int
+}
+
+// Error numbers the failed operation from 1, as it appears to clients of a
+// batch. Callers that number items otherwise, such as by CSV row, describe
+// it themselves.
+func (e *NotAppliedError) Error() string {
+	return fmt.Sprintf("operation %d of the atomic batch failed: %v", e.Failed+1, domain.ErrNotApplied)
+}
+
+func (e *NotAppliedError) Is(target error) bool {
+	return target == domain.ErrNotApplied
+}
+
+// AbortBatch marks every result of an atomic batch except the failed one as
+// not applied, once the batch has been rolled back.
+func AbortBatch(results []BatchResult, failed int) {
+	for i := range results {
+		if i != failed {
+			results[i] = BatchResult{Err: &NotAppliedError{Failed: failed}}
+		}
+	}
+}
+
+// ImportRecord is a user read by a streamed import, with the line of the
+// input it came from, for reporting it if it fails.
+type ImportRecord struct {
+	User User
+	Line int
+}
+
+// ImportSummary counts the outcomes of a streamed import, which does not
+// keep a result per user. Aborted is set if an atomic import stopped at its
+// first failure; nothing was saved then.
+type ImportSummary struct {
+	Created int
+	Updated int
+	Failed  int
+	Aborted bool
+}
+
+// Add counts the outcome of one user of the import.
+func (s *ImportSummary) Add(result BatchResult) {
+	switch {
+	case result.Err != nil:
+		s.Failed++
+	case result.Created:
+		s.Created++
+	default:
+		s.Updated++
+	}
+}
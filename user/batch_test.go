@@ -0,0 +1,55 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge(t *testing.T) {
+	manager := int64(7)
+	current := User{FirstName: "Stephen", LastName: "King", Title: "Author", ManagerID: &manager,
+		Emails: []Email{
+			{Address: "sk@example.com", Type: ContactHome},
+			{Address: "stephen@example.com", Type: ContactWork, Primary: true},
+		},
+		Phones:     []Phone{{Number: "+12075550100", Type: ContactWork, Primary: true}},
+		Attributes: map[string]string{"desk": "4B", "floor": "4"},
+	}
+
+	merged := User{LastName: "Kingsley", Attributes: map[string]string{"desk": "5C"}}.
+		Merge(current, []string{"lastName", "title", "managerId", "attr.desk", "attr.floor"})
+	assert.Equal(t, "Stephen", merged.FirstName)
+	assert.Equal(t, "Kingsley", merged.LastName)
+	assert.Equal(t, "", merged.Title, "an empty field is cleared")
+	assert.Nil(t, merged.ManagerID)
+	assert.Equal(t, current.Emails, merged.Emails)
+	assert.Equal(t, map[string]string{"desk": "5C"}, merged.Attributes)
+	assert.Equal(t, map[string]string{"desk": "4B", "floor": "4"}, current.Attributes, "current is left alone")
+
+	tests := []struct {
+		name    string
+		address string
+		emails  []Email
+	}{
+		{"Unchanged", "stephen@example.com", current.Emails},
+		{"Replaced", "king@example.com", []Email{
+			{Address: "sk@example.com", Type: ContactHome},
+			{Address: "king@example.com", Type: ContactWork, Primary: true},
+		}},
+		{"Promoted", "SK@example.com", []Email{{Address: "sk@example.com", Type: ContactHome, Primary: true}}},
+		{"Removed", "", []Email{{Address: "sk@example.com", Type: ContactHome}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var u User
+			if tt.address != "" {
+				u.Emails = []Email{{Address: tt.address, Primary: true}}
+			}
+			assert.Equal(t, tt.emails, u.Merge(current, []string{"email"}).Emails)
+		})
+	}
+
+	merged = User{Phones: []Phone{{Number: "+12075550199", Primary: true}}}.Merge(User{}, []string{"phone"})
+	assert.Equal(t, []Phone{{Number: "+12075550199", Primary: true}}, merged.Phones)
+}
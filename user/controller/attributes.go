@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pmaterer/peopler/internal/problem"
+	"github.com/pmaterer/peopler/user"
+)
+
+// attributeFilterPrefix starts the query parameters that filter listings by
+// attribute, as in attr.desk=4B.
+const attributeFilterPrefix = "attr."
+
+func (c *Controller) GetAttributeDefinitions() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defs, err := c.service.GetAttributeDefinitions(r.Context())
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		writeResponse(w, http.StatusOK, ListResponse{Data: defs, Total: int64(len(defs))})
+	}
+}
+
+func (c *Controller) GetAttributeDefinition() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, err := c.service.GetAttributeDefinition(r.Context(), mux.Vars(r)["name"])
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		writeResponse(w, http.StatusOK, d)
+	}
+}
+
+// PutAttributeDefinition defines the attribute named in the path, or
+// redefines it, answering 201 Created or 200 OK accordingly. A name in the
+// body is ignored.
+func (c *Controller) PutAttributeDefinition() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			problem.Write(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		var d user.AttributeDefinition
+		if err := json.Unmarshal(body, &d); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		d.Name = mux.Vars(r)["name"]
+		stored, created, err := c.service.PutAttributeDefinition(r.Context(), d)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		if !created {
+			writeResponse(w, http.StatusOK, stored)
+			return
+		}
+		w.Header().Set("Location", "/attribute/"+url.PathEscape(stored.Name))
+		writeResponse(w, http.StatusCreated, stored)
+	}
+}
+
+// DeleteAttributeDefinition removes the attribute, and its value from every
+// user.
+func (c *Controller) DeleteAttributeDefinition() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := c.service.DeleteAttributeDefinition(r.Context(), mux.Vars(r)["name"], origin(r)); err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		writeResponse(w, http.StatusOK, Response{Message: "OK"})
+	}
+}
+
+// parseAttributeFilter reads the attr.<name> query parameters of a listing.
+func parseAttributeFilter(query url.Values) map[string]string {
+	var filter map[string]string
+	for param := range query {
+		name := strings.TrimPrefix(param, attributeFilterPrefix)
+		if name == param {
+			continue
+		}
+		if filter == nil {
+			filter = map[string]string{}
+		}
+		filter[name] = query.Get(param)
+	}
+	return filter
+}
@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/internal/problem"
+)
+
+// ActorHeader names who makes a change, for the audit log. There is no
+// authentication yet, so it is taken on trust.
+const ActorHeader = "X-Actor"
+
+// origin identifies the actor and request behind a change.
+func origin(r *http.Request) audit.Origin {
+	actor := strings.TrimSpace(r.Header.Get(ActorHeader))
+	if actor == "" || len(actor) > audit.MaxActorLength {
+		actor = "anonymous"
+	}
+	return audit.Origin{Actor: actor, RequestID: problem.RequestID(r)}
+}
+
+func (c *Controller) GetUserHistory() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		rawID := vars["id"]
+		id, err := strconv.Atoi(rawID)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		events, err := c.service.GetUserHistory(r.Context(), int64(id))
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		if events == nil {
+			events = []audit.Event{}
+		}
+		writeResponse(w, http.StatusOK, ListResponse{Data: events, Total: int64(len(events))})
+	}
+}
+
+// GetAuditEvents lists the changes to all users, oldest first, one page at a
+// time.
+func (c *Controller) GetAuditEvents() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseAuditQuery(r.URL.Query())
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		page, err := c.service.GetAuditEvents(r.Context(), q)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+
+		response := ListResponse{Data: page.Events, Total: page.Total}
+		if page.Events == nil {
+			response.Data = []audit.Event{}
+		}
+		if page.Next != 0 {
+			query := r.URL.Query()
+			query.Set("cursor", strconv.FormatInt(page.Next, 10))
+			response.Next = r.URL.Path + "?" + query.Encode()
+		}
+		writeResponse(w, http.StatusOK, response)
+	}
+}
+
+// parseAuditQuery reads since, cursor and limit from the query string.
+func parseAuditQuery(query url.Values) (audit.Query, error) {
+	var q audit.Query
+	var err error
+
+	q.Limit, err = parseLimit(query)
+	if err != nil {
+		return q, err
+	}
+	if raw := query.Get("since"); raw != "" {
+		q.Since, err = time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return q, &domain.ValidationError{Fields: []domain.FieldError{
+				{Field: "since", Message: "must be an RFC 3339 timestamp"},
+			}}
+		}
+	}
+	if raw := query.Get("cursor"); raw != "" {
+		q.After, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil || q.After < 0 {
+			return q, &domain.ValidationError{Fields: []domain.FieldError{
+				{Field: "cursor", Message: "is not a valid cursor"},
+			}}
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/internal/problem"
+	"github.com/pmaterer/peopler/user"
+)
+
+// BatchResponse reports the outcome of every operation of a batch, in the
+// order they were sent.
+type BatchResponse struct {
+	Applied int         `json:"applied"`
+	Failed  int         `json:"failed"`
+	Results []BatchItem `json:"results"`
+}
+
+// BatchItem is the outcome of one operation. Status is the HTTP status the
+// equivalent single request would have had; 424 Failed Dependency marks
+// operations undone because another one of an atomic batch failed.
+type BatchItem struct {
+	Op     string              `json:"op"`
+	Status int                 `json:"status"`
+	ID     int64               `json:"id,omitempty"`
+	ETag   string              `json:"etag,omitempty"`
+	Detail string              `json:"detail,omitempty"`
+	Errors []domain.FieldError `json:"errors,omitempty"`
+	// ConflictingID names the user already holding a unique value the
+	// operation asked for.
+	ConflictingID int64 `json:"conflictingId,omitempty"`
+}
+
+// Batch applies an array of create, update and delete operations in one
+// transaction. The atomic query parameter, true by default, decides whether
+// one failure rolls back the whole batch or only that operation.
+func (c *Controller) Batch() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		atomic, err := parseBool(r.URL.Query(), "atomic", true)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		requestBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			problem.Write(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		defer r.Body.Close()
+
+		var ops []user.BatchOperation
+		if err := json.Unmarshal(requestBody, &ops); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		results, err := c.service.Batch(r.Context(), ops, atomic, origin(r))
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+
+		response := BatchResponse{Results: make([]BatchItem, len(results))}
+		for i, result := range results {
+			item := newBatchItem(ops[i].Op, result)
+			if result.Err != nil {
+				response.Failed++
+			} else {
+				response.Applied++
+			}
+			response.Results[i] = item
+		}
+		writeResponse(w, http.StatusOK, response)
+	}
+}
+
+// newBatchItem describes the result of an operation like the equivalent
+// single request would have.
+func newBatchItem(op string, result user.BatchResult) BatchItem {
+	item := BatchItem{Op: op, ID: result.ID}
+	switch {
+	case result.Err != nil:
+		item.Status = problem.StatusOf(result.Err)
+		item.Detail = result.Err.Error()
+		var validationErr *domain.ValidationError
+		if errors.As(result.Err, &validationErr) {
+			item.Errors = validationErr.Fields
+		}
+		var conflictErr *domain.ConflictError
+		if errors.As(result.Err, &conflictErr) {
+			item.Errors = []domain.FieldError{{Field: conflictErr.Field, Message: "is already in use"}}
+			item.ConflictingID = conflictErr.ID
+		}
+	case result.Created:
+		item.Status = http.StatusCreated
+	default:
+		item.Status = http.StatusOK
+	}
+	if result.Err == nil && result.Version != 0 {
+		item.ETag = etag(user.User{Version: result.Version})
+	}
+	return item
+}
@@ -1,25 +1,60 @@
 package controller
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/internal/problem"
 	"github.com/pmaterer/peopler/user"
 )
 
 type service interface {
-	CreateUser(u user.User) error
-	GetUser(id int64) (user.User, error)
-	GetAllUsers() ([]user.User, error)
-	UpdateUser(u user.User) error
-	DeleteUser(id int64) error
+	CreateUser(ctx context.Context, u user.User, origin audit.Origin) (user.User, error)
+	GetUser(ctx context.Context, id int64) (user.User, error)
+	SearchUsers(ctx context.Context, q string, limit int) (user.Page, error)
+	GetAllUsers(ctx context.Context, opts user.ListOptions) (user.Page, error)
+	StreamUsers(ctx context.Context, opts user.ListOptions, fn func(user.User) error) error
+	UpdateUser(ctx context.Context, u user.User, origin audit.Origin) (user.User, error)
+	PatchUser(ctx context.Context, id, version int64, patchType string, patch []byte, origin audit.Origin) (user.User, error)
+	DeleteUser(ctx context.Context, id, version int64, origin audit.Origin) error
+	RestoreUser(ctx context.Context, id int64, origin audit.Origin) (user.User, error)
+	GetReports(ctx context.Context, id int64, depth int) ([]user.OrgNode, error)
+	GetManagementChain(ctx context.Context, id int64) ([]user.User, error)
+	GetOrgChart(ctx context.Context, depth int) ([]user.OrgNode, error)
+	GetAttributeDefinitions(ctx context.Context) ([]user.AttributeDefinition, error)
+	GetAttributeDefinition(ctx context.Context, name string) (user.AttributeDefinition, error)
+	PutAttributeDefinition(ctx context.Context, d user.AttributeDefinition) (user.AttributeDefinition, bool, error)
+	DeleteAttributeDefinition(ctx context.Context, name string, origin audit.Origin) error
+	TagUser(ctx context.Context, id int64, tag string) error
+	UntagUser(ctx context.Context, id int64, tag string) error
+	GetUserTags(ctx context.Context, id int64) ([]string, error)
+	GetTags(ctx context.Context) ([]user.TagCount, error)
+	PutUserPhoto(ctx context.Context, id int64, data []byte) error
+	GetUserPhoto(ctx context.Context, id int64, size user.PhotoSize) ([]byte, error)
+	DeleteUserPhoto(ctx context.Context, id int64) error
+	GetUserHistory(ctx context.Context, id int64) ([]audit.Event, error)
+	GetAuditEvents(ctx context.Context, q audit.Query) (audit.Page, error)
+	Batch(ctx context.Context, ops []user.BatchOperation, atomic bool, origin audit.Origin) ([]user.BatchResult, error)
+	ImportUsers(ctx context.Context, users []user.User, fields []string, atomic, dryRun bool, origin audit.Origin) ([]user.BatchResult, error)
+	StreamImportUsers(ctx context.Context, next func() (user.ImportRecord, error), atomic, dryRun bool, origin audit.Origin, failed func(user.ImportRecord, user.BatchResult)) (user.ImportSummary, error)
 }
 
 type Controller struct {
 	service service
+	// RequireIfMatch rejects PUT, PATCH and DELETE requests without an
+	// If-Match header with 428 Precondition Required.
+	RequireIfMatch bool
 }
 
 func NewController(s service) *Controller {
@@ -29,12 +64,15 @@ func NewController(s service) *Controller {
 }
 
 type Response struct {
-	Status  int    `json:"-"`
 	Message string `json:"message,omitempty"`
 }
 
-func writeErrorResponse(w http.ResponseWriter, code int, message string) {
-	writeResponse(w, code, Response{Status: code, Message: message})
+// ListResponse is the envelope for paginated collections.
+type ListResponse struct {
+	Data  interface{} `json:"data"`
+	Total int64       `json:"total"`
+	// Next links to the following page and is empty on the last one.
+	Next string `json:"next,omitempty"`
 }
 
 func writeResponse(w http.ResponseWriter, code int, payload interface{}) {
@@ -48,34 +86,57 @@ func (c *Controller) CreateUser() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		requestBody, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			writeErrorResponse(w, http.StatusInternalServerError, err.Error())
+			problem.Write(w, r, http.StatusInternalServerError, err)
 			return
 		}
 		defer r.Body.Close()
 
 		var u user.User
 		if err := json.Unmarshal(requestBody, &u); err != nil {
-			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+			problem.Write(w, r, http.StatusBadRequest, err)
 			return
 		}
 
-		err = c.service.CreateUser(u)
+		created, err := c.service.CreateUser(r.Context(), u, origin(r))
 		if err != nil {
-			writeErrorResponse(w, http.StatusInternalServerError, err.Error())
+			problem.WriteError(w, r, err)
 			return
 		}
-		writeResponse(w, http.StatusOK, Response{Message: "OK"})
+		w.Header().Set("Location", fmt.Sprintf("/user/%d", created.ID))
+		w.Header().Set("ETag", etag(created))
+		writeResponse(w, http.StatusCreated, created)
 	}
 }
 
 func (c *Controller) GetAllUsers() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		users, err := c.service.GetAllUsers()
+		opts, err := parseListOptions(r.URL.Query())
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		w.Header().Set("Vary", "Accept")
+		if acceptsNDJSON(r) {
+			c.streamUsers(w, r, opts)
+			return
+		}
+
+		page, err := c.service.GetAllUsers(r.Context(), opts)
 		if err != nil {
-			writeErrorResponse(w, http.StatusInternalServerError, err.Error())
+			problem.WriteError(w, r, err)
 			return
 		}
-		writeResponse(w, http.StatusOK, users)
+
+		response := ListResponse{Data: page.Users, Total: page.Total}
+		if page.Users == nil {
+			response.Data = []user.User{}
+		}
+		if page.Next != nil {
+			query := r.URL.Query()
+			query.Set("cursor", page.Next.Encode())
+			response.Next = r.URL.Path + "?" + query.Encode()
+		}
+		writeResponse(w, http.StatusOK, response)
 	}
 }
 
@@ -85,49 +146,133 @@ func (c *Controller) GetUser() func(w http.ResponseWriter, r *http.Request) {
 		rawID := vars["id"]
 		id, err := strconv.Atoi(rawID)
 		if err != nil {
-			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+			problem.Write(w, r, http.StatusBadRequest, err)
 			return
 		}
-		user, err := c.service.GetUser(int64(id))
+		user, err := c.service.GetUser(r.Context(), int64(id))
 		if err != nil {
-			writeErrorResponse(w, http.StatusInternalServerError, err.Error())
+			problem.WriteError(w, r, err)
+			return
+		}
+		w.Header().Set("ETag", etag(user))
+		if notModified(r, user) {
+			w.WriteHeader(http.StatusNotModified)
 			return
 		}
 		writeResponse(w, http.StatusOK, user)
 	}
 }
 
+func (c *Controller) SearchUsers() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		limit, err := parseLimit(query)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		page, err := c.service.SearchUsers(r.Context(), query.Get("q"), limit)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+
+		response := ListResponse{Data: page.Users, Total: page.Total}
+		if page.Users == nil {
+			response.Data = []user.User{}
+		}
+		writeResponse(w, http.StatusOK, response)
+	}
+}
+
 func (c *Controller) UpdateUser() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		rawID := vars["id"]
 		id, err := strconv.Atoi(rawID)
 		if err != nil {
-			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		version, status, err := c.ifMatch(r)
+		if err != nil {
+			problem.Write(w, r, status, err)
 			return
 		}
 
 		requestBody, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			writeErrorResponse(w, http.StatusInternalServerError, err.Error())
+			problem.Write(w, r, http.StatusInternalServerError, err)
 			return
 		}
 		defer r.Body.Close()
 
 		var u user.User
 		if err := json.Unmarshal(requestBody, &u); err != nil {
-			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+			problem.Write(w, r, http.StatusBadRequest, err)
 			return
 		}
 
 		u.ID = int64(id)
+		u.Version = version
 
-		err = c.service.UpdateUser(u)
+		updated, err := c.service.UpdateUser(r.Context(), u, origin(r))
 		if err != nil {
-			writeErrorResponse(w, http.StatusInternalServerError, err.Error())
+			problem.WriteError(w, r, err)
 			return
 		}
-		writeResponse(w, http.StatusOK, Response{Message: "OK"})
+		w.Header().Set("ETag", etag(updated))
+		writeResponse(w, http.StatusOK, updated)
+	}
+}
+
+// acceptPatch lists the patch formats PatchUser understands, for the
+// Accept-Patch header (RFC 5789).
+var acceptPatch = strings.Join([]string{user.MergePatchType, user.JSONPatchType}, ", ")
+
+func (c *Controller) PatchUser() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		rawID := vars["id"]
+		id, err := strconv.Atoi(rawID)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		patchType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || (patchType != user.MergePatchType && patchType != user.JSONPatchType) {
+			w.Header().Set("Accept-Patch", acceptPatch)
+			problem.Write(w, r, http.StatusUnsupportedMediaType,
+				fmt.Errorf("patch media type must be one of %s", acceptPatch))
+			return
+		}
+		version, status, err := c.ifMatch(r)
+		if err != nil {
+			problem.Write(w, r, status, err)
+			return
+		}
+
+		requestBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			problem.Write(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if !json.Valid(requestBody) {
+			problem.Write(w, r, http.StatusBadRequest, errors.New("request body is not valid JSON"))
+			return
+		}
+
+		patched, err := c.service.PatchUser(r.Context(), int64(id), version, patchType, requestBody, origin(r))
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		w.Header().Set("ETag", etag(patched))
+		writeResponse(w, http.StatusOK, patched)
 	}
 }
 
@@ -137,14 +282,109 @@ func (c *Controller) DeleteUser() func(w http.ResponseWriter, r *http.Request) {
 		rawID := vars["id"]
 		id, err := strconv.Atoi(rawID)
 		if err != nil {
-			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+			problem.Write(w, r, http.StatusBadRequest, err)
 			return
 		}
-		err = c.service.DeleteUser(int64(id))
+		version, status, err := c.ifMatch(r)
 		if err != nil {
-			writeErrorResponse(w, http.StatusInternalServerError, err.Error())
+			problem.Write(w, r, status, err)
+			return
+		}
+		err = c.service.DeleteUser(r.Context(), int64(id), version, origin(r))
+		if err != nil {
+			problem.WriteError(w, r, err)
 			return
 		}
 		writeResponse(w, http.StatusOK, Response{Message: "OK"})
 	}
 }
+
+func (c *Controller) RestoreUser() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		rawID := vars["id"]
+		id, err := strconv.Atoi(rawID)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		restored, err := c.service.RestoreUser(r.Context(), int64(id), origin(r))
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		w.Header().Set("ETag", etag(restored))
+		writeResponse(w, http.StatusOK, restored)
+	}
+}
+
+// parseListOptions reads limit, cursor, sort, firstName, lastName and
+// includeDeleted from the query string.
+func parseListOptions(query url.Values) (user.ListOptions, error) {
+	var opts user.ListOptions
+	var err error
+
+	opts.Limit, err = parseLimit(query)
+	if err != nil {
+		return opts, err
+	}
+	if raw := query.Get("cursor"); raw != "" {
+		opts.After, err = user.DecodeCursor(raw)
+		if err != nil {
+			return opts, err
+		}
+	}
+	opts.Sort, err = user.ParseSort(query.Get("sort"))
+	if err != nil {
+		return opts, err
+	}
+	opts.FirstNamePrefix = query.Get("firstName")
+	opts.LastNamePrefix = query.Get("lastName")
+	opts.Attributes = parseAttributeFilter(query)
+	opts.Tags = query["tag"]
+	switch query.Get("tagMatch") {
+	case "", tagMatchAll:
+	case tagMatchAny:
+		opts.AnyTag = true
+	default:
+		return opts, &domain.ValidationError{Fields: []domain.FieldError{
+			{Field: "tagMatch", Message: fmt.Sprintf("must be %s or %s", tagMatchAll, tagMatchAny)},
+		}}
+	}
+	opts.IncludeDeleted, err = parseBool(query, "includeDeleted", false)
+	if err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// parseBool reads an optional boolean query parameter.
+func parseBool(query url.Values, name string, fallback bool) (bool, error) {
+	raw := query.Get(name)
+	if raw == "" {
+		return fallback, nil
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return fallback, &domain.ValidationError{Fields: []domain.FieldError{
+			{Field: name, Message: "must be true or false"},
+		}}
+	}
+	return value, nil
+}
+
+// parseLimit reads the optional limit query parameter, returning zero when it
+// is absent so the service default applies.
+func parseLimit(query url.Values) (int, error) {
+	raw := query.Get("limit")
+	if raw == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 || limit > user.MaxListLimit {
+		return 0, &domain.ValidationError{Fields: []domain.FieldError{
+			{Field: "limit", Message: fmt.Sprintf("must be a number between 1 and %d", user.MaxListLimit)},
+		}}
+	}
+	return limit, nil
+}
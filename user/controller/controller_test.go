@@ -1,15 +1,29 @@
 package controller
 
 import (
-	"errors"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/internal/problem"
 	"github.com/pmaterer/peopler/user"
+	"github.com/pmaterer/peopler/user/repository/memory"
+	"github.com/pmaterer/peopler/user/repository/repositorytest"
+	userservice "github.com/pmaterer/peopler/user/service"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -44,62 +58,54 @@ var (
 			LastName:  "Kubrick",
 		},
 	}
-	testUsersPayload = `[{"id":2,"firstName":"Stephen","lastName":"King"},{"id":3,"firstName":"Herman","lastName":"Melville"},{"id":4,"firstName":"Stanley","lastName":"Kubrick"}]`
+	testUsersPayload = `{"data":[{"id":1,"firstName":"Shane","lastName":"Glass"},{"id":2,"firstName":"Stephen","lastName":"King"},{"id":3,"firstName":"Herman","lastName":"Melville"},{"id":4,"firstName":"Stanley","lastName":"Kubrick"}],"total":4}`
 )
 
-type mockService struct {
-	CreateUserFunc  func(u user.User) error
-	GetAllUsersFunc func() ([]user.User, error)
-	GetUserFunc     func(id int64) (user.User, error)
-	UpdateUserFunc  func(u user.User) error
-	DeleteUserFunc  func(id int64) error
+// newTestService returns a service over an in-memory repository holding
+// testUser followed by testUsers, so their IDs match the fixtures.
+func newTestService(t *testing.T) *userservice.Service {
+	r := memory.NewRepository()
+	for _, u := range append([]user.User{testUser}, testUsers...) {
+		_, err := r.CreateUser(context.Background(), u, audit.System)
+		assert.Nil(t, err)
+	}
+	return userservice.NewService(r)
 }
 
-func (s *mockService) CreateUser(u user.User) error        { return s.CreateUserFunc(u) }
-func (s *mockService) GetAllUsers() ([]user.User, error)   { return s.GetAllUsersFunc() }
-func (s *mockService) GetUser(id int64) (user.User, error) { return s.GetUserFunc(id) }
-func (s *mockService) UpdateUser(u user.User) error        { return s.UpdateUserFunc(u) }
-func (s *mockService) DeleteUser(id int64) error           { return s.DeleteUserFunc(id) }
+func newBrokenService(t *testing.T) *userservice.Service {
+	return userservice.NewService(repositorytest.Broken{Repository: memory.NewRepository()})
+}
 
 func TestCreateUser(t *testing.T) {
 	tests := []struct {
 		name        string
 		errExpected bool
-		method      func(u user.User) error
+		service     func(t *testing.T) *userservice.Service
 		payload     string
 	}{
 		{
 			name:        "Create user OK",
 			errExpected: false,
-			method: func(u user.User) error {
-				return nil
-			},
-			payload: userPayload,
+			service:     newTestService,
+			payload:     userPayload,
 		},
 		{
 			name:        "Create user error",
 			errExpected: true,
-			method: func(u user.User) error {
-				return errors.New("bad stuff")
-			},
-			payload: userPayload,
+			service:     newBrokenService,
+			payload:     userPayload,
 		},
 		{
 			name:        "Create user error",
 			errExpected: true,
-			method: func(u user.User) error {
-				return nil
-			},
-			payload: userPayloadMalformed,
+			service:     newTestService,
+			payload:     userPayloadMalformed,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &mockService{
-				CreateUserFunc: tt.method,
-			}
-			c := NewController(s)
+			c := NewController(tt.service(t))
 
 			req, err := http.NewRequest("POST", "/user", strings.NewReader(tt.payload))
 			assert.Nil(t, err)
@@ -116,7 +122,12 @@ func TestCreateUser(t *testing.T) {
 					assert.Equal(t, http.StatusInternalServerError, rr.Result().StatusCode)
 				}
 			} else {
-				assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+				assert.Equal(t, http.StatusCreated, rr.Result().StatusCode)
+				assert.Equal(t, "/user/5", rr.Result().Header.Get("Location"))
+				assert.Equal(t, `"1"`, rr.Result().Header.Get("ETag"))
+
+				payload, _ := ioutil.ReadAll(rr.Result().Body)
+				assert.Equal(t, `{"id":5,"firstName":"Shane","lastName":"Glass"}`, string(payload))
 			}
 		})
 	}
@@ -126,30 +137,23 @@ func TestGetAllUsers(t *testing.T) {
 	tests := []struct {
 		name        string
 		errExpected bool
-		method      func() ([]user.User, error)
+		service     func(t *testing.T) *userservice.Service
 	}{
 		{
 			name:        "Get all users OK",
 			errExpected: false,
-			method: func() ([]user.User, error) {
-				return testUsers, nil
-			},
+			service:     newTestService,
 		},
 		{
 			name:        "Get all users error",
 			errExpected: true,
-			method: func() ([]user.User, error) {
-				return testUsers, errors.New("bad stuff")
-			},
+			service:     newBrokenService,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &mockService{
-				GetAllUsersFunc: tt.method,
-			}
-			c := NewController(s)
+			c := NewController(tt.service(t))
 
 			req, err := http.NewRequest("GET", "/users", nil)
 			assert.Nil(t, err)
@@ -177,30 +181,23 @@ func TestGetUser(t *testing.T) {
 	tests := []struct {
 		name        string
 		errExpected bool
-		method      func(id int64) (user.User, error)
+		service     func(t *testing.T) *userservice.Service
 	}{
 		{
 			name:        "Get user OK",
 			errExpected: false,
-			method: func(id int64) (user.User, error) {
-				return testUser, nil
-			},
+			service:     newTestService,
 		},
 		{
 			name:        "Get user error",
 			errExpected: true,
-			method: func(id int64) (user.User, error) {
-				return testUser, errors.New("bad stuff")
-			},
+			service:     newBrokenService,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &mockService{
-				GetUserFunc: tt.method,
-			}
-			c := NewController(s)
+			c := NewController(tt.service(t))
 
 			req, err := http.NewRequest("GET", "/user/1", nil)
 			assert.Nil(t, err)
@@ -232,41 +229,32 @@ func TestUpdateUser(t *testing.T) {
 	tests := []struct {
 		name        string
 		errExpected bool
-		method      func(u user.User) error
+		service     func(t *testing.T) *userservice.Service
 		payload     string
 	}{
 		{
 			name:        "Update user OK",
 			errExpected: false,
-			method: func(u user.User) error {
-				return nil
-			},
-			payload: updateUserPayload,
+			service:     newTestService,
+			payload:     updateUserPayload,
 		},
 		{
 			name:        "Update user error",
 			errExpected: true,
-			method: func(u user.User) error {
-				return errors.New("bad stuff")
-			},
-			payload: updateUserPayload,
+			service:     newBrokenService,
+			payload:     updateUserPayload,
 		},
 		{
 			name:        "Update user malformed payload error",
 			errExpected: true,
-			method: func(u user.User) error {
-				return errors.New("bad stuff")
-			},
-			payload: updateUserPayloadMalformed,
+			service:     newBrokenService,
+			payload:     updateUserPayloadMalformed,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &mockService{
-				UpdateUserFunc: tt.method,
-			}
-			c := NewController(s)
+			c := NewController(tt.service(t))
 
 			req, err := http.NewRequest("PUT", "/user/1", strings.NewReader(tt.payload))
 			assert.Nil(t, err)
@@ -289,6 +277,10 @@ func TestUpdateUser(t *testing.T) {
 				}
 			} else {
 				assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+				assert.Equal(t, `"2"`, rr.Result().Header.Get("ETag"), "the next write can be conditional without a GET")
+				var updated user.User
+				assert.Nil(t, json.NewDecoder(rr.Body).Decode(&updated))
+				assert.Equal(t, "Glas", updated.LastName)
 			}
 		})
 	}
@@ -298,30 +290,23 @@ func TestDeleteUser(t *testing.T) {
 	tests := []struct {
 		name        string
 		errExpected bool
-		method      func(id int64) error
+		service     func(t *testing.T) *userservice.Service
 	}{
 		{
 			name:        "Delete user OK",
 			errExpected: false,
-			method: func(id int64) error {
-				return nil
-			},
+			service:     newTestService,
 		},
 		{
 			name:        "Delete user error",
 			errExpected: true,
-			method: func(id int64) error {
-				return errors.New("bad stuff")
-			},
+			service:     newBrokenService,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &mockService{
-				DeleteUserFunc: tt.method,
-			}
-			c := NewController(s)
+			c := NewController(tt.service(t))
 
 			req, err := http.NewRequest("DELETE", "/user/1", nil)
 			assert.Nil(t, err)
@@ -344,3 +329,1154 @@ func TestDeleteUser(t *testing.T) {
 		})
 	}
 }
+
+func TestUserNotFound(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		handler func(c *Controller) func(w http.ResponseWriter, r *http.Request)
+		payload string
+	}{
+		{
+			name:    "Get missing user",
+			method:  "GET",
+			handler: (*Controller).GetUser,
+		},
+		{
+			name:    "Update missing user",
+			method:  "PUT",
+			handler: (*Controller).UpdateUser,
+			payload: updateUserPayload,
+		},
+		{
+			name:    "Delete missing user",
+			method:  "DELETE",
+			handler: (*Controller).DeleteUser,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewController(newTestService(t))
+
+			req, err := http.NewRequest(tt.method, "/user/99", strings.NewReader(tt.payload))
+			assert.Nil(t, err)
+			req = mux.SetURLVars(req, map[string]string{"id": "99"})
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(tt.handler(c))
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+			assert.Equal(t, problem.ContentType, rr.Result().Header.Get("Content-Type"))
+		})
+	}
+}
+
+func TestCreateInvalidUser(t *testing.T) {
+	c := NewController(newTestService(t))
+
+	req, err := http.NewRequest("POST", "/user", strings.NewReader(`{}`))
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(c.CreateUser())
+
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Result().StatusCode)
+
+	var p problem.Problem
+	err = json.NewDecoder(rr.Result().Body).Decode(&p)
+	assert.Nil(t, err)
+	assert.Equal(t, []domain.FieldError{
+		{Field: "firstName", Message: "is required"},
+		{Field: "lastName", Message: "is required"},
+	}, p.Errors)
+}
+
+func TestGetAllUsersPagination(t *testing.T) {
+	c := NewController(newTestService(t))
+	handler := http.HandlerFunc(c.GetAllUsers())
+
+	var ids []int64
+	next := "/users?limit=3&sort=-id"
+	for next != "" {
+		req, err := http.NewRequest("GET", next, nil)
+		assert.Nil(t, err)
+		rr := httptest.NewRecorder()
+
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+		var page struct {
+			Data  []user.User `json:"data"`
+			Total int64       `json:"total"`
+			Next  string      `json:"next"`
+		}
+		err = json.NewDecoder(rr.Result().Body).Decode(&page)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(4), page.Total)
+		for _, u := range page.Data {
+			ids = append(ids, u.ID)
+		}
+		next = page.Next
+	}
+	assert.Equal(t, []int64{4, 3, 2, 1}, ids)
+}
+
+func TestGetAllUsersBadQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		field string
+	}{
+		{"Bad limit", "limit=none", "limit"},
+		{"Limit too large", "limit=100000", "limit"},
+		{"Bad cursor", "cursor=abc", "cursor"},
+		{"Bad sort", "sort=password", "sort"},
+		{"Bad includeDeleted", "includeDeleted=maybe", "includeDeleted"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewController(newTestService(t))
+
+			req, err := http.NewRequest("GET", "/users?"+tt.query, nil)
+			assert.Nil(t, err)
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(c.GetAllUsers())
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Result().StatusCode)
+			var p problem.Problem
+			err = json.NewDecoder(rr.Result().Body).Decode(&p)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.field, p.Errors[0].Field)
+		})
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		statusCode int
+		payload    string
+	}{
+		{
+			name:       "Search users OK",
+			query:      "q=kub",
+			statusCode: http.StatusOK,
+			payload:    `{"data":[{"id":4,"firstName":"Stanley","lastName":"Kubrick"}],"total":1}`,
+		},
+		{
+			name:       "Search users no match",
+			query:      "q=zzz",
+			statusCode: http.StatusOK,
+			payload:    `{"data":[],"total":0}`,
+		},
+		{
+			name:       "Search users missing query",
+			query:      "",
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "Search users bad limit",
+			query:      "q=kub&limit=0",
+			statusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewController(newTestService(t))
+
+			req, err := http.NewRequest("GET", "/users/search?"+tt.query, nil)
+			assert.Nil(t, err)
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(c.SearchUsers())
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.statusCode, rr.Result().StatusCode)
+			if tt.payload != "" {
+				payload, _ := ioutil.ReadAll(rr.Result().Body)
+				assert.Equal(t, tt.payload, string(payload))
+			}
+		})
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		payload     string
+		statusCode  int
+		response    string
+	}{
+		{
+			name:        "Merge patch OK",
+			contentType: user.MergePatchType,
+			payload:     `{"lastName":"Glas"}`,
+			statusCode:  http.StatusOK,
+			response:    `{"id":1,"firstName":"Shane","lastName":"Glas"}`,
+		},
+		{
+			name:        "JSON patch OK",
+			contentType: user.JSONPatchType + "; charset=utf-8",
+			payload:     `[{"op":"replace","path":"/firstName","value":"Shay"}]`,
+			statusCode:  http.StatusOK,
+			response:    `{"id":1,"firstName":"Shay","lastName":"Glass"}`,
+		},
+		{
+			name:        "Unsupported media type",
+			contentType: "application/json",
+			payload:     `{"lastName":"Glas"}`,
+			statusCode:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "Malformed patch",
+			contentType: user.MergePatchType,
+			payload:     `{"lastName":`,
+			statusCode:  http.StatusBadRequest,
+		},
+		{
+			name:        "Invalid result",
+			contentType: user.MergePatchType,
+			payload:     `{"firstName":""}`,
+			statusCode:  http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "Failed test",
+			contentType: user.JSONPatchType,
+			payload:     `[{"op":"test","path":"/lastName","value":"Glas"}]`,
+			statusCode:  http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewController(newTestService(t))
+
+			req, err := http.NewRequest("PATCH", "/user/1", strings.NewReader(tt.payload))
+			assert.Nil(t, err)
+			req.Header.Set("Content-Type", tt.contentType)
+			req = mux.SetURLVars(req, map[string]string{"id": "1"})
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(c.PatchUser())
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.statusCode, rr.Result().StatusCode)
+			if tt.statusCode == http.StatusUnsupportedMediaType {
+				assert.Equal(t, user.MergePatchType+", "+user.JSONPatchType, rr.Result().Header.Get("Accept-Patch"))
+			}
+			if tt.response != "" {
+				payload, _ := ioutil.ReadAll(rr.Result().Body)
+				assert.Equal(t, tt.response, string(payload))
+			}
+		})
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		handler        func(c *Controller) func(w http.ResponseWriter, r *http.Request)
+		payload        string
+		header         string
+		value          string
+		requireIfMatch bool
+		statusCode     int
+		etag           string
+	}{
+		{
+			name:       "Get",
+			method:     "GET",
+			handler:    (*Controller).GetUser,
+			statusCode: http.StatusOK,
+			etag:       `"1"`,
+		},
+		{
+			name:       "Get not modified",
+			method:     "GET",
+			handler:    (*Controller).GetUser,
+			header:     "If-None-Match",
+			value:      `"1"`,
+			statusCode: http.StatusNotModified,
+			etag:       `"1"`,
+		},
+		{
+			name:       "Get not modified, weak comparison",
+			method:     "GET",
+			handler:    (*Controller).GetUser,
+			header:     "If-None-Match",
+			value:      `"7", W/"1"`,
+			statusCode: http.StatusNotModified,
+			etag:       `"1"`,
+		},
+		{
+			name:       "Get modified",
+			method:     "GET",
+			handler:    (*Controller).GetUser,
+			header:     "If-None-Match",
+			value:      `"7"`,
+			statusCode: http.StatusOK,
+			etag:       `"1"`,
+		},
+		{
+			name:       "Update matching",
+			method:     "PUT",
+			handler:    (*Controller).UpdateUser,
+			payload:    updateUserPayload,
+			header:     "If-Match",
+			value:      `"1"`,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Update any",
+			method:     "PUT",
+			handler:    (*Controller).UpdateUser,
+			payload:    updateUserPayload,
+			header:     "If-Match",
+			value:      `*`,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Update stale",
+			method:     "PUT",
+			handler:    (*Controller).UpdateUser,
+			payload:    updateUserPayload,
+			header:     "If-Match",
+			value:      `"7"`,
+			statusCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "Update weak",
+			method:     "PUT",
+			handler:    (*Controller).UpdateUser,
+			payload:    updateUserPayload,
+			header:     "If-Match",
+			value:      `W/"1"`,
+			statusCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "Update several tags",
+			method:     "PUT",
+			handler:    (*Controller).UpdateUser,
+			payload:    updateUserPayload,
+			header:     "If-Match",
+			value:      `"1", "2"`,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Update unconditional when required",
+			method:         "PUT",
+			handler:        (*Controller).UpdateUser,
+			payload:        updateUserPayload,
+			requireIfMatch: true,
+			statusCode:     http.StatusPreconditionRequired,
+		},
+		{
+			name:       "Patch matching",
+			method:     "PATCH",
+			handler:    (*Controller).PatchUser,
+			payload:    `{"lastName":"Glas"}`,
+			header:     "If-Match",
+			value:      `"1"`,
+			statusCode: http.StatusOK,
+			etag:       `"2"`,
+		},
+		{
+			name:       "Patch stale",
+			method:     "PATCH",
+			handler:    (*Controller).PatchUser,
+			payload:    `{"lastName":"Glas"}`,
+			header:     "If-Match",
+			value:      `"7"`,
+			statusCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "Delete matching",
+			method:     "DELETE",
+			handler:    (*Controller).DeleteUser,
+			header:     "If-Match",
+			value:      `"1"`,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Delete stale",
+			method:     "DELETE",
+			handler:    (*Controller).DeleteUser,
+			header:     "If-Match",
+			value:      `"7"`,
+			statusCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:           "Delete unconditional when required",
+			method:         "DELETE",
+			handler:        (*Controller).DeleteUser,
+			requireIfMatch: true,
+			statusCode:     http.StatusPreconditionRequired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewController(newTestService(t))
+			c.RequireIfMatch = tt.requireIfMatch
+
+			req, err := http.NewRequest(tt.method, "/user/1", strings.NewReader(tt.payload))
+			assert.Nil(t, err)
+			req = mux.SetURLVars(req, map[string]string{"id": "1"})
+			req.Header.Set("Content-Type", user.MergePatchType)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(tt.handler(c))
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.statusCode, rr.Result().StatusCode)
+			if tt.etag != "" {
+				assert.Equal(t, tt.etag, rr.Result().Header.Get("ETag"))
+			}
+			if tt.statusCode == http.StatusNotModified {
+				assert.Zero(t, rr.Body.Len())
+			}
+		})
+	}
+}
+
+func TestRestoreUser(t *testing.T) {
+	s := newTestService(t)
+	c := NewController(s)
+	assert.Nil(t, s.DeleteUser(context.Background(), 1, 0, audit.System))
+
+	list := func(query string) ListResponse {
+		req, err := http.NewRequest("GET", "/users"+query, nil)
+		assert.Nil(t, err)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(c.GetAllUsers()).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+		var page ListResponse
+		assert.Nil(t, json.NewDecoder(rr.Result().Body).Decode(&page))
+		return page
+	}
+	assert.Equal(t, int64(3), list("").Total)
+	assert.Equal(t, int64(4), list("?includeDeleted=true").Total)
+
+	restore := func() *http.Response {
+		req, err := http.NewRequest("POST", "/user/1/restore", nil)
+		assert.Nil(t, err)
+		req = mux.SetURLVars(req, map[string]string{"id": "1"})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(c.RestoreUser()).ServeHTTP(rr, req)
+		return rr.Result()
+	}
+	resp := restore()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"3"`, resp.Header.Get("ETag"))
+	payload, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, testUserPayload, string(payload))
+	assert.Equal(t, int64(4), list("").Total)
+
+	assert.Equal(t, http.StatusConflict, restore().StatusCode)
+}
+
+func TestAudit(t *testing.T) {
+	c := NewController(newTestService(t))
+
+	req, err := http.NewRequest("PUT", "/user/1", strings.NewReader(updateUserPayload))
+	assert.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	req.Header.Set(ActorHeader, "jdoe")
+	req.Header.Set(problem.RequestIDHeader, "req-42")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(c.UpdateUser()).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+
+	type events struct {
+		Data  []audit.Event `json:"data"`
+		Total int64         `json:"total"`
+		Next  string        `json:"next"`
+	}
+
+	req, err = http.NewRequest("GET", "/user/1/history", nil)
+	assert.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(c.GetUserHistory()).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	var history events
+	assert.Nil(t, json.NewDecoder(rr.Result().Body).Decode(&history))
+	if assert.Len(t, history.Data, 2) {
+		updated := history.Data[1]
+		assert.Equal(t, audit.Update, updated.Operation)
+		assert.Equal(t, "jdoe", updated.Actor)
+		assert.Equal(t, "req-42", updated.RequestID)
+		assert.Contains(t, updated.Changes, "lastName")
+	}
+
+	req, err = http.NewRequest("GET", "/user/99/history", nil)
+	assert.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": "99"})
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(c.GetUserHistory()).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+
+	var ids []int64
+	next := "/audit?limit=2"
+	for next != "" {
+		req, err := http.NewRequest("GET", next, nil)
+		assert.Nil(t, err)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(c.GetAuditEvents()).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+		var page events
+		assert.Nil(t, json.NewDecoder(rr.Result().Body).Decode(&page))
+		assert.Equal(t, int64(5), page.Total)
+		for _, e := range page.Data {
+			ids = append(ids, e.ID)
+		}
+		next = page.Next
+	}
+	assert.Equal(t, []int64{1, 2, 3, 4, 5}, ids)
+
+	since := url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))
+	for query, statusCode := range map[string]int{
+		"since=" + since:  http.StatusOK,
+		"since=yesterday": http.StatusBadRequest,
+		"cursor=-1":       http.StatusBadRequest,
+	} {
+		req, err := http.NewRequest("GET", "/audit?"+query, nil)
+		assert.Nil(t, err)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(c.GetAuditEvents()).ServeHTTP(rr, req)
+		assert.Equal(t, statusCode, rr.Result().StatusCode, query)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	c := NewController(newTestService(t))
+	batch := func(query, payload string) (*http.Response, BatchResponse) {
+		req, err := http.NewRequest("POST", "/users:batch"+query, strings.NewReader(payload))
+		assert.Nil(t, err)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(c.Batch()).ServeHTTP(rr, req)
+		var response BatchResponse
+		if rr.Result().StatusCode == http.StatusOK {
+			assert.Nil(t, json.NewDecoder(rr.Result().Body).Decode(&response))
+		}
+		return rr.Result(), response
+	}
+
+	payload := `[
+		{"op": "create", "user": {"firstName": "Julian", "lastName": "Barnes"}},
+		{"op": "update", "id": 1, "version": 1, "user": {"firstName": "Stephen", "lastName": "Kingsley"}},
+		{"op": "update", "id": 2, "user": {"firstName": "", "lastName": "Melville"}},
+		{"op": "delete", "id": 99}
+	]`
+
+	resp, response := batch("", payload)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 0, response.Applied)
+	assert.Equal(t, 4, response.Failed)
+	if assert.Len(t, response.Results, 4) {
+		assert.Equal(t, http.StatusFailedDependency, response.Results[0].Status)
+		assert.Equal(t, "operation 3 of the atomic batch failed: not applied", response.Results[0].Detail, "operations are numbered from 1")
+		assert.Equal(t, http.StatusUnprocessableEntity, response.Results[2].Status)
+		assert.Equal(t, []domain.FieldError{{Field: "firstName", Message: "is required"}}, response.Results[2].Errors)
+	}
+
+	resp, response = batch("?atomic=false", payload)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, response.Applied)
+	assert.Equal(t, 2, response.Failed)
+	assert.Equal(t, []BatchItem{
+		{Op: "create", Status: http.StatusCreated, ID: 5, ETag: `"1"`},
+		{Op: "update", Status: http.StatusOK, ID: 1, ETag: `"2"`},
+		{Op: "update", Status: http.StatusUnprocessableEntity, Detail: "validation failed: firstName is required",
+			Errors: []domain.FieldError{{Field: "firstName", Message: "is required"}}},
+		{Op: "delete", Status: http.StatusNotFound, ID: 99, Detail: "user #99: not found"},
+	}, response.Results)
+
+	resp, _ = batch("?atomic=maybe", payload)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = batch("", `{"op": "create"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = batch("", `[]`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	c = NewController(newBrokenService(t))
+	resp, _ = batch("?atomic=false", payload)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
+
+func TestExportUsers(t *testing.T) {
+	s := newTestService(t)
+	c := NewController(s)
+	assert.Nil(t, s.DeleteUser(context.Background(), 4, 0, audit.System))
+	manager := int64(3)
+	_, err := s.UpdateUser(context.Background(), user.User{ID: 2, FirstName: "Stephen", LastName: "King", Title: "Author, horror",
+		Emails: []user.Email{{Address: "sk@example.com", Type: user.ContactHome}, {Address: "stephen@example.com", Primary: true}},
+		Phones: []user.Phone{{Number: "+12075550100"}}, StartDate: "1974-04-05", ManagerID: &manager}, audit.System)
+	assert.Nil(t, err)
+
+	export := func(query string) *http.Response {
+		req, err := http.NewRequest("GET", "/users.csv"+query, nil)
+		assert.Nil(t, err)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(c.ExportUsers()).ServeHTTP(rr, req)
+		return rr.Result()
+	}
+
+	resp := export("?sort=lastName&limit=1")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "id,externalId,firstName,lastName,email,phone,title,department,employeeNumber,startDate,managerId\n"+
+		"1,,Shane,Glass,,,,,,,\n"+
+		"2,,Stephen,King,stephen@example.com,+12075550100,\"Author, horror\",,,1974-04-05,3\n"+
+		"3,,Herman,Melville,,,,,,,\n", string(body))
+
+	body, _ = ioutil.ReadAll(export("?includeDeleted=true&lastName=Ku").Body)
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	assert.Equal(t, "id,externalId,firstName,lastName,email,phone,title,department,employeeNumber,startDate,managerId,deletedAt", lines[0])
+	if assert.Len(t, lines, 2) {
+		assert.True(t, strings.HasPrefix(lines[1], "4,,Stanley,Kubrick,,,,,,,,20"), lines[1])
+	}
+
+	assert.Equal(t, http.StatusBadRequest, export("?sort=age").StatusCode)
+	c = NewController(newBrokenService(t))
+	assert.Equal(t, http.StatusInternalServerError, export("").StatusCode)
+}
+
+func TestImportUsers(t *testing.T) {
+	s := newTestService(t)
+	c := NewController(s)
+	_, err := s.UpdateUser(context.Background(), user.User{ID: 2, FirstName: "Stephen", LastName: "King", ExternalID: "HR-2"}, audit.System)
+	assert.Nil(t, err)
+
+	importCSV := func(query, payload string) (*http.Response, ImportResponse) {
+		req, err := http.NewRequest("POST", "/users/import"+query, strings.NewReader(payload))
+		assert.Nil(t, err)
+		req.Header.Set("Content-Type", "text/csv; charset=utf-8")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(c.ImportUsers()).ServeHTTP(rr, req)
+		var response ImportResponse
+		if rr.Result().StatusCode == http.StatusOK {
+			assert.Nil(t, json.NewDecoder(rr.Result().Body).Decode(&response))
+		}
+		return rr.Result(), response
+	}
+
+	payload := "\ufeffEmployee No,Given Name,Last_Name,Office\n" +
+		"HR-2,Stephen,Kingsley,Bangor\n" +
+		"HR-9,Julian,Barnes,London\n" +
+		"HR-10,,Hesse\n"
+	query := "?map.externalId=employee+no&map.firstName=Given+Name"
+
+	resp, response := importCSV(query+"&dryRun=true&atomic=false", payload)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, ImportResponse{DryRun: true, Created: 1, Updated: 1, Failed: 1, Rows: []ImportRow{
+		{Row: 2, ExternalID: "HR-2", BatchItem: BatchItem{Op: "update", Status: http.StatusOK, ID: 2, ETag: `"3"`}},
+		{Row: 3, ExternalID: "HR-9", BatchItem: BatchItem{Op: "create", Status: http.StatusCreated}},
+		{Row: 4, ExternalID: "HR-10", BatchItem: BatchItem{Op: "upsert", Status: http.StatusUnprocessableEntity,
+			Detail: "validation failed: firstName is required", Errors: []domain.FieldError{{Field: "firstName", Message: "is required"}}}},
+	}}, response)
+	u, err := s.GetUser(context.Background(), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "King", u.LastName, "a dry run changes nothing")
+
+	_, response = importCSV(query, payload)
+	assert.Equal(t, 0, response.Created+response.Updated)
+	assert.Equal(t, 3, response.Failed)
+	assert.Equal(t, "row 4 of the atomic import failed: not applied", response.Rows[0].Detail, "rows are numbered as in the file")
+
+	_, response = importCSV(query+"&atomic=false", payload)
+	assert.Equal(t, 1, response.Created)
+	assert.Equal(t, 1, response.Updated)
+	assert.Equal(t, int64(5), response.Rows[1].ID)
+	u, err = s.GetUser(context.Background(), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "Kingsley", u.LastName)
+
+	_, response = importCSV("", "firstName,lastName,E-mail,Phone,Start Date\nJulian,Barnes,julian@example.com,+44 20 7946 0000,1980-01-01\n")
+	if assert.Equal(t, 1, response.Created) {
+		u, err = s.GetUser(context.Background(), response.Rows[0].ID)
+		assert.Nil(t, err)
+		assert.Equal(t, []user.Email{{Address: "julian@example.com", Type: user.ContactWork, Primary: true}}, u.Emails)
+		assert.Equal(t, []user.Phone{{Number: "+442079460000", Type: user.ContactWork, Primary: true}}, u.Phones)
+		assert.Equal(t, "1980-01-01", u.StartDate)
+	}
+
+	badRequests := []struct {
+		name    string
+		query   string
+		payload string
+	}{
+		{"Empty file", "", ""},
+		{"Missing column", "", "firstName,surname\nShane,Glass\n"},
+		{"Mapped column missing", "?map.lastName=Surname", "firstName,lastName\nShane,Glass\n"},
+		{"Unknown field", "?map.age=Age", "firstName,lastName\nShane,Glass\n"},
+		{"Malformed CSV", "", "firstName,lastName\n\"Shane,Glass\n"},
+		{"Bad flag", "?dryRun=perhaps", "firstName,lastName\nShane,Glass\n"},
+	}
+	for _, tt := range badRequests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := importCSV(tt.query, tt.payload)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	}
+
+	req, err := http.NewRequest("POST", "/users/import", strings.NewReader("firstName,lastName\n"))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(c.ImportUsers()).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rr.Result().StatusCode)
+
+	resp, _ = importCSV("", "firstName,lastName\n")
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode, "a file without rows imports nobody")
+}
+
+func TestImportKeepsFieldsWithoutColumns(t *testing.T) {
+	s := newTestService(t)
+	c := NewController(s)
+	ctx := context.Background()
+	_, _, err := s.PutAttributeDefinition(ctx, user.AttributeDefinition{Name: "desk"})
+	assert.Nil(t, err)
+	_, _, err = s.PutAttributeDefinition(ctx, user.AttributeDefinition{Name: "shirtSize", Required: true})
+	assert.Nil(t, err)
+	manager := int64(1)
+	stored, err := s.UpdateUser(ctx, user.User{ID: 2, FirstName: "Stephen", LastName: "King", ExternalID: "HR-2", Title: "Author",
+		Emails: []user.Email{{Address: "sk@example.com", Type: user.ContactHome}, {Address: "stephen@example.com", Primary: true}},
+		Phones: []user.Phone{{Number: "+12075550100"}, {Number: "+12075550199", Type: user.ContactMobile}}, ManagerID: &manager,
+		Attributes: map[string]string{"desk": "4B", "shirtSize": "L"}}, audit.System)
+	assert.Nil(t, err)
+
+	importCSV := func(payload string) ImportResponse {
+		req, err := http.NewRequest("POST", "/users/import", strings.NewReader(payload))
+		assert.Nil(t, err)
+		req.Header.Set("Content-Type", "text/csv")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(c.ImportUsers()).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+		var response ImportResponse
+		assert.Nil(t, json.NewDecoder(rr.Result().Body).Decode(&response))
+		return response
+	}
+
+	response := importCSV("externalId,firstName,lastName,email,attr.desk\nHR-2,Stephen,Kingsley,king@example.com,\n")
+	assert.Equal(t, 1, response.Updated, response)
+	u, err := s.GetUser(ctx, 2)
+	assert.Nil(t, err)
+	want := stored
+	want.LastName = "Kingsley"
+	want.Emails = []user.Email{{Address: "sk@example.com", Type: user.ContactHome}, {Address: "king@example.com", Type: user.ContactWork, Primary: true}}
+	want.Attributes = map[string]string{"shirtSize": "L"}
+	want.Version = u.Version
+	assert.Equal(t, want, u, "only the columns of the file are imported, and only the primary email")
+
+	req, err := http.NewRequest("GET", "/users.csv?lastName=Kingsley", nil)
+	assert.Nil(t, err)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(c.ExportUsers()).ServeHTTP(rr, req)
+	response = importCSV(rr.Body.String())
+	assert.Equal(t, 0, response.Failed, response)
+	roundTripped, err := s.GetUser(ctx, 2)
+	assert.Nil(t, err)
+	roundTripped.Version = u.Version
+	assert.Equal(t, u, roundTripped, "an export imports back unchanged")
+}
+
+func TestStreamUsers(t *testing.T) {
+	stream := func(c *Controller, query string) *http.Response {
+		req, err := http.NewRequest("GET", "/users"+query, nil)
+		assert.Nil(t, err)
+		req.Header.Set("Accept", "application/json;q=0.5, application/x-ndjson")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(c.GetAllUsers()).ServeHTTP(rr, req)
+		return rr.Result()
+	}
+
+	resp := stream(NewController(newTestService(t)), "?limit=1&lastName=K")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "Accept", resp.Header.Get("Vary"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, `{"id":2,"firstName":"Stephen","lastName":"King"}`+"\n"+
+		`{"id":4,"firstName":"Stanley","lastName":"Kubrick"}`+"\n", string(body))
+
+	assert.Equal(t, http.StatusBadRequest, stream(NewController(newTestService(t)), "?sort=age").StatusCode)
+	assert.Equal(t, http.StatusInternalServerError, stream(NewController(newBrokenService(t)), "").StatusCode)
+}
+
+func TestImportNDJSON(t *testing.T) {
+	s := newTestService(t)
+	c := NewController(s)
+	_, err := s.UpdateUser(context.Background(), user.User{ID: 2, FirstName: "Stephen", LastName: "King", ExternalID: "HR-2"}, audit.System)
+	assert.Nil(t, err)
+
+	importNDJSON := func(query, payload string) (*http.Response, ImportResponse) {
+		req, err := http.NewRequest("POST", "/users/import"+query, strings.NewReader(payload))
+		assert.Nil(t, err)
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(c.ImportUsers()).ServeHTTP(rr, req)
+		var response ImportResponse
+		if rr.Result().StatusCode == http.StatusOK {
+			assert.Nil(t, json.NewDecoder(rr.Result().Body).Decode(&response))
+		}
+		return rr.Result(), response
+	}
+
+	payload := `{"id":2,"externalId":"HR-2","firstName":"Stephen","lastName":"Kingsley"}` + "\n" +
+		"\n" +
+		`{"externalId":"HR-9","firstName":"Julian","lastName":"Barnes"}` + "\n" +
+		`{"externalId":"HR-10","lastName":"Hesse"}` + "\n" +
+		`{"firstName":` + "\n"
+
+	resp, response := importNDJSON("?atomic=false&dryRun=true", payload)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, ImportResponse{DryRun: true, Created: 1, Updated: 1, Failed: 2, Rows: []ImportRow{
+		{Line: 4, ExternalID: "HR-10", BatchItem: BatchItem{Op: "upsert", Status: http.StatusUnprocessableEntity,
+			Detail: "validation failed: firstName is required", Errors: []domain.FieldError{{Field: "firstName", Message: "is required"}}}},
+		{Line: 5, BatchItem: BatchItem{Op: "upsert", Status: http.StatusUnprocessableEntity,
+			Detail: "validation failed: line is not a JSON user: unexpected end of JSON input",
+			Errors: []domain.FieldError{{Field: "line", Message: "is not a JSON user: unexpected end of JSON input"}}}},
+	}}, response)
+	u, err := s.GetUser(context.Background(), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "King", u.LastName, "a dry run changes nothing")
+
+	_, response = importNDJSON("?atomic=true", payload)
+	assert.True(t, response.Aborted)
+	assert.Equal(t, 0, response.Created+response.Updated)
+	assert.Equal(t, 1, response.Failed)
+	assert.Equal(t, 4, response.Rows[0].Line)
+
+	_, response = importNDJSON("", payload)
+	assert.Equal(t, 1, response.Created)
+	assert.Equal(t, 1, response.Updated)
+	u, err = s.GetUser(context.Background(), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "Kingsley", u.LastName)
+
+	long := `{"firstName":"` + strings.Repeat("a", maxNDJSONLine) + `"}`
+	resp, _ = importNDJSON("", `{"firstName":"Shane","lastName":"Glass"}`+"\n"+long+"\n")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	var p problem.Problem
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&p))
+	assert.Contains(t, p.Detail, "line 2")
+}
+
+func TestImportNDJSONInChunks(t *testing.T) {
+	r := &repositorytest.Counting{Repository: memory.NewRepository()}
+	c := NewController(userservice.NewService(r))
+	var payload strings.Builder
+	const n = 1200
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&payload, `{"firstName":"Reader","lastName":"%04d"}`+"\n", i)
+	}
+	req, err := http.NewRequest("POST", "/users/import", strings.NewReader(payload.String()))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(c.ImportUsers()).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	var response ImportResponse
+	assert.Nil(t, json.NewDecoder(rr.Result().Body).Decode(&response))
+	assert.Equal(t, n, response.Created)
+	assert.False(t, response.Aborted)
+	assert.Greater(t, r.Txs, 1, "an NDJSON import is written in chunks unless asked to be atomic")
+}
+
+func TestCreateUserProfile(t *testing.T) {
+	c := NewController(newTestService(t))
+	create := func(payload string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/user", strings.NewReader(payload))
+		assert.Nil(t, err)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(c.CreateUser()).ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := create(`{"firstName":"Julian","lastName":"Barnes","title":"Novelist","department":"Fiction",
+		"employeeNumber":"0042","startDate":"1980-01-01",
+		"emails":[{"address":"julian@example.com"},{"address":"jb@example.org","type":"home"}],
+		"phones":[{"number":"+44 20 7946 0000","type":"mobile"}]}`)
+	assert.Equal(t, http.StatusCreated, rr.Result().StatusCode)
+	assert.Equal(t, `{"id":5,"firstName":"Julian","lastName":"Barnes",`+
+		`"emails":[{"address":"julian@example.com","type":"work","primary":true},{"address":"jb@example.org","type":"home","primary":false}],`+
+		`"phones":[{"number":"+442079460000","type":"mobile","primary":true}],`+
+		`"title":"Novelist","department":"Fiction","employeeNumber":"0042","startDate":"1980-01-01"}`, rr.Body.String())
+
+	rr = create(`{"firstName":"Julian","lastName":"Barnes","emails":[{"address":"julian"}],"phones":[{"number":"0207946"}]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Result().StatusCode)
+	var p problem.Problem
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&p))
+	assert.Equal(t, []domain.FieldError{
+		{Field: "emails[0].address", Message: "must be an email address such as jane@example.com"},
+		{Field: "phones[0].number", Message: "must be an E.164 number such as +14155550123"},
+	}, p.Errors)
+}
+
+func TestDuplicatePrimaryEmail(t *testing.T) {
+	s := newTestService(t)
+	c := NewController(s)
+	_, err := s.UpdateUser(context.Background(), user.User{ID: 2, FirstName: "Stephen", LastName: "King",
+		Emails: []user.Email{{Address: "stephen@example.com"}}}, audit.System)
+	assert.Nil(t, err)
+
+	send := func(method, path string, handler http.HandlerFunc, payload string) problem.Problem {
+		req, err := http.NewRequest(method, path, strings.NewReader(payload))
+		assert.Nil(t, err)
+		req = mux.SetURLVars(req, map[string]string{"id": "3"})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusConflict, rr.Result().StatusCode)
+		var p problem.Problem
+		assert.Nil(t, json.NewDecoder(rr.Body).Decode(&p))
+		return p
+	}
+
+	p := send("POST", "/user", c.CreateUser(), `{"firstName":"Steve","lastName":"King","emails":[{"address":"Stephen@Example.com"}]}`)
+	assert.Equal(t, int64(2), p.ConflictingID)
+	assert.Equal(t, []domain.FieldError{{Field: "emails[0].address", Message: "is already in use"}}, p.Errors)
+
+	p = send("PUT", "/user/3", c.UpdateUser(), `{"firstName":"Herman","lastName":"Melville","emails":[{"address":"herman@example.com"},{"address":"stephen@example.com","primary":true}]}`)
+	assert.Equal(t, int64(2), p.ConflictingID)
+	assert.Equal(t, []domain.FieldError{{Field: "emails[1].address", Message: "is already in use"}}, p.Errors)
+}
+
+func TestOrgChart(t *testing.T) {
+	s := newTestService(t)
+	c := NewController(s)
+	for _, u := range []user.User{{ID: 2, FirstName: "Stephen", LastName: "King"}, {ID: 3, FirstName: "Herman", LastName: "Melville"}} {
+		manager := u.ID - 1
+		u.ManagerID = &manager
+		_, err := s.UpdateUser(context.Background(), u, audit.System)
+		assert.Nil(t, err)
+	}
+
+	get := func(handler http.HandlerFunc, path, id string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", path, nil)
+		assert.Nil(t, err)
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get(c.GetReports(), "/user/1/reports", "1")
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	assert.Equal(t, `{"data":[{"id":2,"firstName":"Stephen","lastName":"King","managerId":1}],"total":1}`, rr.Body.String())
+	rr = get(c.GetReports(), "/user/1/reports?depth=2", "1")
+	assert.Equal(t, `{"data":[{"id":2,"firstName":"Stephen","lastName":"King","managerId":1,`+
+		`"reports":[{"id":3,"firstName":"Herman","lastName":"Melville","managerId":2}]}],"total":1}`, rr.Body.String())
+	rr = get(c.GetReports(), "/user/4/reports", "4")
+	assert.Equal(t, `{"data":[],"total":0}`, rr.Body.String())
+	for _, query := range []string{"?depth=0", "?depth=deep", "?depth=" + strconv.Itoa(user.MaxOrgDepth+1)} {
+		rr = get(c.GetReports(), "/user/1/reports"+query, "1")
+		assert.Equal(t, http.StatusBadRequest, rr.Result().StatusCode, query)
+	}
+	rr = get(c.GetReports(), "/user/99/reports", "99")
+	assert.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+
+	rr = get(c.GetManagementChain(), "/user/3/chain", "3")
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	assert.Equal(t, `{"data":[{"id":2,"firstName":"Stephen","lastName":"King","managerId":1},`+
+		`{"id":1,"firstName":"Shane","lastName":"Glass"}],"total":2}`, rr.Body.String())
+	rr = get(c.GetManagementChain(), "/user/99/chain", "99")
+	assert.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+
+	rr = get(c.GetOrgChart(), "/orgchart?depth=1", "")
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	assert.Equal(t, `{"data":[{"id":1,"firstName":"Shane","lastName":"Glass"},{"id":4,"firstName":"Stanley","lastName":"Kubrick"}],"total":2}`,
+		rr.Body.String())
+
+	c = NewController(newBrokenService(t))
+	rr = get(c.GetOrgChart(), "/orgchart", "")
+	assert.Equal(t, http.StatusInternalServerError, rr.Result().StatusCode)
+}
+
+func TestAttributes(t *testing.T) {
+	s := newTestService(t)
+	c := NewController(s)
+
+	call := func(handler http.HandlerFunc, method, path, name, payload string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(payload))
+		assert.Nil(t, err)
+		req = mux.SetURLVars(req, map[string]string{"name": name})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := call(c.PutAttributeDefinition(), "PUT", "/attribute/desk", "desk", `{"type":"string","pattern":"[0-9]+[A-Z]"}`)
+	assert.Equal(t, http.StatusCreated, rr.Result().StatusCode)
+	assert.Equal(t, "/attribute/desk", rr.Result().Header.Get("Location"))
+	assert.Equal(t, `{"name":"desk","type":"string","required":false,"pattern":"[0-9]+[A-Z]"}`, rr.Body.String())
+	rr = call(c.PutAttributeDefinition(), "PUT", "/attribute/desk", "desk", `{"name":"office","pattern":"[0-9]+[A-Z]","description":"Floor and letter"}`)
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	assert.Equal(t, `{"name":"desk","type":"string","required":false,"pattern":"[0-9]+[A-Z]","description":"Floor and letter"}`, rr.Body.String())
+	rr = call(c.PutAttributeDefinition(), "PUT", "/attribute/floor", "floor", `{"type":"integer","enum":["one"]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Result().StatusCode)
+	rr = call(c.PutAttributeDefinition(), "PUT", "/attribute/floor", "floor", `{"type":`)
+	assert.Equal(t, http.StatusBadRequest, rr.Result().StatusCode)
+
+	rr = call(c.GetAttributeDefinitions(), "GET", "/attributes", "", "")
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	assert.Equal(t, `{"data":[{"name":"desk","type":"string","required":false,"pattern":"[0-9]+[A-Z]","description":"Floor and letter"}],"total":1}`,
+		rr.Body.String())
+	rr = call(c.GetAttributeDefinition(), "GET", "/attribute/desk", "desk", "")
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	rr = call(c.GetAttributeDefinition(), "GET", "/attribute/floor", "floor", "")
+	assert.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+
+	_, err := s.UpdateUser(context.Background(), user.User{ID: 2, FirstName: "Stephen", LastName: "King",
+		Attributes: map[string]string{"desk": "4B"}}, audit.System)
+	assert.Nil(t, err)
+	rr = call(c.GetAllUsers(), "GET", "/users?attr.desk=4B", "", "")
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	assert.Equal(t, `{"data":[{"id":2,"firstName":"Stephen","lastName":"King","attributes":{"desk":"4B"}}],"total":1}`, rr.Body.String())
+	rr = call(c.GetAllUsers(), "GET", "/users?attr.github=sking", "", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Result().StatusCode)
+
+	rr = call(c.ExportUsers(), "GET", "/users.csv?lastName=K", "", "")
+	assert.Equal(t, "id,externalId,firstName,lastName,email,phone,title,department,employeeNumber,startDate,managerId,attr.desk\n"+
+		"2,,Stephen,King,,,,,,,,4B\n"+
+		"4,,Stanley,Kubrick,,,,,,,,\n", rr.Body.String())
+	req, err := http.NewRequest("POST", "/users/import?atomic=false", strings.NewReader("firstName,lastName,Attr.desk\nIan,McEwan,2C\nZadie,Smith,C2\n"))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "text/csv")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(c.ImportUsers()).ServeHTTP(rr, req)
+	var imported ImportResponse
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&imported))
+	assert.Equal(t, 1, imported.Failed, "C2 does not match the pattern")
+
+	rr = call(c.DeleteAttributeDefinition(), "DELETE", "/attribute/desk", "desk", "")
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	u, err := s.GetUser(context.Background(), 2)
+	assert.Nil(t, err)
+	assert.Nil(t, u.Attributes)
+	rr = call(c.DeleteAttributeDefinition(), "DELETE", "/attribute/desk", "desk", "")
+	assert.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+}
+
+func TestTags(t *testing.T) {
+	c := NewController(newTestService(t))
+
+	call := func(handler http.HandlerFunc, method, path string, vars map[string]string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, nil)
+		assert.Nil(t, err)
+		req = mux.SetURLVars(req, vars)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	tag := func(handler http.HandlerFunc, method, id, tag string) *httptest.ResponseRecorder {
+		return call(handler, method, "/user/"+id+"/tags/"+tag, map[string]string{"id": id, "tag": tag})
+	}
+
+	for _, tagging := range [][2]string{{"1", "remote"}, {"2", "Remote"}, {"2", "oncall"}, {"3", "contractor"}} {
+		rr := tag(c.TagUser(), "PUT", tagging[0], tagging[1])
+		assert.Equal(t, http.StatusOK, rr.Result().StatusCode, tagging)
+	}
+	assert.Equal(t, http.StatusUnprocessableEntity, tag(c.TagUser(), "PUT", "1", "-").Result().StatusCode)
+	assert.Equal(t, http.StatusNotFound, tag(c.TagUser(), "PUT", "99", "remote").Result().StatusCode)
+	assert.Equal(t, http.StatusBadRequest, tag(c.TagUser(), "PUT", "one", "remote").Result().StatusCode)
+
+	rr := call(c.GetTags(), "GET", "/tags", nil)
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	assert.Equal(t, `{"data":[{"name":"contractor","count":1},{"name":"oncall","count":1},{"name":"remote","count":2}],"total":3}`, rr.Body.String())
+	rr = call(c.GetUserTags(), "GET", "/user/2/tags", map[string]string{"id": "2"})
+	assert.Equal(t, `{"data":["oncall","remote"],"total":2}`, rr.Body.String())
+
+	list := func(query string) (int, ListResponse) {
+		rr := call(c.GetAllUsers(), "GET", "/users"+query, nil)
+		var response ListResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		return rr.Result().StatusCode, response
+	}
+	code, response := list("?tag=remote&tag=oncall")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, int64(1), response.Total)
+	_, response = list("?tag=remote&tag=oncall&tagMatch=all")
+	assert.Equal(t, int64(1), response.Total)
+	_, response = list("?tag=remote&tag=contractor&tagMatch=any")
+	assert.Equal(t, int64(3), response.Total)
+	code, _ = list("?tag=remote&tagMatch=some")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	rr = tag(c.UntagUser(), "DELETE", "2", "REMOTE")
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	rr = tag(c.UntagUser(), "DELETE", "2", "remote")
+	assert.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+
+	c = NewController(newBrokenService(t))
+	assert.Equal(t, http.StatusInternalServerError, call(c.GetTags(), "GET", "/tags", nil).Result().StatusCode)
+}
+
+func TestPhotos(t *testing.T) {
+	c := NewController(newTestService(t))
+	var buf bytes.Buffer
+	assert.Nil(t, jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 300, 200)), nil))
+	photo := buf.Bytes()
+
+	call := func(handler http.HandlerFunc, method, path, id string, body []byte, header http.Header) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewReader(body))
+		assert.Nil(t, err)
+		for name := range header {
+			req.Header.Set(name, header.Get(name))
+		}
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusNotFound, call(c.GetUserPhoto(), "GET", "/user/1/photo", "1", nil, nil).Result().StatusCode)
+	rr := call(c.PutUserPhoto(), "PUT", "/user/1/photo", "1", []byte("GIF89a"), http.Header{"Content-Type": {"image/jpeg"}})
+	assert.Equal(t, http.StatusUnsupportedMediaType, rr.Result().StatusCode, "the body is sniffed, not the header")
+	rr = call(c.PutUserPhoto(), "PUT", "/user/1/photo", "1", make([]byte, user.MaxPhotoBytes+1), nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Result().StatusCode)
+	rr = call(c.PutUserPhoto(), "PUT", "/user/1/photo", "1", photo[:20], nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Result().StatusCode)
+	rr = call(c.PutUserPhoto(), "PUT", "/user/99/photo", "99", photo, nil)
+	assert.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+	rr = call(c.PutUserPhoto(), "PUT", "/user/1/photo", "1", photo, nil)
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+
+	rr = call(c.GetUserPhoto(), "GET", "/user/1/photo?size=small", "1", nil, nil)
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	assert.Equal(t, user.PhotoJPEG, rr.Result().Header.Get("Content-Type"))
+	assert.Equal(t, "private, no-cache", rr.Result().Header.Get("Cache-Control"))
+	config, err := jpeg.DecodeConfig(rr.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, 64, config.Width)
+	etag := rr.Result().Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	rr = call(c.GetUserPhoto(), "GET", "/user/1/photo?size=small", "1", nil, http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, rr.Result().StatusCode)
+	rr = call(c.GetUserPhoto(), "GET", "/user/1/photo", "1", nil, http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode, "each size has its own entity tag")
+	assert.Equal(t, http.StatusBadRequest, call(c.GetUserPhoto(), "GET", "/user/1/photo?size=huge", "1", nil, nil).Result().StatusCode)
+
+	assert.Equal(t, http.StatusOK, call(c.DeleteUserPhoto(), "DELETE", "/user/1/photo", "1", nil, nil).Result().StatusCode)
+	assert.Equal(t, http.StatusNotFound, call(c.GetUserPhoto(), "GET", "/user/1/photo", "1", nil, nil).Result().StatusCode)
+	assert.Equal(t, http.StatusBadRequest, call(c.DeleteUserPhoto(), "DELETE", "/user/one/photo", "one", nil, nil).Result().StatusCode)
+}
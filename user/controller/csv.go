@@ -0,0 +1,385 @@
+package controller
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/internal/problem"
+	"github.com/pmaterer/peopler/user"
+)
+
+const csvContentType = "text/csv"
+
+// csvFields are the user fields an import reads, by their default column
+// name. An export writes the same columns after the ID, followed by an
+// attr.<name> column per attribute. A CSV row has room for one email and one
+// phone, the primary ones.
+var csvFields = []string{"externalId", "firstName", "lastName", "email", "phone", "title", "department", "employeeNumber", "startDate", "managerId"}
+
+// ImportResponse reports what an import did, or would do in a dry run, to
+// every row of the file. An NDJSON import only lists its first failed
+// lines, and sets Aborted if it was atomic and stopped at a failure.
+type ImportResponse struct {
+	DryRun  bool        `json:"dryRun"`
+	Aborted bool        `json:"aborted,omitempty"`
+	Created int         `json:"created"`
+	Updated int         `json:"updated"`
+	Failed  int         `json:"failed"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+// ImportRow is the outcome of one data row. Row counts lines as a
+// spreadsheet does, so the first row after the header is row 2; NDJSON
+// imports set Line instead. Op is create or update once the row was
+// applied, and upsert otherwise.
+type ImportRow struct {
+	Row        int    `json:"row,omitempty"`
+	Line       int    `json:"line,omitempty"`
+	ExternalID string `json:"externalId,omitempty"`
+	BatchItem
+}
+
+// ExportUsers streams every user matching the listing filters as CSV,
+// straight from the database. The limit and cursor parameters are ignored.
+func (c *Controller) ExportUsers() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts, err := parseListOptions(r.URL.Query())
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		opts.After = nil
+		defs, err := c.service.GetAttributeDefinitions(r.Context())
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", csvContentType+"; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="users.csv"`)
+		out := csv.NewWriter(w)
+		header := append([]string{"id"}, csvFields...)
+		for _, d := range defs {
+			header = append(header, attributeFilterPrefix+d.Name)
+		}
+		if opts.IncludeDeleted {
+			header = append(header, "deletedAt")
+		}
+		out.Write(header)
+		flusher, _ := w.(http.Flusher)
+		var written int
+		err = c.service.StreamUsers(r.Context(), opts, func(u user.User) error {
+			record := []string{strconv.FormatInt(u.ID, 10), u.ExternalID, u.FirstName, u.LastName,
+				primaryEmail(u), primaryPhone(u), u.Title, u.Department, u.EmployeeNumber, u.StartDate, managerID(u)}
+			for _, d := range defs {
+				record = append(record, u.Attributes[d.Name])
+			}
+			if opts.IncludeDeleted {
+				var deletedAt string
+				if u.DeletedAt != nil {
+					deletedAt = u.DeletedAt.UTC().Format(time.RFC3339)
+				}
+				record = append(record, deletedAt)
+			}
+			out.Write(record)
+			written++
+			if written%streamFlushInterval == 0 {
+				out.Flush()
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+			// An error here means the client went away.
+			return out.Error()
+		})
+		if err != nil && written == 0 {
+			// Only the header row is buffered, so the error can still be
+			// reported.
+			w.Header().Del("Content-Disposition")
+			problem.WriteError(w, r, err)
+			return
+		}
+		out.Flush()
+		if err != nil {
+			// The status is already sent, so all we can do is cut the
+			// export short.
+			log.Printf("request %s: exporting users: %v\n", problem.RequestID(r), err)
+		}
+	}
+}
+
+// ImportUsers upserts the users of a CSV file or an NDJSON stream by
+// external ID. CSV columns are found by name, ignoring case, spaces, dashes
+// and underscores; a map.<field> parameter names the column holding a field
+// instead, as in map.firstName=Given%20Name. Columns named attr.<name> hold
+// attributes, an empty cell leaving the attribute unset. Other columns are
+// ignored.
+//
+// The atomic parameter works as for Batch, except that NDJSON imports are
+// only atomic if asked, since an atomic import is held in memory until it
+// has all been read. With dryRun=true nothing is saved, but the response
+// still reports the outcome of every row.
+func (c *Controller) ImportUsers() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || (mediaType != csvContentType && mediaType != ndjsonContentType) {
+			problem.Write(w, r, http.StatusUnsupportedMediaType,
+				fmt.Errorf("imports must be sent as %s or %s", csvContentType, ndjsonContentType))
+			return
+		}
+		query := r.URL.Query()
+		atomic, err := parseBool(query, "atomic", mediaType != ndjsonContentType)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		dryRun, err := parseBool(query, "dryRun", false)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+		if mediaType == ndjsonContentType {
+			c.importNDJSON(w, r, atomic, dryRun)
+			return
+		}
+
+		users, fields, err := readCSVUsers(r.Body, query)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		results, err := c.service.ImportUsers(r.Context(), users, fields, atomic, dryRun, origin(r))
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+
+		response := ImportResponse{DryRun: dryRun, Rows: make([]ImportRow, len(results))}
+		for i, result := range results {
+			op := user.BatchUpsert
+			switch {
+			case result.Err != nil:
+				response.Failed++
+			case result.Created:
+				op = user.BatchCreate
+				response.Created++
+			default:
+				op = user.BatchUpdate
+				response.Updated++
+			}
+			item := newBatchItem(op, result)
+			var notApplied *user.NotAppliedError
+			if errors.As(result.Err, &notApplied) {
+				item.Detail = fmt.Sprintf("row %d of the atomic import failed: %v", csvRow(notApplied.Failed), domain.ErrNotApplied)
+			}
+			response.Rows[i] = ImportRow{Row: csvRow(i), ExternalID: users[i].ExternalID, BatchItem: item}
+		}
+		writeResponse(w, http.StatusOK, response)
+	}
+}
+
+// csvRow returns the row of a file holding the user at index i of an
+// import, numbered as spreadsheets do, from the header's 1.
+func csvRow(i int) int {
+	return i + 2
+}
+
+// readCSVUsers reads a header row and then one user per row. Missing
+// trailing cells are read as empty, for validation to report. It also
+// returns the fields the header has a column for, so that updated users
+// keep the others.
+func readCSVUsers(body io.Reader, query url.Values) ([]user.User, []string, error) {
+	in := csv.NewReader(body)
+	in.FieldsPerRecord = -1
+	header, err := in.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil, errors.New("the CSV file is empty")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	columns, err := mapColumns(header, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	attributes := attributeColumns(header)
+	var fields []string
+	for _, field := range csvFields {
+		if _, ok := columns[field]; ok {
+			fields = append(fields, field)
+		}
+	}
+	for name := range attributes {
+		fields = append(fields, attributeFilterPrefix+name)
+	}
+
+	var users []user.User
+	for {
+		record, err := in.Read()
+		if errors.Is(err, io.EOF) {
+			return users, fields, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		cell := func(field string) string {
+			i, ok := columns[field]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return record[i]
+		}
+		u := user.User{
+			ExternalID:     cell("externalId"),
+			FirstName:      cell("firstName"),
+			LastName:       cell("lastName"),
+			Title:          cell("title"),
+			Department:     cell("department"),
+			EmployeeNumber: cell("employeeNumber"),
+			StartDate:      cell("startDate"),
+		}
+		if email := cell("email"); email != "" {
+			u.Emails = []user.Email{{Address: email, Primary: true}}
+		}
+		if phone := cell("phone"); phone != "" {
+			u.Phones = []user.Phone{{Number: phone, Primary: true}}
+		}
+		if raw := strings.TrimSpace(cell("managerId")); raw != "" {
+			// An ID that does not parse is left as 0 for validation to
+			// reject along with the row's other mistakes.
+			id, _ := strconv.ParseInt(raw, 10, 64)
+			u.ManagerID = &id
+		}
+		for name, i := range attributes {
+			if i < len(record) && record[i] != "" {
+				if u.Attributes == nil {
+					u.Attributes = map[string]string{}
+				}
+				u.Attributes[name] = record[i]
+			}
+		}
+		users = append(users, u)
+		if len(users) > user.MaxImportSize {
+			return nil, nil, fmt.Errorf("imports are limited to %d rows", user.MaxImportSize)
+		}
+	}
+}
+
+// mapColumns finds the index of each field's column in header. The names
+// are required to be there; the other columns can be left out.
+func mapColumns(header []string, query url.Values) (map[string]int, error) {
+	var params []string
+	for param := range query {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	var fields []domain.FieldError
+	for _, param := range params {
+		if field := strings.TrimPrefix(param, "map."); field != param && !isCSVField(field) {
+			fields = append(fields, domain.FieldError{Field: param, Message: "is not a field that can be imported"})
+		}
+	}
+
+	positions := map[string]int{}
+	for i, name := range header {
+		if i == 0 {
+			// Spreadsheets like to start UTF-8 files with a byte order mark.
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		if _, ok := positions[columnKey(name)]; !ok {
+			positions[columnKey(name)] = i
+		}
+	}
+
+	columns := map[string]int{}
+	for _, field := range csvFields {
+		name := field
+		if mapped := query.Get("map." + field); mapped != "" {
+			name = mapped
+		}
+		i, ok := positions[columnKey(name)]
+		switch {
+		case ok:
+			columns[field] = i
+		case name != field:
+			fields = append(fields, domain.FieldError{Field: "map." + field, Message: fmt.Sprintf("names column %q, which is not in the header", name)})
+		case field == "firstName" || field == "lastName":
+			fields = append(fields, domain.FieldError{Field: field, Message: "has no column in the header"})
+		}
+	}
+	if len(fields) > 0 {
+		return nil, &domain.ValidationError{Fields: fields}
+	}
+	return columns, nil
+}
+
+// attributeColumns finds the index of the column of each attribute in
+// header, by its name after the attr. prefix, which ignores case.
+func attributeColumns(header []string) map[string]int {
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if len(name) <= len(attributeFilterPrefix) || !strings.EqualFold(name[:len(attributeFilterPrefix)], attributeFilterPrefix) {
+			continue
+		}
+		if _, ok := columns[name[len(attributeFilterPrefix):]]; !ok {
+			columns[name[len(attributeFilterPrefix):]] = i
+		}
+	}
+	return columns
+}
+
+func primaryEmail(u user.User) string {
+	e, _ := u.PrimaryEmail()
+	return e.Address
+}
+
+func primaryPhone(u user.User) string {
+	for _, p := range u.Phones {
+		if p.Primary {
+			return p.Number
+		}
+	}
+	return ""
+}
+
+func managerID(u user.User) string {
+	if u.ManagerID == nil {
+		return ""
+	}
+	return strconv.FormatInt(*u.ManagerID, 10)
+}
+
+func isCSVField(name string) bool {
+	for _, field := range csvFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// columnKey folds the differences between the ways people write a column
+// name, so First Name, first_name and firstName all match.
+func columnKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '_', '-':
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(name)))
+}
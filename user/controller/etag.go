@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/user"
+)
+
+// etag is the strong entity tag of a user: its quoted version.
+func etag(u user.User) string {
+	return `"` + strconv.FormatInt(u.Version, 10) + `"`
+}
+
+// ifMatch reads the version a PUT, PATCH or DELETE is conditional on from the
+// If-Match header. It returns zero for an unconditional request, which is an
+// error when c.RequireIfMatch is set. The returned status applies to a
+// non-nil error.
+//
+// Only "*" or a single entity tag are accepted, since a user has a single
+// current version to compare against. Weak tags never match (RFC 7232
+// section 3.1).
+func (c *Controller) ifMatch(r *http.Request) (int64, int, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	switch {
+	case header == "":
+		if c.RequireIfMatch {
+			return 0, http.StatusPreconditionRequired,
+				errors.New("request must be conditional; send If-Match with the ETag returned by GET")
+		}
+		return 0, 0, nil
+	case header == "*":
+		return 0, 0, nil
+	case strings.HasPrefix(header, "W/"):
+		return 0, http.StatusPreconditionFailed,
+			fmt.Errorf("weak entity tag %s cannot match: %w", header, domain.ErrPreconditionFailed)
+	}
+	version, err := parseETag(header)
+	if err != nil {
+		return 0, http.StatusBadRequest, errors.New(`the If-Match header must be "*" or a single entity tag`)
+	}
+	return version, 0, nil
+}
+
+// notModified reports whether the If-None-Match header of a GET matches the
+// user's current entity tag, using weak comparison.
+func notModified(r *http.Request, u user.User) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	current := etag(u)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+func parseETag(tag string) (int64, error) {
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, fmt.Errorf("malformed entity tag %s", tag)
+	}
+	version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("malformed entity tag %s", tag)
+	}
+	return version, nil
+}
@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/internal/problem"
+	"github.com/pmaterer/peopler/user"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+const (
+	// maxNDJSONLine is the longest line an NDJSON import accepts, in bytes.
+	maxNDJSONLine = 64 * 1024
+	// maxReportedFailures caps the failed lines an NDJSON import lists, so
+	// the response stays small however bad the input is.
+	maxReportedFailures = 100
+	// streamFlushInterval is how many users a stream writes between flushes.
+	streamFlushInterval = 100
+)
+
+// acceptsNDJSON reports whether the Accept header asks for NDJSON.
+func acceptsNDJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, _, err := mime.ParseMediaType(mediaRange)
+			if err == nil && mediaType == ndjsonContentType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// streamUsers writes every user matching opts as a line of JSON, straight
+// from the database. The limit and cursor parameters are ignored.
+func (c *Controller) streamUsers(w http.ResponseWriter, r *http.Request, opts user.ListOptions) {
+	opts.After = nil
+	w.Header().Set("Content-Type", ndjsonContentType)
+	flusher, _ := w.(http.Flusher)
+	out := json.NewEncoder(w)
+	var written int
+	err := c.service.StreamUsers(r.Context(), opts, func(u user.User) error {
+		if err := out.Encode(u); err != nil {
+			return err
+		}
+		written++
+		if flusher != nil && written%streamFlushInterval == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if written == 0 {
+			// Nothing was sent yet, so the error can still be reported.
+			problem.WriteError(w, r, err)
+			return
+		}
+		// The status is already sent, so all we can do is cut the stream
+		// short.
+		log.Printf("request %s: streaming users: %v\n", problem.RequestID(r), err)
+	}
+}
+
+// importNDJSON upserts one user per line of the body. Blank lines are
+// skipped. Only failed lines are reported, numbered from 1.
+func (c *Controller) importNDJSON(w http.ResponseWriter, r *http.Request, atomic, dryRun bool) {
+	in := bufio.NewScanner(r.Body)
+	in.Buffer(make([]byte, 0, 4096), maxNDJSONLine)
+	var line int
+	next := func() (user.ImportRecord, error) {
+		for in.Scan() {
+			line++
+			text := bytes.TrimSpace(in.Bytes())
+			if len(text) == 0 {
+				continue
+			}
+			var u user.User
+			if err := json.Unmarshal(text, &u); err != nil {
+				return user.ImportRecord{Line: line}, &domain.ValidationError{Fields: []domain.FieldError{
+					{Field: "line", Message: fmt.Sprintf("is not a JSON user: %v", err)},
+				}}
+			}
+			// Lines of an NDJSON export can be imported as they are.
+			u.ID, u.DeletedAt = 0, nil
+			return user.ImportRecord{User: u, Line: line}, nil
+		}
+		if err := in.Err(); err != nil {
+			return user.ImportRecord{}, err
+		}
+		return user.ImportRecord{}, io.EOF
+	}
+
+	response := ImportResponse{DryRun: dryRun, Rows: []ImportRow{}}
+	failed := func(record user.ImportRecord, result user.BatchResult) {
+		if len(response.Rows) < maxReportedFailures {
+			response.Rows = append(response.Rows, ImportRow{Line: record.Line, ExternalID: record.User.ExternalID, BatchItem: newBatchItem(user.BatchUpsert, result)})
+		}
+	}
+	summary, err := c.service.StreamImportUsers(r.Context(), next, atomic, dryRun, origin(r), failed)
+	if errors.Is(err, bufio.ErrTooLong) {
+		problem.Write(w, r, http.StatusBadRequest, fmt.Errorf("line %d is longer than %d bytes", line+1, maxNDJSONLine))
+		return
+	}
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	response.Created, response.Updated, response.Failed = summary.Created, summary.Updated, summary.Failed
+	response.Aborted = summary.Aborted
+	writeResponse(w, http.StatusOK, response)
+}
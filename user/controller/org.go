@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/internal/problem"
+	"github.com/pmaterer/peopler/user"
+)
+
+// GetReports lists the users managed by the user as trees, the direct
+// reports only unless the depth parameter asks for more levels.
+func (c *Controller) GetReports() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		depth, err := parseDepth(r.URL.Query(), user.DefaultReportsDepth)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		reports, err := c.service.GetReports(r.Context(), int64(id), depth)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		if reports == nil {
+			reports = []user.OrgNode{}
+		}
+		writeResponse(w, http.StatusOK, ListResponse{Data: reports, Total: int64(len(reports))})
+	}
+}
+
+// GetManagementChain lists the user's managers, their direct manager first.
+func (c *Controller) GetManagementChain() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		chain, err := c.service.GetManagementChain(r.Context(), int64(id))
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		if chain == nil {
+			chain = []user.User{}
+		}
+		writeResponse(w, http.StatusOK, ListResponse{Data: chain, Total: int64(len(chain))})
+	}
+}
+
+// GetOrgChart lists the whole organisation as trees rooted at the users
+// without a manager, every level deep unless the depth parameter says
+// otherwise.
+func (c *Controller) GetOrgChart() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		depth, err := parseDepth(r.URL.Query(), user.MaxOrgDepth)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		chart, err := c.service.GetOrgChart(r.Context(), depth)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		if chart == nil {
+			chart = []user.OrgNode{}
+		}
+		writeResponse(w, http.StatusOK, ListResponse{Data: chart, Total: int64(len(chart))})
+	}
+}
+
+// parseDepth reads the optional depth query parameter.
+func parseDepth(query url.Values, fallback int) (int, error) {
+	raw := query.Get("depth")
+	if raw == "" {
+		return fallback, nil
+	}
+	depth, err := strconv.Atoi(raw)
+	if err != nil || depth < 1 || depth > user.MaxOrgDepth {
+		return 0, &domain.ValidationError{Fields: []domain.FieldError{
+			{Field: "depth", Message: fmt.Sprintf("must be a number between 1 and %d", user.MaxOrgDepth)},
+		}}
+	}
+	return depth, nil
+}
@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pmaterer/peopler/internal/problem"
+	"github.com/pmaterer/peopler/user"
+)
+
+// photoCacheControl lets clients keep a photo but makes them check it is
+// still current, which If-None-Match answers cheaply. Photos are personal,
+// so shared caches must not keep them.
+const photoCacheControl = "private, no-cache"
+
+// PutUserPhoto sets the user's photo from the raw JPEG or PNG image in the
+// body. The type is sniffed from the image itself; the Content-Type header
+// is not trusted.
+func (c *Controller) PutUserPhoto() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+		data, err := ioutil.ReadAll(io.LimitReader(r.Body, user.MaxPhotoBytes+1))
+		if err != nil {
+			problem.Write(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if len(data) > user.MaxPhotoBytes {
+			problem.Write(w, r, http.StatusRequestEntityTooLarge,
+				fmt.Errorf("photos must be at most %d MiB", user.MaxPhotoBytes>>20))
+			return
+		}
+		if user.PhotoContentType(data) == "" {
+			problem.Write(w, r, http.StatusUnsupportedMediaType,
+				fmt.Errorf("photos must be %s or %s images", user.PhotoJPEG, user.PhotoPNG))
+			return
+		}
+		if err := c.service.PutUserPhoto(r.Context(), int64(id), data); err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		writeResponse(w, http.StatusOK, Response{Message: "OK"})
+	}
+}
+
+// GetUserPhoto serves the user's photo in the size given by the size
+// parameter: original, medium or small. It answers conditional and range
+// requests, with an entity tag derived from the image.
+func (c *Controller) GetUserPhoto() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		size, err := user.ParsePhotoSize(r.URL.Query().Get("size"))
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		photo, err := c.service.GetUserPhoto(r.Context(), int64(id), size)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		sum := sha256.Sum256(photo)
+		w.Header().Set("Content-Type", user.PhotoContentType(photo))
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+		w.Header().Set("Cache-Control", photoCacheControl)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(photo))
+	}
+}
+
+func (c *Controller) DeleteUserPhoto() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if err := c.service.DeleteUserPhoto(r.Context(), int64(id)); err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		writeResponse(w, http.StatusOK, Response{Message: "OK"})
+	}
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/pmaterer/peopler/internal/problem"
+)
+
+// Values of the tagMatch parameter of listings, which decides whether users
+// need all the tags given or any of them.
+const (
+	tagMatchAll = "all"
+	tagMatchAny = "any"
+)
+
+func (c *Controller) TagUser() func(w http.ResponseWriter, r *http.Request) {
+	return c.changeTags(c.service.TagUser)
+}
+
+func (c *Controller) UntagUser() func(w http.ResponseWriter, r *http.Request) {
+	return c.changeTags(c.service.UntagUser)
+}
+
+// changeTags applies change to the user and tag in the path.
+func (c *Controller) changeTags(change func(ctx context.Context, id int64, tag string) error) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if err := change(r.Context(), int64(id), vars["tag"]); err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		writeResponse(w, http.StatusOK, Response{Message: "OK"})
+	}
+}
+
+func (c *Controller) GetUserTags() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err)
+			return
+		}
+		tags, err := c.service.GetUserTags(r.Context(), int64(id))
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		writeResponse(w, http.StatusOK, ListResponse{Data: tags, Total: int64(len(tags))})
+	}
+}
+
+// GetTags lists every tag in use, with how many people have it.
+func (c *Controller) GetTags() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tags, err := c.service.GetTags(r.Context())
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		writeResponse(w, http.StatusOK, ListResponse{Data: tags, Total: int64(len(tags))})
+	}
+}
@@ -0,0 +1,136 @@
+package user
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pmaterer/peopler/domain"
+)
+
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 500
+)
+
+// Sortable fields, named as in the JSON contract.
+const (
+	SortByID        = "id"
+	SortByFirstName = "firstName"
+	SortByLastName  = "lastName"
+)
+
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// ListOptions selects one page of users. The zero value lists the first
+// DefaultListLimit users ordered by ID.
+type ListOptions struct {
+	Limit int
+	// After is the position of the last user on the previous page, or nil
+	// for the first page.
+	After *Cursor
+	Sort  []SortField
+	// FirstNamePrefix and LastNamePrefix keep only users whose names start
+	// with the given text, ignoring case.
+	FirstNamePrefix string
+	LastNamePrefix  string
+	// Attributes keeps only users whose attributes have exactly the given
+	// values, by name.
+	Attributes map[string]string
+	// Tags keeps only users with every one of the tags, or with any of them
+	// if AnyTag is set.
+	Tags   []string
+	AnyTag bool
+	// IncludeDeleted lists deleted users alongside live ones.
+	IncludeDeleted bool
+}
+
+// WithDefaults fills in the page size and makes the ordering total by
+// breaking ties on ID, which keyset pagination relies on.
+func (o ListOptions) WithDefaults() ListOptions {
+	if o.Limit <= 0 {
+		o.Limit = DefaultListLimit
+	}
+	if o.Limit > MaxListLimit {
+		o.Limit = MaxListLimit
+	}
+	sort := make([]SortField, 0, len(o.Sort)+1)
+	for _, f := range o.Sort {
+		sort = append(sort, f)
+		if f.Field == SortByID {
+			o.Sort = sort
+			return o
+		}
+	}
+	o.Sort = append(sort, SortField{Field: SortByID})
+	return o
+}
+
+type Page struct {
+	Users []User
+	// Total counts every user matching the filters, across all pages.
+	Total int64
+	// Next is the cursor for the following page, or nil on the last page.
+	Next *Cursor
+}
+
+// ParseSort parses a comma separated list of sortable fields, each
+// optionally prefixed with - for descending order, e.g. "lastName,-id".
+func ParseSort(s string) ([]SortField, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var sort []SortField
+	for _, name := range strings.Split(s, ",") {
+		f := SortField{Field: name}
+		if strings.HasPrefix(name, "-") {
+			f = SortField{Field: name[1:], Descending: true}
+		}
+		switch f.Field {
+		case SortByID, SortByFirstName, SortByLastName:
+		default:
+			return nil, &domain.ValidationError{Fields: []domain.FieldError{
+				{Field: "sort", Message: fmt.Sprintf("cannot sort by %q", f.Field)},
+			}}
+		}
+		sort = append(sort, f)
+	}
+	return sort, nil
+}
+
+// Cursor is the position of a user within a sorted listing. It holds every
+// sortable value of that user, so it stays meaningful whatever the sort.
+type Cursor struct {
+	ID        int64  `json:"i"`
+	FirstName string `json:"f"`
+	LastName  string `json:"l"`
+}
+
+func CursorFor(u User) *Cursor {
+	return &Cursor{ID: u.ID, FirstName: u.FirstName, LastName: u.LastName}
+}
+
+// Encode renders the cursor as an opaque, URL safe token.
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	invalid := &domain.ValidationError{Fields: []domain.FieldError{
+		{Field: "cursor", Message: "is not a valid cursor"},
+	}}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, invalid
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, invalid
+	}
+	return &c, nil
+}
@@ -0,0 +1,60 @@
+package user
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSort(t *testing.T) {
+	sort, err := ParseSort("lastName,-id")
+	assert.Nil(t, err)
+	assert.Equal(t, []SortField{
+		{Field: SortByLastName},
+		{Field: SortByID, Descending: true},
+	}, sort)
+
+	sort, err = ParseSort("")
+	assert.Nil(t, err)
+	assert.Nil(t, sort)
+
+	_, err = ParseSort("lastName,-password")
+	assert.True(t, errors.Is(err, domain.ErrValidation))
+}
+
+func TestListOptionsWithDefaults(t *testing.T) {
+	opts := ListOptions{}.WithDefaults()
+	assert.Equal(t, DefaultListLimit, opts.Limit)
+	assert.Equal(t, []SortField{{Field: SortByID}}, opts.Sort)
+
+	opts = ListOptions{
+		Limit: MaxListLimit + 1,
+		Sort:  []SortField{{Field: SortByLastName, Descending: true}},
+	}.WithDefaults()
+	assert.Equal(t, MaxListLimit, opts.Limit)
+	assert.Equal(t, []SortField{
+		{Field: SortByLastName, Descending: true},
+		{Field: SortByID},
+	}, opts.Sort)
+
+	opts = ListOptions{
+		Sort: []SortField{
+			{Field: SortByID, Descending: true},
+			{Field: SortByLastName},
+		},
+	}.WithDefaults()
+	assert.Equal(t, []SortField{{Field: SortByID, Descending: true}}, opts.Sort)
+}
+
+func TestCursor(t *testing.T) {
+	c := CursorFor(User{ID: 3, FirstName: "Haruki", LastName: "Murakami"})
+
+	decoded, err := DecodeCursor(c.Encode())
+	assert.Nil(t, err)
+	assert.Equal(t, c, decoded)
+
+	_, err = DecodeCursor("not a cursor")
+	assert.True(t, errors.Is(err, domain.ErrValidation))
+}
@@ -0,0 +1,105 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/pmaterer/peopler/domain"
+)
+
+const (
+	// DefaultReportsDepth is how many levels of reports are listed unless
+	// asked otherwise: the direct reports.
+	DefaultReportsDepth = 1
+	// MaxOrgDepth is the most levels of management listed at once.
+	MaxOrgDepth = 32
+	// MaxOrgChartSize is the most users listed at once as reports or as
+	// the org chart.
+	MaxOrgChartSize = 10000
+)
+
+// OrgNode is a user in an org chart, with the users reporting to them.
+type OrgNode struct {
+	User
+	Reports []OrgNode `json:"reports,omitempty"`
+}
+
+// OrgTree arranges users, as returned by Repository.Reports for managerID,
+// into trees. Their roots report to managerID, or for a managerID of 0 have
+// no manager among users.
+func OrgTree(users []User, managerID int64) []OrgNode {
+	listed := make(map[int64]bool, len(users))
+	for _, u := range users {
+		listed[u.ID] = true
+	}
+	reports := map[int64][]User{}
+	var roots []User
+	for _, u := range users {
+		switch {
+		case managerID == 0 && (u.ManagerID == nil || !listed[*u.ManagerID]):
+			roots = append(roots, u)
+		case u.ManagerID != nil && *u.ManagerID == managerID:
+			roots = append(roots, u)
+		case u.ManagerID != nil:
+			reports[*u.ManagerID] = append(reports[*u.ManagerID], u)
+		}
+	}
+
+	var grow func(users []User) []OrgNode
+	grow = func(users []User) []OrgNode {
+		nodes := make([]OrgNode, len(users))
+		for i, u := range users {
+			nodes[i] = OrgNode{User: u, Reports: grow(reports[u.ID])}
+		}
+		return nodes
+	}
+	return grow(roots)
+}
+
+// SameManager reports whether two manager IDs name the same manager, or are
+// both unset. Updates only check a manager that changes, so that the reports
+// of a deleted manager can still be edited.
+func SameManager(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// CheckManager reports, as a *domain.ValidationError, a managerID that is not
+// a live user or that would put the user with the given ID in their own
+// management chain. Deleted managers count towards the chain, since
+// restoring one would bring the cycle back. id is 0 for a user not created
+// yet. It may run before or after the user is written within the same
+// transaction: either way a cycle shows up as id above managerID.
+func CheckManager(ctx context.Context, r Repository, id int64, managerID *int64) error {
+	if managerID == nil {
+		return nil
+	}
+	invalid := func(message string) error {
+		return &domain.ValidationError{Fields: []domain.FieldError{{Field: "managerId", Message: message}}}
+	}
+	if *managerID == id {
+		return invalid("must not be the user themself")
+	}
+	if _, err := r.GetUser(ctx, *managerID); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return invalid(fmt.Sprintf("user #%d does not exist", *managerID))
+		}
+		return err
+	}
+	if id == 0 {
+		return nil
+	}
+	managerIDs, err := r.ManagerIDs(ctx, *managerID)
+	if err != nil {
+		return err
+	}
+	for _, above := range managerIDs {
+		if above == id {
+			return invalid(fmt.Sprintf("user #%d reports to user #%d, which would make a cycle", *managerID, id))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+package user
+
+// Media types of the patch documents accepted for partial updates.
+const (
+	// MergePatchType is an RFC 7396 JSON merge patch.
+	MergePatchType = "application/merge-patch+json"
+	// JSONPatchType is an RFC 6902 JSON patch.
+	JSONPatchType = "application/json-patch+json"
+)
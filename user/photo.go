@@ -0,0 +1,182 @@
+package user
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/pmaterer/peopler/domain"
+)
+
+const (
+	// MaxPhotoBytes is the largest photo upload accepted.
+	MaxPhotoBytes = 5 << 20
+	// MaxPhotoDimension is the widest or tallest photo accepted, in pixels.
+	MaxPhotoDimension = 4096
+
+	// PhotoJPEG and PhotoPNG are the content types photos are accepted and
+	// served in.
+	PhotoJPEG = "image/jpeg"
+	PhotoPNG  = "image/png"
+
+	// photoQuality is the quality JPEG photos are encoded at.
+	photoQuality = 85
+)
+
+// PhotoSize names a rendition of a user's photo.
+type PhotoSize string
+
+const (
+	// PhotoOriginal is the photo as uploaded, re-encoded without its
+	// metadata.
+	PhotoOriginal PhotoSize = "original"
+	// PhotoMedium and PhotoSmall are square thumbnails cut from the centre
+	// of the photo.
+	PhotoMedium PhotoSize = "medium"
+	PhotoSmall  PhotoSize = "small"
+)
+
+// PhotoSizes lists every rendition a photo is stored in.
+var PhotoSizes = []PhotoSize{PhotoOriginal, PhotoMedium, PhotoSmall}
+
+// thumbnailSides holds the side of each square thumbnail, in pixels.
+// Photos smaller than a thumbnail are not enlarged to fit it.
+var thumbnailSides = map[PhotoSize]int{
+	PhotoMedium: 256,
+	PhotoSmall:  64,
+}
+
+// ParsePhotoSize reads the size of a photo asked for, which defaults to
+// PhotoOriginal.
+func ParsePhotoSize(s string) (PhotoSize, error) {
+	if s == "" {
+		return PhotoOriginal, nil
+	}
+	for _, size := range PhotoSizes {
+		if PhotoSize(s) == size {
+			return size, nil
+		}
+	}
+	return "", &domain.ValidationError{Fields: []domain.FieldError{
+		{Field: "size", Message: "must be one of original, medium or small"},
+	}}
+}
+
+// PhotoContentType sniffs the content type of a photo from its first bytes,
+// returning "" unless it is a JPEG or PNG image. The name a client gives the
+// file, or the type it claims, counts for nothing.
+func PhotoContentType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xFF\xD8\xFF")):
+		return PhotoJPEG
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1A\n")):
+		return PhotoPNG
+	default:
+		return ""
+	}
+}
+
+// RenderPhoto checks an uploaded photo and renders it in every one of
+// PhotoSizes, in the format it was uploaded in. Photos that are too large,
+// or are not JPEG or PNG images, are reported as a
+// *domain.ValidationError.
+func RenderPhoto(data []byte) (map[PhotoSize][]byte, error) {
+	if len(data) > MaxPhotoBytes {
+		return nil, photoError(fmt.Sprintf("must be at most %d MiB", MaxPhotoBytes>>20))
+	}
+	var decodeConfig func(io.Reader) (image.Config, error)
+	var decode func(io.Reader) (image.Image, error)
+	var encode func(io.Writer, image.Image) error
+	switch PhotoContentType(data) {
+	case PhotoJPEG:
+		decodeConfig, decode = jpeg.DecodeConfig, jpeg.Decode
+		encode = func(w io.Writer, m image.Image) error {
+			return jpeg.Encode(w, m, &jpeg.Options{Quality: photoQuality})
+		}
+	case PhotoPNG:
+		decodeConfig, decode, encode = png.DecodeConfig, png.Decode, png.Encode
+	default:
+		return nil, photoError("must be a JPEG or PNG image")
+	}
+
+	// The header gives the dimensions away before the pixels are decoded,
+	// which is what keeps a small file from claiming gigabytes of memory.
+	config, err := decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, photoError("must be a valid JPEG or PNG image")
+	}
+	if config.Width > MaxPhotoDimension || config.Height > MaxPhotoDimension {
+		return nil, photoError(fmt.Sprintf("must be at most %dx%d pixels", MaxPhotoDimension, MaxPhotoDimension))
+	}
+	if config.Width == 0 || config.Height == 0 {
+		return nil, photoError("must not be empty")
+	}
+	m, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, photoError("must be a valid JPEG or PNG image")
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, m.Bounds().Dx(), m.Bounds().Dy()))
+	draw.Draw(src, src.Bounds(), m, m.Bounds().Min, draw.Src)
+	renditions := make(map[PhotoSize][]byte, len(PhotoSizes))
+	for _, size := range PhotoSizes {
+		var rendition image.Image = src
+		if side, ok := thumbnailSides[size]; ok {
+			rendition = thumbnail(src, side)
+		}
+		var buf bytes.Buffer
+		if err := encode(&buf, rendition); err != nil {
+			return nil, err
+		}
+		renditions[size] = buf.Bytes()
+	}
+	return renditions, nil
+}
+
+// thumbnail cuts the largest square it can from the centre of src and
+// shrinks it to side pixels, averaging the pixels each one covers.
+func thumbnail(src *image.RGBA, side int) *image.RGBA {
+	bounds := src.Bounds()
+	crop := bounds.Dx()
+	if bounds.Dy() < crop {
+		crop = bounds.Dy()
+	}
+	if side > crop {
+		side = crop
+	}
+	x0 := bounds.Min.X + (bounds.Dx()-crop)/2
+	y0 := bounds.Min.Y + (bounds.Dy()-crop)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	for dy := 0; dy < side; dy++ {
+		top, bottom := y0+dy*crop/side, y0+(dy+1)*crop/side
+		for dx := 0; dx < side; dx++ {
+			left, right := x0+dx*crop/side, x0+(dx+1)*crop/side
+			var sum [4]int
+			for y := top; y < bottom; y++ {
+				row := src.Pix[src.PixOffset(left, y):src.PixOffset(right, y)]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (bottom - top) * (right - left)
+			i := dst.PixOffset(dx, dy)
+			for c := range sum {
+				dst.Pix[i+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
+
+// photoError reports a rejected photo.
+func photoError(message string) error {
+	return &domain.ValidationError{Fields: []domain.FieldError{{Field: "photo", Message: message}}}
+}
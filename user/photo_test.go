@@ -0,0 +1,91 @@
+package user
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderPhoto(t *testing.T) {
+	// A 400x200 PNG, red on the left half and blue on the right.
+	m := image.NewNRGBA(image.Rect(0, 0, 400, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 400; x++ {
+			if x < 200 {
+				m.Set(x, y, color.NRGBA{R: 255, A: 255})
+			} else {
+				m.Set(x, y, color.NRGBA{B: 255, A: 255})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	assert.Nil(t, png.Encode(&buf, m))
+
+	renditions, err := RenderPhoto(buf.Bytes())
+	assert.Nil(t, err)
+	for size, side := range map[PhotoSize]image.Point{
+		PhotoOriginal: {400, 200},
+		PhotoMedium:   {200, 200},
+		PhotoSmall:    {64, 64},
+	} {
+		assert.Equal(t, PhotoPNG, PhotoContentType(renditions[size]), size)
+		config, err := png.DecodeConfig(bytes.NewReader(renditions[size]))
+		assert.Nil(t, err)
+		assert.Equal(t, side, image.Pt(config.Width, config.Height), size)
+	}
+	small, err := png.Decode(bytes.NewReader(renditions[PhotoSmall]))
+	assert.Nil(t, err)
+	r, _, b, _ := small.At(0, 0).RGBA()
+	assert.Equal(t, [2]uint32{0xffff, 0}, [2]uint32{r, b}, "the thumbnail is cut from the centre")
+	r, _, b, _ = small.At(63, 0).RGBA()
+	assert.Equal(t, [2]uint32{0, 0xffff}, [2]uint32{r, b})
+
+	buf.Reset()
+	assert.Nil(t, jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 32, 32)), nil))
+	renditions, err = RenderPhoto(buf.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, PhotoJPEG, PhotoContentType(renditions[PhotoSmall]))
+}
+
+func TestRenderPhotoRejects(t *testing.T) {
+	var huge bytes.Buffer
+	assert.Nil(t, png.Encode(&huge, image.NewGray(image.Rect(0, 0, MaxPhotoDimension+1, 1))))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		message string
+	}{
+		{"GIF", []byte("GIF89a\x01\x00\x01\x00"), "must be a JPEG or PNG image"},
+		{"Truncated", []byte("\x89PNG\r\n\x1A\n\x00\x00"), "must be a valid JPEG or PNG image"},
+		{"Too wide", huge.Bytes(), "must be at most 4096x4096 pixels"},
+		{"Too large", append([]byte("\xFF\xD8\xFF"), make([]byte, MaxPhotoBytes)...), "must be at most 5 MiB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := RenderPhoto(tt.data)
+			var validationErr *domain.ValidationError
+			assert.True(t, errors.As(err, &validationErr))
+			assert.Equal(t, []domain.FieldError{{Field: "photo", Message: tt.message}}, validationErr.Fields)
+		})
+	}
+}
+
+func TestParsePhotoSize(t *testing.T) {
+	size, err := ParsePhotoSize("")
+	assert.Nil(t, err)
+	assert.Equal(t, PhotoOriginal, size)
+	size, err = ParsePhotoSize("small")
+	assert.Nil(t, err)
+	assert.Equal(t, PhotoSmall, size)
+	_, err = ParsePhotoSize("huge")
+	assert.True(t, errors.Is(err, domain.ErrValidation))
+}
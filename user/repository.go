@@ -0,0 +1,79 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/pmaterer/peopler/audit"
+)
+
+// Repository is the storage contract the user service depends on. Every
+// backend implements it.
+type Repository interface {
+	CreateUser(ctx context.Context, u User, origin audit.Origin) (int64, error)
+	GetAllUsers(ctx context.Context, opts ListOptions) (Page, error)
+	// StreamUsers calls fn with every user matching opts, in order, starting
+	// after opts.After. opts.Limit is ignored. It stops at the first error
+	// fn returns and returns it.
+	StreamUsers(ctx context.Context, opts ListOptions, fn func(User) error) error
+	GetUser(ctx context.Context, id int64) (User, error)
+	// GetUsers returns the live users among ids, in the order of ids.
+	// Missing and deleted users are left out rather than reported.
+	GetUsers(ctx context.Context, ids []int64) ([]User, error)
+	GetUserByExternalID(ctx context.Context, externalID string) (User, error)
+	SearchUsers(ctx context.Context, terms []string, limit int) (Page, error)
+	UpdateUser(ctx context.Context, u User, origin audit.Origin) (int64, error)
+	ModifyUser(ctx context.Context, id int64, origin audit.Origin, modify func(User) (User, error)) (User, error)
+	DeleteUser(ctx context.Context, id, version int64, origin audit.Origin) (int64, error)
+	RestoreUser(ctx context.Context, id int64, origin audit.Origin) (int64, error)
+	// PurgeUsers permanently removes the users deleted before the given
+	// time and returns their IDs in order.
+	PurgeUsers(ctx context.Context, deletedBefore time.Time) ([]int64, error)
+	// Reports returns the live users managed by managerID, directly or
+	// through up to depth levels of management, shallowest first, and at
+	// most limit of them. A managerID of 0 starts from the top of the
+	// organisation: the users without a live manager.
+	Reports(ctx context.Context, managerID int64, depth, limit int) ([]User, error)
+	// ManagementChain returns the live managers above the user, from their
+	// direct manager up to the top. It stops at a deleted manager.
+	ManagementChain(ctx context.Context, id int64) ([]User, error)
+	// ManagerIDs returns the IDs of every manager above the user, in no
+	// particular order. Unlike ManagementChain it carries on past deleted
+	// managers, who may be restored, and past purged ones a user still
+	// names. It stops should the managers go round in a circle.
+	ManagerIDs(ctx context.Context, id int64) ([]int64, error)
+	// AttributeDefinitions returns every attribute definition, ordered by
+	// name.
+	AttributeDefinitions(ctx context.Context) ([]AttributeDefinition, error)
+	// PutAttributeDefinition creates the definition, or replaces the one of
+	// the same name, and reports whether it was created. The values users
+	// already have are not checked against it.
+	PutAttributeDefinition(ctx context.Context, d AttributeDefinition) (bool, error)
+	// DeleteAttributeDefinition removes the definition and the attribute of
+	// every user that has it, auditing each change as made by origin.
+	DeleteAttributeDefinition(ctx context.Context, name string, origin audit.Origin) error
+	// TagUser tags the live user, creating the tag if nobody had it yet.
+	// Tagging a user twice changes nothing.
+	TagUser(ctx context.Context, id int64, tag string) error
+	// UntagUser removes the tag from the live user, and the tag itself once
+	// nobody has it.
+	UntagUser(ctx context.Context, id int64, tag string) error
+	// UserTags returns the tags of the live user, in order.
+	UserTags(ctx context.Context, id int64) ([]string, error)
+	// Tags returns every tag that live users have, in order, with how many
+	// of them have it.
+	Tags(ctx context.Context) ([]TagCount, error)
+	UserHistory(ctx context.Context, id int64) ([]audit.Event, error)
+	AuditEvents(ctx context.Context, q audit.Query) (audit.Page, error)
+	// Batch applies ops in order in one transaction and returns a result per
+	// operation. With atomic set, the first failure rolls back the whole
+	// batch; otherwise only the failed operations are undone. The error is
+	// only set if the batch could not run at all.
+	Batch(ctx context.Context, ops []BatchOperation, atomic bool, origin audit.Origin) ([]BatchResult, error)
+	// WithTx runs fn as a unit of work against a repository scoped to one
+	// transaction. The transaction commits if fn returns nil and rolls back
+	// otherwise. fn may run more than once if the backend retries transient
+	// failures, so it must not have side effects outside the repository.
+	// Calling WithTx on the repository passed to fn joins the transaction.
+	WithTx(ctx context.Context, fn func(Repository) error) error
+}
@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/user"
+)
+
+func (r *Reopository) AttributeDefinitions(ctx context.Context) ([]user.AttributeDefinition, error) {
+	query := `SELECT name, type, required, enum_values, pattern, description FROM attribute_definitions ORDER BY name`
+	rows, err := r.conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	defs := []user.AttributeDefinition{}
+	for rows.Next() {
+		var d user.AttributeDefinition
+		var enum string
+		if err := rows.Scan(&d.Name, &d.Type, &d.Required, &enum, &d.Pattern, &d.Description); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(enum), &d.Enum); err != nil {
+			return nil, fmt.Errorf("enum values of attribute %q: %w", d.Name, err)
+		}
+		if len(d.Enum) == 0 {
+			d.Enum = nil
+		}
+		defs = append(defs, d)
+	}
+	return defs, rows.Err()
+}
+
+// PutAttributeDefinition stores the enum values as a JSON array, as neither
+// database needs to look into them.
+func (r *Reopository) PutAttributeDefinition(ctx context.Context, d user.AttributeDefinition) (bool, error) {
+	enum, err := json.Marshal(append([]string{}, d.Enum...))
+	if err != nil {
+		return false, err
+	}
+	var created bool
+	err = r.inTx(ctx, func(tx *Reopository) error {
+		exists, err := tx.attributeDefined(ctx, d.Name)
+		if err != nil {
+			return err
+		}
+		created = !exists
+		query := `UPDATE attribute_definitions SET type=?, required=?, enum_values=?, pattern=?, description=? WHERE name=?`
+		if created {
+			query = `INSERT INTO attribute_definitions(type, required, enum_values, pattern, description, name) VALUES (?, ?, ?, ?, ?, ?)`
+		}
+		_, err = tx.conn.ExecContext(ctx, tx.dialect.Rebind(query), d.Type, d.Required, string(enum), d.Pattern, d.Description, d.Name)
+		return err
+	})
+	return created, err
+}
+
+// DeleteAttributeDefinition also removes the attribute from deleted users,
+// which would otherwise get it back on restore.
+func (r *Reopository) DeleteAttributeDefinition(ctx context.Context, name string, origin audit.Origin) error {
+	return r.inTx(ctx, func(tx *Reopository) error {
+		exists, err := tx.attributeDefined(ctx, name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return attributeNotFound(name)
+		}
+
+		var holders []user.User
+		query := `SELECT ` + userColumns + ` FROM users
+			JOIN user_attributes ON user_attributes.user_id = users.id
+			WHERE user_attributes.name = ? ORDER BY users.id`
+		err = tx.eachRow(ctx, query, []interface{}{name}, func(u user.User) error {
+			holders = append(holders, u)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := tx.loadContacts(ctx, holders); err != nil {
+			return err
+		}
+
+		// The values go before the definition they refer to.
+		if _, err := tx.conn.ExecContext(ctx, tx.dialect.Rebind(`DELETE FROM user_attributes WHERE name = ?`), name); err != nil {
+			return err
+		}
+		if _, err := tx.conn.ExecContext(ctx, tx.dialect.Rebind(`DELETE FROM attribute_definitions WHERE name = ?`), name); err != nil {
+			return err
+		}
+		for i := range holders {
+			current := &holders[i]
+			query := tx.dialect.Rebind(`UPDATE users SET version = version+1 WHERE id = ?`)
+			if _, err := tx.conn.ExecContext(ctx, query, current.ID); err != nil {
+				return err
+			}
+			modified := *current
+			modified.Attributes = map[string]string{}
+			for n, value := range current.Attributes {
+				if n != name {
+					modified.Attributes[n] = value
+				}
+			}
+			modified.Version++
+			if err := tx.recordEvent(ctx, audit.Update, origin, current.ID, current, &modified); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *Reopository) attributeDefined(ctx context.Context, name string) (bool, error) {
+	var exists int
+	err := r.conn.QueryRowContext(ctx, r.dialect.Rebind(`SELECT 1 FROM attribute_definitions WHERE name = ?`), name).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func attributeNotFound(name string) error {
+	return fmt.Errorf("attribute %q: %w", name, domain.ErrNotFound)
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/user"
+)
+
+// recordEvent appends the change from before to after to the audit log
+// within r's transaction, so the event is committed or rolled back with the
+// change.
+func (r *Reopository) recordEvent(ctx context.Context, op audit.Operation, origin audit.Origin, id int64, before, after *user.User) error {
+	var b, a interface{}
+	if before != nil {
+		b = before
+	}
+	if after != nil {
+		a = after
+	}
+	changes, err := audit.Diff(b, a)
+	if err != nil {
+		return err
+	}
+	doc, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	query := r.dialect.Rebind(`INSERT INTO audit_events(user_id, operation, actor, request_id, occurred_at, changes)
+		VALUES (?, ?, ?, ?, ?, ?)`)
+	_, err = r.conn.ExecContext(ctx, query, id, string(op), origin.Actor, origin.RequestID, time.Now().UTC(), string(doc))
+	return err
+}
+
+// UserHistory returns every audit event of the user, oldest first. It
+// includes events of deleted and purged users.
+func (r *Reopository) UserHistory(ctx context.Context, id int64) ([]audit.Event, error) {
+	query := r.dialect.Rebind(`SELECT id, user_id, operation, actor, request_id, occurred_at, changes
+		FROM audit_events WHERE user_id = ? ORDER BY id`)
+	rows, err := r.conn.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows)
+}
+
+// AuditEvents returns one page of events of all users, oldest first.
+func (r *Reopository) AuditEvents(ctx context.Context, q audit.Query) (audit.Page, error) {
+	q = q.WithDefaults()
+	var page audit.Page
+
+	count := r.dialect.Rebind(`SELECT COUNT(*) FROM audit_events WHERE occurred_at >= ?`)
+	if err := r.conn.QueryRowContext(ctx, count, q.Since.UTC()).Scan(&page.Total); err != nil {
+		return page, err
+	}
+
+	query := r.dialect.Rebind(`SELECT id, user_id, operation, actor, request_id, occurred_at, changes
+		FROM audit_events WHERE occurred_at >= ? AND id > ? ORDER BY id LIMIT ?`)
+	// Fetch one extra row to learn whether there is a next page.
+	rows, err := r.conn.QueryContext(ctx, query, q.Since.UTC(), q.After, q.Limit+1)
+	if err != nil {
+		return page, err
+	}
+	page.Events, err = scanEvents(rows)
+	if err != nil {
+		return page, err
+	}
+	if len(page.Events) > q.Limit {
+		page.Events = page.Events[:q.Limit]
+		page.Next = page.Events[q.Limit-1].ID
+	}
+	return page, nil
+}
+
+func scanEvents(rows *sql.Rows) ([]audit.Event, error) {
+	defer rows.Close()
+
+	var events []audit.Event
+	for rows.Next() {
+		var e audit.Event
+		var op string
+		var changes []byte
+		err := rows.Scan(&e.ID, &e.UserID, &op, &e.Actor, &e.RequestID, &e.At, &changes)
+		if err != nil {
+			return nil, err
+		}
+		e.Operation = audit.Operation(op)
+		if err := json.Unmarshal(changes, &e.Changes); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
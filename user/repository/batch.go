@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/user"
+)
+
+// Batch runs every operation within a savepoint, so that outside atomic mode
+// a failed operation is undone on its own, even on PostgreSQL, which refuses
+// further statements in a transaction after an error. An atomic batch is
+// undone through a savepoint of its own, which also works when it joins a
+// caller's transaction.
+func (r *Reopository) Batch(ctx context.Context, ops []user.BatchOperation, atomic bool, origin audit.Origin) ([]user.BatchResult, error) {
+	var results []user.BatchResult
+	failed := -1
+	err := r.inTx(ctx, func(tx *Reopository) error {
+		results, failed = make([]user.BatchResult, len(ops)), -1
+		if _, err := tx.conn.ExecContext(ctx, `SAVEPOINT batch`); err != nil {
+			return err
+		}
+		for i, op := range ops {
+			if _, err := tx.conn.ExecContext(ctx, `SAVEPOINT batch_operation`); err != nil {
+				return err
+			}
+			results[i] = op.Apply(ctx, tx, origin)
+			if err := results[i].Err; err != nil {
+				if !domain.IsKnown(err) {
+					return err
+				}
+				if atomic {
+					failed = i
+					break
+				}
+				if _, err := tx.conn.ExecContext(ctx, `ROLLBACK TO SAVEPOINT batch_operation`); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.conn.ExecContext(ctx, `RELEASE SAVEPOINT batch_operation`); err != nil {
+				return err
+			}
+		}
+		if failed >= 0 {
+			if _, err := tx.conn.ExecContext(ctx, `ROLLBACK TO SAVEPOINT batch`); err != nil {
+				return err
+			}
+		}
+		_, err := tx.conn.ExecContext(ctx, `RELEASE SAVEPOINT batch`)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if failed >= 0 {
+		user.AbortBatch(results, failed)
+	}
+	return results, nil
+}
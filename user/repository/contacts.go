@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pmaterer/peopler/user"
+)
+
+// withContacts returns u with its emails, phones and attributes.
+func (r *Reopository) withContacts(ctx context.Context, u user.User) (user.User, error) {
+	users := []user.User{u}
+	err := r.loadContacts(ctx, users)
+	return users[0], err
+}
+
+// loadContacts fills in the emails, phones and attributes of users, with one
+// query per table for every getUsersChunkSize users, which keeps the number
+// of placeholders in each query within the databases' limits.
+func (r *Reopository) loadContacts(ctx context.Context, users []user.User) error {
+	for start := 0; start < len(users); start += getUsersChunkSize {
+		end := start + getUsersChunkSize
+		if end > len(users) {
+			end = len(users)
+		}
+		if err := r.loadContactsChunk(ctx, users[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadContactsChunk fills in the contacts of up to getUsersChunkSize users.
+func (r *Reopository) loadContactsChunk(ctx context.Context, users []user.User) error {
+	index := make(map[int64]int, len(users))
+	ids := make([]interface{}, len(users))
+	for i, u := range users {
+		index[u.ID] = i
+		ids[i] = u.ID
+	}
+	in := `(?` + strings.Repeat(`, ?`, len(ids)-1) + `)`
+
+	query := `SELECT user_id, address, type, is_primary FROM user_emails WHERE user_id IN ` + in + ` ORDER BY user_id, position`
+	rows, err := r.conn.QueryContext(ctx, r.dialect.Rebind(query), ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var e user.Email
+		if err := rows.Scan(&id, &e.Address, &e.Type, &e.Primary); err != nil {
+			return err
+		}
+		u := &users[index[id]]
+		u.Emails = append(u.Emails, e)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	query = `SELECT user_id, number, type, is_primary FROM user_phones WHERE user_id IN ` + in + ` ORDER BY user_id, position`
+	rows, err = r.conn.QueryContext(ctx, r.dialect.Rebind(query), ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var p user.Phone
+		if err := rows.Scan(&id, &p.Number, &p.Type, &p.Primary); err != nil {
+			return err
+		}
+		u := &users[index[id]]
+		u.Phones = append(u.Phones, p)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	query = `SELECT user_id, name, value FROM user_attributes WHERE user_id IN ` + in
+	rows, err = r.conn.QueryContext(ctx, r.dialect.Rebind(query), ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var name, value string
+		if err := rows.Scan(&id, &name, &value); err != nil {
+			return err
+		}
+		u := &users[index[id]]
+		if u.Attributes == nil {
+			u.Attributes = map[string]string{}
+		}
+		u.Attributes[name] = value
+	}
+	return rows.Err()
+}
+
+// saveContacts replaces the stored emails, phones and attributes of u with
+// its own, within r's transaction.
+func (r *Reopository) saveContacts(ctx context.Context, u user.User) error {
+	if err := r.deleteContacts(ctx, u.ID); err != nil {
+		return err
+	}
+	for i, e := range u.Emails {
+		query := r.dialect.Rebind(`INSERT INTO user_emails(user_id, position, address, type, is_primary) VALUES (?, ?, ?, ?, ?)`)
+		if _, err := r.conn.ExecContext(ctx, query, u.ID, i, e.Address, e.Type, e.Primary); err != nil {
+			return err
+		}
+	}
+	for i, p := range u.Phones {
+		query := r.dialect.Rebind(`INSERT INTO user_phones(user_id, position, number, type, is_primary) VALUES (?, ?, ?, ?, ?)`)
+		if _, err := r.conn.ExecContext(ctx, query, u.ID, i, p.Number, p.Type, p.Primary); err != nil {
+			return err
+		}
+	}
+	for name, value := range u.Attributes {
+		query := r.dialect.Rebind(`INSERT INTO user_attributes(user_id, name, value) VALUES (?, ?, ?)`)
+		if _, err := r.conn.ExecContext(ctx, query, u.ID, name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Reopository) deleteContacts(ctx context.Context, id int64) error {
+	for _, table := range []string{"user_emails", "user_phones", "user_attributes"} {
+		query := r.dialect.Rebind(`DELETE FROM ` + table + ` WHERE user_id = ?`)
+		if _, err := r.conn.ExecContext(ctx, query, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
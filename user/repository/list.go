@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"strings"
+
+	"github.com/pmaterer/peopler/internal/dialect"
+	"github.com/pmaterer/peopler/user"
+)
+
+var sortColumns = map[string]string{
+	user.SortByID:        "id",
+	user.SortByFirstName: "first_name",
+	user.SortByLastName:  "last_name",
+}
+
+// likeEscaper escapes the LIKE wildcards in user supplied prefixes.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (r *Reopository) listFilter(opts user.ListOptions) ([]string, []interface{}) {
+	// LIKE already ignores ASCII case in SQLite; PostgreSQL needs ILIKE.
+	like := "LIKE"
+	if r.dialect == dialect.Postgres {
+		like = "ILIKE"
+	}
+
+	var conditions []string
+	var args []interface{}
+	if !opts.IncludeDeleted {
+		conditions = append(conditions, `deleted_at IS NULL`)
+	}
+	if opts.FirstNamePrefix != "" {
+		conditions = append(conditions, `first_name `+like+` ? ESCAPE '\'`)
+		args = append(args, likeEscaper.Replace(opts.FirstNamePrefix)+"%")
+	}
+	if opts.LastNamePrefix != "" {
+		conditions = append(conditions, `last_name `+like+` ? ESCAPE '\'`)
+		args = append(args, likeEscaper.Replace(opts.LastNamePrefix)+"%")
+	}
+	for name, value := range opts.Attributes {
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM user_attributes
+			WHERE user_attributes.user_id = users.id AND user_attributes.name = ? AND user_attributes.value = ?)`)
+		args = append(args, name, value)
+	}
+	if len(opts.Tags) > 0 {
+		tagged := `EXISTS (SELECT 1 FROM user_tags JOIN tags ON tags.id = user_tags.tag_id
+			WHERE user_tags.user_id = users.id AND tags.name `
+		if opts.AnyTag {
+			conditions = append(conditions, tagged+`IN (?`+strings.Repeat(`, ?`, len(opts.Tags)-1)+`))`)
+			for _, tag := range opts.Tags {
+				args = append(args, tag)
+			}
+		} else {
+			for _, tag := range opts.Tags {
+				conditions = append(conditions, tagged+`= ?)`)
+				args = append(args, tag)
+			}
+		}
+	}
+	return conditions, args
+}
+
+// keysetCondition selects the rows sorting strictly after the cursor. For a
+// sort of a, -b it expands to (a > ?) OR (a = ? AND b < ?).
+func keysetCondition(sort []user.SortField, after *user.Cursor) (string, []interface{}) {
+	var alternatives []string
+	var args []interface{}
+	for i, f := range sort {
+		var terms []string
+		for _, prev := range sort[:i] {
+			terms = append(terms, sortColumns[prev.Field]+` = ?`)
+			args = append(args, cursorValue(prev.Field, after))
+		}
+		op := ` > ?`
+		if f.Descending {
+			op = ` < ?`
+		}
+		terms = append(terms, sortColumns[f.Field]+op)
+		args = append(args, cursorValue(f.Field, after))
+		alternatives = append(alternatives, `(`+strings.Join(terms, ` AND `)+`)`)
+	}
+	return `(` + strings.Join(alternatives, ` OR `) + `)`, args
+}
+
+func cursorValue(field string, c *user.Cursor) interface{} {
+	switch field {
+	case user.SortByFirstName:
+		return c.FirstName
+	case user.SortByLastName:
+		return c.LastName
+	default:
+		return c.ID
+	}
+}
+
+func orderBy(sort []user.SortField) string {
+	terms := make([]string, len(sort))
+	for i, f := range sort {
+		terms[i] = sortColumns[f.Field]
+		if f.Descending {
+			terms[i] += ` DESC`
+		}
+	}
+	return ` ORDER BY ` + strings.Join(terms, `, `)
+}
+
+func where(conditions []string) string {
+	if len(conditions) == 0 {
+		return ``
+	}
+	return ` WHERE ` + strings.Join(conditions, ` AND `)
+}
@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/user"
+)
+
+func (r *Reopository) AttributeDefinitions(_ context.Context) ([]user.AttributeDefinition, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	defs := make([]user.AttributeDefinition, 0, len(r.definitions))
+	for _, d := range r.definitions {
+		d.Enum = append([]string(nil), d.Enum...)
+		defs = append(defs, d)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs, nil
+}
+
+func (r *Reopository) PutAttributeDefinition(_ context.Context, d user.AttributeDefinition) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, exists := r.definitions[d.Name]
+	d.Enum = append([]string(nil), d.Enum...)
+	r.definitions[d.Name] = d
+	return !exists, nil
+}
+
+func (r *Reopository) DeleteAttributeDefinition(_ context.Context, name string, origin audit.Origin) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.definitions[name]; !ok {
+		return fmt.Errorf("attribute %q: %w", name, domain.ErrNotFound)
+	}
+	var ids []int64
+	for id, u := range r.users {
+		if _, ok := u.Attributes[name]; ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		current := r.users[id]
+		modified := detach(current)
+		delete(modified.Attributes, name)
+		modified.Version++
+		if err := r.recordEvent(audit.Update, origin, id, &current, &modified); err != nil {
+			return err
+		}
+		r.users[id] = modified
+	}
+	delete(r.definitions, name)
+	return nil
+}
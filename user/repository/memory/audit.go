@@ -0,0 +1,71 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/user"
+)
+
+// recordEvent appends an event to the log. The caller must hold the write
+// lock.
+func (r *Reopository) recordEvent(op audit.Operation, origin audit.Origin, id int64, before, after *user.User) error {
+	var b, a interface{}
+	if before != nil {
+		b = before
+	}
+	if after != nil {
+		a = after
+	}
+	changes, err := audit.Diff(b, a)
+	if err != nil {
+		return err
+	}
+	r.events = append(r.events, audit.Event{
+		ID:        int64(len(r.events)) + 1,
+		UserID:    id,
+		Operation: op,
+		Actor:     origin.Actor,
+		RequestID: origin.RequestID,
+		At:        time.Now().UTC(),
+		Changes:   changes,
+	})
+	return nil
+}
+
+func (r *Reopository) UserHistory(_ context.Context, id int64) ([]audit.Event, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var events []audit.Event
+	for _, e := range r.events {
+		if e.UserID == id {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (r *Reopository) AuditEvents(_ context.Context, q audit.Query) (audit.Page, error) {
+	q = q.WithDefaults()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var page audit.Page
+	for _, e := range r.events {
+		if e.At.Before(q.Since) {
+			continue
+		}
+		page.Total++
+		if e.ID <= q.After || page.Next != 0 {
+			continue
+		}
+		if len(page.Events) == q.Limit {
+			page.Next = page.Events[len(page.Events)-1].ID
+			continue
+		}
+		page.Events = append(page.Events, e)
+	}
+	return page, nil
+}
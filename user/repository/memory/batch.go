@@ -0,0 +1,45 @@
+package memory
+
+import (
+	"context"
+	"errors"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/user"
+)
+
+// errBatchAborted rolls back an atomic batch after one of its operations
+// failed.
+var errBatchAborted = errors.New("batch aborted")
+
+// Batch runs an atomic batch in a WithTx copy of the store. Each operation
+// either changes the store completely or not at all, so outside atomic mode
+// they run directly, and a failed one needs no undoing.
+func (r *Reopository) Batch(ctx context.Context, ops []user.BatchOperation, atomic bool, origin audit.Origin) ([]user.BatchResult, error) {
+	results := make([]user.BatchResult, len(ops))
+	if !atomic {
+		for i, op := range ops {
+			results[i] = op.Apply(ctx, r, origin)
+		}
+		return results, nil
+	}
+	failed := -1
+	err := r.WithTx(ctx, func(tx user.Repository) error {
+		for i, op := range ops {
+			results[i] = op.Apply(ctx, tx, origin)
+			if results[i].Err != nil {
+				failed = i
+				return errBatchAborted
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errBatchAborted) {
+		user.AbortBatch(results, failed)
+		return results, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
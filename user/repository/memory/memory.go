@@ -0,0 +1,378 @@
+// Package memory is a concurrency-safe, in-memory user repository for tests
+// and demos. Nothing is persisted between runs. Contexts are accepted to
+// satisfy the repository interfaces but ignored, as no operation blocks.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/user"
+)
+
+// Reopository stores users by value, so callers never share state with the
+// store or with each other. The contact slices are copied on the way in and
+// out, see detach.
+type Reopository struct {
+	mu     sync.RWMutex
+	users  map[int64]user.User
+	lastID int64
+	events []audit.Event
+	// definitions holds the attribute definitions by name.
+	definitions map[string]user.AttributeDefinition
+	// tags holds the set of tags of each tagged user.
+	tags map[int64]map[string]bool
+}
+
+func NewRepository() *Reopository {
+	return &Reopository{
+		users:       map[int64]user.User{},
+		definitions: map[string]user.AttributeDefinition{},
+		tags:        map[int64]map[string]bool{},
+	}
+}
+
+func (r *Reopository) CreateUser(_ context.Context, u user.User, origin audit.Origin) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Any ID the caller set is ignored, so it must not exempt a user from
+	// the uniqueness checks.
+	u.ID = 0
+	if err := r.checkUnique(u); err != nil {
+		return 0, err
+	}
+	u = detach(u)
+	r.lastID++
+	u.ID = r.lastID
+	u.Version = 1
+	u.DeletedAt = nil
+	if err := r.recordEvent(audit.Create, origin, u.ID, nil, &u); err != nil {
+		return u.ID, err
+	}
+	r.users[u.ID] = u
+	return u.ID, nil
+}
+
+// GetAllUsers returns one page of copies of the matching users, starting
+// strictly after opts.After in the requested order.
+func (r *Reopository) GetAllUsers(_ context.Context, opts user.ListOptions) (user.Page, error) {
+	opts = opts.WithDefaults()
+	matches := r.matching(opts)
+
+	page := user.Page{Total: int64(len(matches))}
+	for _, u := range matches {
+		if opts.After != nil && compare(opts.Sort, u, cursorUser(opts.After)) <= 0 {
+			continue
+		}
+		if len(page.Users) == opts.Limit {
+			page.Next = user.CursorFor(page.Users[len(page.Users)-1])
+			break
+		}
+		page.Users = append(page.Users, u)
+	}
+	return page, nil
+}
+
+// StreamUsers calls fn with copies of the matching users after opts.After.
+// The store has to be sorted as a whole anyway, so the matches are copied
+// first and fn runs without holding the lock.
+func (r *Reopository) StreamUsers(_ context.Context, opts user.ListOptions, fn func(user.User) error) error {
+	opts = opts.WithDefaults()
+	for _, u := range r.matching(opts) {
+		if opts.After != nil && compare(opts.Sort, u, cursorUser(opts.After)) <= 0 {
+			continue
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matching returns copies of the users matching the filters of opts, sorted.
+func (r *Reopository) matching(opts user.ListOptions) []user.User {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matches []user.User
+	for _, u := range r.users {
+		if u.DeletedAt != nil && !opts.IncludeDeleted {
+			continue
+		}
+		if hasPrefixFold(u.FirstName, opts.FirstNamePrefix) && hasPrefixFold(u.LastName, opts.LastNamePrefix) &&
+			hasAttributes(u, opts.Attributes) && r.hasTags(u.ID, opts) {
+			matches = append(matches, detach(u))
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return compare(opts.Sort, matches[i], matches[j]) < 0
+	})
+	return matches
+}
+
+func (r *Reopository) GetUser(_ context.Context, id int64) (user.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	u, ok := r.live(id)
+	if !ok {
+		return user.User{}, notFound(id)
+	}
+	return detach(u), nil
+}
+
+func (r *Reopository) GetUsers(_ context.Context, ids []int64) ([]user.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := []user.User{}
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if u, ok := r.live(id); ok && !seen[id] {
+			seen[id] = true
+			users = append(users, detach(u))
+		}
+	}
+	return users, nil
+}
+
+// GetUserByExternalID returns the user, unless deleted, that the external
+// system knows by externalID.
+func (r *Reopository) GetUserByExternalID(_ context.Context, externalID string) (user.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, u := range r.users {
+		if u.ExternalID == externalID && u.DeletedAt == nil {
+			return detach(u), nil
+		}
+	}
+	return user.User{}, fmt.Errorf("user with external ID %q: %w", externalID, domain.ErrNotFound)
+}
+
+// UpdateUser replaces the user and increments its version. A non-zero
+// u.Version must match the stored one.
+func (r *Reopository) UpdateUser(ctx context.Context, u user.User, origin audit.Origin) (int64, error) {
+	_, err := r.ModifyUser(ctx, u.ID, origin, func(current user.User) (user.User, error) {
+		if u.Version != 0 && u.Version != current.Version {
+			return current, stale(u.ID, u.Version)
+		}
+		return u, nil
+	})
+	return u.ID, err
+}
+
+// ModifyUser holds the write lock while modify runs, so modifications of the
+// store are serialised.
+func (r *Reopository) ModifyUser(_ context.Context, id int64, origin audit.Origin, modify func(user.User) (user.User, error)) (user.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.live(id)
+	if !ok {
+		return user.User{}, notFound(id)
+	}
+	modified, err := modify(detach(current))
+	if err != nil {
+		return current, err
+	}
+	modified = detach(modified)
+	modified.ID = id
+	modified.Version = current.Version + 1
+	modified.DeletedAt = nil
+	if err := r.checkUnique(modified); err != nil {
+		return current, err
+	}
+	if err := r.recordEvent(audit.Update, origin, id, &current, &modified); err != nil {
+		return current, err
+	}
+	r.users[id] = modified
+	return modified, nil
+}
+
+// DeleteUser marks the user as deleted until it is restored or purged.
+func (r *Reopository) DeleteUser(_ context.Context, id, version int64, origin audit.Origin) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.live(id)
+	if !ok {
+		return id, notFound(id)
+	}
+	if version != 0 && version != current.Version {
+		return id, stale(id, version)
+	}
+	deleted := current
+	deletedAt := time.Now().UTC()
+	deleted.DeletedAt = &deletedAt
+	deleted.Version++
+	if err := r.recordEvent(audit.Delete, origin, id, &current, &deleted); err != nil {
+		return id, err
+	}
+	r.users[id] = deleted
+	return id, nil
+}
+
+func (r *Reopository) RestoreUser(_ context.Context, id int64, origin audit.Origin) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.users[id]
+	if !ok {
+		return id, notFound(id)
+	}
+	if current.DeletedAt == nil {
+		return id, fmt.Errorf("user #%d is not deleted: %w", id, domain.ErrConflict)
+	}
+	restored := current
+	restored.DeletedAt = nil
+	restored.Version++
+	if err := r.recordEvent(audit.Restore, origin, id, &current, &restored); err != nil {
+		return id, err
+	}
+	r.users[id] = restored
+	return id, nil
+}
+
+func (r *Reopository) PurgeUsers(_ context.Context, deletedBefore time.Time) ([]int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := []int64{}
+	for id, u := range r.users {
+		if u.DeletedAt != nil && u.DeletedAt.Before(deletedBefore) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	purged := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		purged[id] = true
+	}
+	for _, id := range ids {
+		if err := r.clearManager(id, purged); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range ids {
+		u := r.users[id]
+		if err := r.recordEvent(audit.Purge, audit.System, id, &u, nil); err != nil {
+			return nil, err
+		}
+		delete(r.users, id)
+		delete(r.tags, id)
+	}
+	return ids, nil
+}
+
+// live returns the user unless it is missing or deleted. The caller must
+// hold the lock.
+func (r *Reopository) live(id int64) (user.User, bool) {
+	u, ok := r.users[id]
+	if !ok || u.DeletedAt != nil {
+		return user.User{}, false
+	}
+	return u, true
+}
+
+// checkUnique rejects u if another user, deleted or not, has its external
+// ID or, ignoring case, its primary email. The caller must hold the lock.
+func (r *Reopository) checkUnique(u user.User) error {
+	email, i := u.PrimaryEmail()
+	for id, other := range r.users {
+		if id == u.ID {
+			continue
+		}
+		if u.ExternalID != "" && other.ExternalID == u.ExternalID {
+			return &domain.ConflictError{Field: "externalId", Value: u.ExternalID, ID: id}
+		}
+		if otherEmail, j := other.PrimaryEmail(); i >= 0 && j >= 0 && strings.EqualFold(otherEmail.Address, email.Address) {
+			return &domain.ConflictError{Field: fmt.Sprintf("emails[%d].address", i), Value: email.Address, ID: id}
+		}
+	}
+	return nil
+}
+
+// detach copies the emails, phones, manager ID and attributes of u, which
+// would otherwise be shared between the store and its callers.
+func detach(u user.User) user.User {
+	u.Emails = append([]user.Email(nil), u.Emails...)
+	u.Phones = append([]user.Phone(nil), u.Phones...)
+	if u.ManagerID != nil {
+		managerID := *u.ManagerID
+		u.ManagerID = &managerID
+	}
+	attributes := u.Attributes
+	u.Attributes = nil
+	for name, value := range attributes {
+		if u.Attributes == nil {
+			u.Attributes = make(map[string]string, len(attributes))
+		}
+		u.Attributes[name] = value
+	}
+	return u
+}
+
+func notFound(id int64) error {
+	return fmt.Errorf("user #%d: %w", id, domain.ErrNotFound)
+}
+
+func stale(id, version int64) error {
+	return fmt.Errorf("user #%d is no longer at version %d: %w", id, version, domain.ErrPreconditionFailed)
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func hasAttributes(u user.User, attributes map[string]string) bool {
+	for name, value := range attributes {
+		if stored, ok := u.Attributes[name]; !ok || stored != value {
+			return false
+		}
+	}
+	return true
+}
+
+func cursorUser(c *user.Cursor) user.User {
+	return user.User{ID: c.ID, FirstName: c.FirstName, LastName: c.LastName}
+}
+
+// compare orders a and b by the sort fields, like strings.Compare.
+func compare(fields []user.SortField, a, b user.User) int {
+	for _, f := range fields {
+		var c int
+		switch f.Field {
+		case user.SortByFirstName:
+			c = strings.Compare(a.FirstName, b.FirstName)
+		case user.SortByLastName:
+			c = strings.Compare(a.LastName, b.LastName)
+		default:
+			c = compareInt64(a.ID, b.ID)
+		}
+		if f.Descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
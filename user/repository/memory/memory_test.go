@@ -0,0 +1,50 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/user"
+	"github.com/pmaterer/peopler/user/repository/repositorytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repositorytest.Repository {
+		return NewRepository()
+	})
+}
+
+func TestConcurrentCreate(t *testing.T) {
+	r := NewRepository()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.CreateUser(context.Background(), user.User{FirstName: "Shane", LastName: "Glass"}, audit.System)
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+
+	page, err := r.GetAllUsers(context.Background(), user.ListOptions{Limit: 100})
+	assert.Nil(t, err)
+	assert.Len(t, page.Users, 50)
+	for i, u := range page.Users {
+		assert.Equal(t, int64(i+1), u.ID)
+	}
+}
+
+func TestUpdateMissingUserDoesNotCreate(t *testing.T) {
+	r := NewRepository()
+
+	_, err := r.UpdateUser(context.Background(), user.User{ID: 7, FirstName: "Shane", LastName: "Glass"}, audit.System)
+	assert.Error(t, err)
+
+	_, err = r.GetUser(context.Background(), 7)
+	assert.Error(t, err)
+}
@@ -0,0 +1,123 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/user"
+)
+
+// Reports walks down from managerID a level at a time, so the users come out
+// shallowest first and ordered by name within a level.
+func (r *Reopository) Reports(_ context.Context, managerID int64, depth, limit int) ([]user.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	reports := map[int64][]user.User{}
+	var roots []user.User
+	for _, u := range r.users {
+		if u.DeletedAt != nil {
+			continue
+		}
+		if u.ManagerID != nil {
+			if _, ok := r.live(*u.ManagerID); ok {
+				reports[*u.ManagerID] = append(reports[*u.ManagerID], u)
+				continue
+			}
+		}
+		roots = append(roots, u)
+	}
+	if managerID != 0 {
+		roots = reports[managerID]
+	}
+
+	var found []user.User
+	level := roots
+	for i := 0; i < depth && len(level) > 0; i++ {
+		sort.Slice(level, func(i, j int) bool { return byName(level[i], level[j]) })
+		var next []user.User
+		for _, u := range level {
+			if len(found) == limit {
+				return found, nil
+			}
+			found = append(found, detach(u))
+			next = append(next, reports[u.ID]...)
+		}
+		level = next
+	}
+	return found, nil
+}
+
+// ManagementChain stops at a manager seen before as well as at a deleted
+// one, in case the store holds a cycle.
+func (r *Reopository) ManagementChain(_ context.Context, id int64) ([]user.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	u, ok := r.live(id)
+	if !ok {
+		return nil, notFound(id)
+	}
+	var chain []user.User
+	seen := map[int64]bool{id: true}
+	for u.ManagerID != nil && !seen[*u.ManagerID] {
+		seen[*u.ManagerID] = true
+		if u, ok = r.live(*u.ManagerID); !ok {
+			break
+		}
+		chain = append(chain, detach(u))
+	}
+	return chain, nil
+}
+
+// ManagerIDs follows the manager IDs through deleted users as well as live
+// ones, stopping at a manager seen before.
+func (r *Reopository) ManagerIDs(_ context.Context, id int64) ([]int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var ids []int64
+	seen := map[int64]bool{id: true}
+	u, ok := r.users[id]
+	for ok && u.ManagerID != nil && !seen[*u.ManagerID] {
+		seen[*u.ManagerID] = true
+		ids = append(ids, *u.ManagerID)
+		u, ok = r.users[*u.ManagerID]
+	}
+	return ids, nil
+}
+
+// clearManager removes the user about to be purged as the manager of their
+// reports, other than those purged along with them. The caller must hold the
+// lock.
+func (r *Reopository) clearManager(id int64, purged map[int64]bool) error {
+	var ids []int64
+	for reportID, u := range r.users {
+		if u.ManagerID != nil && *u.ManagerID == id && !purged[reportID] {
+			ids = append(ids, reportID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, reportID := range ids {
+		current := r.users[reportID]
+		modified := detach(current)
+		modified.ManagerID = nil
+		modified.Version++
+		if err := r.recordEvent(audit.Update, audit.System, reportID, &current, &modified); err != nil {
+			return err
+		}
+		r.users[reportID] = modified
+	}
+	return nil
+}
+
+func byName(a, b user.User) bool {
+	if a.LastName != b.LastName {
+		return a.LastName < b.LastName
+	}
+	if a.FirstName != b.FirstName {
+		return a.FirstName < b.FirstName
+	}
+	return a.ID < b.ID
+}
@@ -0,0 +1,65 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pmaterer/peopler/user"
+)
+
+// SearchUsers returns up to limit users whose names contain words starting
+// with every term. Whole word matches rank above prefix matches.
+func (r *Reopository) SearchUsers(_ context.Context, terms []string, limit int) (user.Page, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	type match struct {
+		user  user.User
+		score int
+	}
+	var matches []match
+	for _, u := range r.users {
+		if u.DeletedAt != nil {
+			continue
+		}
+		if score, ok := searchScore(terms, user.SearchTerms(u.FirstName+" "+u.LastName)); ok {
+			matches = append(matches, match{user: u, score: score})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].user.ID < matches[j].user.ID
+	})
+
+	page := user.Page{Total: int64(len(matches))}
+	for i := 0; i < len(matches) && i < limit; i++ {
+		page.Users = append(page.Users, detach(matches[i].user))
+	}
+	return page, nil
+}
+
+// searchScore reports whether every term prefixes one of the words, scoring
+// two for a whole word and one for a prefix.
+func searchScore(terms, words []string) (int, bool) {
+	score := 0
+	for _, term := range terms {
+		best := 0
+		for _, word := range words {
+			if word == term {
+				best = 2
+				break
+			}
+			if strings.HasPrefix(word, term) {
+				best = 1
+			}
+		}
+		if best == 0 {
+			return 0, false
+		}
+		score += best
+	}
+	return score, true
+}
@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/user"
+)
+
+func (r *Reopository) TagUser(_ context.Context, id int64, tag string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.live(id); !ok {
+		return notFound(id)
+	}
+	if r.tags[id] == nil {
+		r.tags[id] = map[string]bool{}
+	}
+	r.tags[id][tag] = true
+	return nil
+}
+
+func (r *Reopository) UntagUser(_ context.Context, id int64, tag string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.live(id); !ok {
+		return notFound(id)
+	}
+	if !r.tags[id][tag] {
+		return fmt.Errorf("user #%d is not tagged %q: %w", id, tag, domain.ErrNotFound)
+	}
+	delete(r.tags[id], tag)
+	if len(r.tags[id]) == 0 {
+		delete(r.tags, id)
+	}
+	return nil
+}
+
+func (r *Reopository) UserTags(_ context.Context, id int64) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.live(id); !ok {
+		return nil, notFound(id)
+	}
+	tags := []string{}
+	for tag := range r.tags[id] {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+func (r *Reopository) Tags(_ context.Context) ([]user.TagCount, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counts := map[string]int64{}
+	for id, tags := range r.tags {
+		if _, ok := r.live(id); !ok {
+			continue
+		}
+		for tag := range tags {
+			counts[tag]++
+		}
+	}
+	tags := make([]user.TagCount, 0, len(counts))
+	for name, count := range counts {
+		tags = append(tags, user.TagCount{Name: name, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags, nil
+}
+
+// hasTags reports whether the user has every tag opts filters by, or any of
+// them if opts.AnyTag is set. The caller must hold the lock.
+func (r *Reopository) hasTags(id int64, opts user.ListOptions) bool {
+	if len(opts.Tags) == 0 {
+		return true
+	}
+	for _, tag := range opts.Tags {
+		if r.tags[id][tag] == opts.AnyTag {
+			return opts.AnyTag
+		}
+	}
+	return !opts.AnyTag
+}
@@ -0,0 +1,43 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/user"
+)
+
+// WithTx runs fn against a copy of the store while holding the write lock,
+// and keeps the copy only if fn succeeds. Units of work are therefore
+// serialised and all-or-nothing, at the cost of copying the store. fn must
+// only use the repository it is given; calling r would deadlock.
+func (r *Reopository) WithTx(_ context.Context, fn func(user.Repository) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx := &Reopository{
+		users:  make(map[int64]user.User, len(r.users)),
+		lastID: r.lastID,
+		events: append([]audit.Event(nil), r.events...),
+
+		definitions: make(map[string]user.AttributeDefinition, len(r.definitions)),
+		tags:        make(map[int64]map[string]bool, len(r.tags)),
+	}
+	for id, u := range r.users {
+		tx.users[id] = u
+	}
+	for name, d := range r.definitions {
+		tx.definitions[name] = d
+	}
+	for id, tags := range r.tags {
+		tx.tags[id] = make(map[string]bool, len(tags))
+		for tag := range tags {
+			tx.tags[id][tag] = true
+		}
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	r.users, r.lastID, r.events, r.definitions, r.tags = tx.users, tx.lastID, tx.events, tx.definitions, tx.tags
+	return nil
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/user"
+)
+
+// Reports walks down the management tree with a recursive query, counting
+// the levels so it stops at depth.
+func (r *Reopository) Reports(ctx context.Context, managerID int64, depth, limit int) ([]user.User, error) {
+	roots := `manager_id = ?`
+	args := []interface{}{managerID, depth, limit}
+	if managerID == 0 {
+		// The top of the organisation also takes in the reports of deleted
+		// managers, who would otherwise drop out of the chart.
+		roots = `(manager_id IS NULL OR NOT EXISTS (
+			SELECT 1 FROM users managers WHERE managers.id = users.manager_id AND managers.deleted_at IS NULL))`
+		args = args[1:]
+	}
+	query := `WITH RECURSIVE reports(id, depth) AS (
+			SELECT id, 1 FROM users WHERE ` + roots + ` AND deleted_at IS NULL
+			UNION ALL
+			SELECT users.id, reports.depth + 1 FROM users
+			JOIN reports ON users.manager_id = reports.id
+			WHERE users.deleted_at IS NULL AND reports.depth < ?
+		)
+		SELECT ` + userColumns + ` FROM reports
+		JOIN users ON users.id = reports.id
+		ORDER BY reports.depth, users.last_name, users.first_name, users.id
+		LIMIT ?`
+
+	var reports []user.User
+	err := r.eachRow(ctx, query, args, func(u user.User) error {
+		reports = append(reports, u)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reports, r.loadContacts(ctx, reports)
+}
+
+// ManagementChain walks up the management tree with a recursive query. The
+// rows of a recursive query come back in no particular order, so the chain
+// is put in order by following the manager IDs from the user.
+func (r *Reopository) ManagementChain(ctx context.Context, id int64) ([]user.User, error) {
+	// UNION rather than UNION ALL stops the walk should the table hold a
+	// cycle.
+	query := `WITH RECURSIVE chain(id, manager_id) AS (
+			SELECT id, manager_id FROM users WHERE id = ? AND deleted_at IS NULL
+			UNION
+			SELECT users.id, users.manager_id FROM users
+			JOIN chain ON users.id = chain.manager_id
+			WHERE users.deleted_at IS NULL
+		)
+		SELECT ` + userColumns + ` FROM chain
+		JOIN users ON users.id = chain.id`
+
+	found := map[int64]user.User{}
+	err := r.eachRow(ctx, query, []interface{}{id}, func(u user.User) error {
+		found[u.ID] = u
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	u, ok := found[id]
+	if !ok {
+		return nil, notFound(id)
+	}
+	var chain []user.User
+	seen := map[int64]bool{id: true}
+	for u.ManagerID != nil && !seen[*u.ManagerID] {
+		seen[*u.ManagerID] = true
+		if u, ok = found[*u.ManagerID]; !ok {
+			break
+		}
+		chain = append(chain, u)
+	}
+	return chain, r.loadContacts(ctx, chain)
+}
+
+// ManagerIDs walks up the management tree with a recursive query that,
+// unlike ManagementChain's, takes in deleted users.
+func (r *Reopository) ManagerIDs(ctx context.Context, id int64) ([]int64, error) {
+	// UNION rather than UNION ALL stops the walk should the table hold a
+	// cycle.
+	query := `WITH RECURSIVE chain(id) AS (
+			SELECT manager_id FROM users WHERE id = ? AND manager_id IS NOT NULL
+			UNION
+			SELECT users.manager_id FROM users
+			JOIN chain ON users.id = chain.id
+			WHERE users.manager_id IS NOT NULL
+		)
+		SELECT id FROM chain`
+	rows, err := r.conn.QueryContext(ctx, r.dialect.Rebind(query), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var managerID int64
+		if err := rows.Scan(&managerID); err != nil {
+			return nil, err
+		}
+		ids = append(ids, managerID)
+	}
+	return ids, rows.Err()
+}
+
+// clearManager removes the user about to be purged as the manager of their
+// reports, other than those purged along with them, recording each change.
+func (r *Reopository) clearManager(ctx context.Context, id int64, purged map[int64]bool) error {
+	var reports []user.User
+	err := r.eachRow(ctx, `SELECT `+userColumns+` FROM users WHERE manager_id = ? ORDER BY id`, []interface{}{id}, func(u user.User) error {
+		reports = append(reports, u)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := r.loadContacts(ctx, reports); err != nil {
+		return err
+	}
+	for i := range reports {
+		current := &reports[i]
+		if purged[current.ID] {
+			continue
+		}
+		query := r.dialect.Rebind(`UPDATE users SET manager_id = NULL, version = version+1 WHERE id = ?`)
+		if _, err := r.conn.ExecContext(ctx, query, current.ID); err != nil {
+			return err
+		}
+		modified := *current
+		modified.ManagerID = nil
+		modified.Version++
+		if err := r.recordEvent(ctx, audit.Update, audit.System, current.ID, current, &modified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
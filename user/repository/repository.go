@@ -1,102 +1,549 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/internal/dialect"
+	"github.com/pmaterer/peopler/internal/postgres"
+	"github.com/pmaterer/peopler/internal/sqlite"
 	"github.com/pmaterer/peopler/user"
 )
 
 type Reopository struct {
 	db *sql.DB
+	// conn runs the queries: db, or tx within WithTx.
+	conn    querier
+	tx      *sql.Tx
+	dialect dialect.Dialect
+	// retryable reports whether a failed transaction is worth retrying.
+	retryable func(error) bool
+	// uniqueViolation reports whether a statement failed on a unique index.
+	uniqueViolation func(error) bool
 }
 
+// NewRepository returns a repository backed by a SQLite database.
 func NewRepository(db *sql.DB) *Reopository {
 	return &Reopository{
-		db: db,
+		db:              db,
+		conn:            db,
+		dialect:         dialect.SQLite,
+		retryable:       sqlite.IsBusy,
+		uniqueViolation: sqlite.IsUniqueViolation,
 	}
 }
 
-func (r *Reopository) CreateUser(u user.User) (int64, error) {
+// NewPostgresRepository returns a repository backed by a PostgreSQL database.
+func NewPostgresRepository(db *sql.DB) *Reopository {
+	return &Reopository{
+		db:              db,
+		conn:            db,
+		dialect:         dialect.Postgres,
+		uniqueViolation: postgres.IsUniqueViolation,
+	}
+}
+
+// CreateUser inserts the user and records its creation in the audit log, in
+// one transaction.
+func (r *Reopository) CreateUser(ctx context.Context, u user.User, origin audit.Origin) (int64, error) {
 	var id int64
-	query := `INSERT INTO users(first_name, last_name) VALUES (?, ?)`
-	statement, err := r.db.Prepare(query)
+	// Any ID the caller set is ignored, so it must not exempt a user from
+	// the uniqueness checks.
+	u.ID = 0
+	err := r.inTx(ctx, func(tx *Reopository) error {
+		if err := tx.checkConflicts(ctx, u); err != nil {
+			return err
+		}
+		query := `INSERT INTO users(first_name, last_name, external_id, title, department, employee_number, start_date, manager_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+		args := []interface{}{u.FirstName, u.LastName, nullString(u.ExternalID), u.Title, u.Department, u.EmployeeNumber,
+			nullString(u.StartDate), u.ManagerID}
+		if tx.dialect == dialect.Postgres {
+			err := tx.conn.QueryRowContext(ctx, tx.dialect.Rebind(query+` RETURNING id`), args...).Scan(&id)
+			if err != nil {
+				return tx.checkUnique(err, u)
+			}
+		} else {
+			result, err := tx.conn.ExecContext(ctx, query, args...)
+			if err != nil {
+				return tx.checkUnique(err, u)
+			}
+			id, err = result.LastInsertId()
+			if err != nil {
+				return err
+			}
+		}
+
+		u.ID, u.Version, u.DeletedAt = id, 1, nil
+		if err := tx.saveContacts(ctx, u); err != nil {
+			return tx.checkUnique(err, u)
+		}
+		return tx.recordEvent(ctx, audit.Create, origin, id, nil, &u)
+	})
+	return id, err
+}
+
+// GetAllUsers returns one page of users using keyset pagination: the page
+// starts strictly after opts.After in the requested order.
+func (r *Reopository) GetAllUsers(ctx context.Context, opts user.ListOptions) (user.Page, error) {
+	opts = opts.WithDefaults()
+	var page user.Page
+
+	filter, filterArgs := r.listFilter(opts)
+	err := r.conn.QueryRowContext(ctx, r.dialect.Rebind(`SELECT COUNT(*) FROM users`+where(filter)), filterArgs...).Scan(&page.Total)
 	if err != nil {
-		return id, err
+		return page, err
 	}
-	row, err := statement.Exec(u.FirstName, u.LastName)
+
+	// Fetch one extra row to learn whether there is a next page.
+	query, args := r.listQuery(opts)
+	args = append(args, opts.Limit+1)
+	err = r.eachRow(ctx, query+` LIMIT ?`, args, func(u user.User) error {
+		page.Users = append(page.Users, u)
+		return nil
+	})
 	if err != nil {
-		return id, err
+		return page, err
 	}
-	id, err = row.LastInsertId()
+
+	if len(page.Users) > opts.Limit {
+		page.Users = page.Users[:opts.Limit]
+		page.Next = user.CursorFor(page.Users[opts.Limit-1])
+	}
+	return page, r.loadContacts(ctx, page.Users)
+}
+
+// streamChunkSize is how many users StreamUsers reads before loading their
+// contacts.
+const streamChunkSize = 100
+
+// getUsersChunkSize is how many IDs GetUsers looks up per query.
+const getUsersChunkSize = 500
+
+// StreamUsers hands fn each matching user as its row is read, so memory use
+// does not grow with the listing. opts.Limit is ignored. Contacts are loaded
+// for a chunk of users at a time while the listing is still open, which
+// PostgreSQL only allows outside a transaction.
+func (r *Reopository) StreamUsers(ctx context.Context, opts user.ListOptions, fn func(user.User) error) error {
+	query, args := r.listQuery(opts.WithDefaults())
+	chunk := make([]user.User, 0, streamChunkSize)
+	flush := func() error {
+		if err := r.loadContacts(ctx, chunk); err != nil {
+			return err
+		}
+		for _, u := range chunk {
+			if err := fn(u); err != nil {
+				return err
+			}
+		}
+		chunk = chunk[:0]
+		return nil
+	}
+	err := r.eachRow(ctx, query, args, func(u user.User) error {
+		chunk = append(chunk, u)
+		if len(chunk) < streamChunkSize {
+			return nil
+		}
+		return flush()
+	})
 	if err != nil {
-		return id, err
+		return err
 	}
-	return id, nil
+	return flush()
 }
 
-func (r *Reopository) GetAllUsers() ([]user.User, error) {
-	var users []user.User
-	rows, err := r.db.Query(`SELECT * FROM users`)
+// listQuery selects the users matching opts that come after opts.After, in
+// order.
+func (r *Reopository) listQuery(opts user.ListOptions) (string, []interface{}) {
+	conditions, args := r.listFilter(opts)
+	if opts.After != nil {
+		condition, cursorArgs := keysetCondition(opts.Sort, opts.After)
+		conditions = append(conditions, condition)
+		args = append(args, cursorArgs...)
+	}
+	return `SELECT ` + userColumns + ` FROM users` + where(conditions) + orderBy(opts.Sort), args
+}
+
+// eachRow runs a query selecting userColumns and calls fn for every row,
+// stopping at the first error.
+func (r *Reopository) eachRow(ctx context.Context, query string, args []interface{}, fn func(user.User) error) error {
+	rows, err := r.conn.QueryContext(ctx, r.dialect.Rebind(query), args...)
 	if err != nil {
-		return users, err
+		return err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var user user.User
-		err = rows.Scan(&user.ID, &user.FirstName, &user.LastName)
+		u, err := scanUser(rows)
 		if err != nil {
-			return users, err
+			return err
+		}
+		if err := fn(u); err != nil {
+			return err
 		}
-		users = append(users, user)
-	}
-	err = rows.Err()
-	if err != nil {
-		return users, err
 	}
-	return users, nil
+	return rows.Err()
 }
 
-func (r *Reopository) GetUser(id int64) (user.User, error) {
-	var user user.User
-	err := r.db.QueryRow(`SELECT id, first_name, last_name FROM users WHERE id = ?`, id).Scan(&user.ID, &user.FirstName, &user.LastName)
+// GetUser returns the user unless it does not exist or is deleted.
+func (r *Reopository) GetUser(ctx context.Context, id int64) (user.User, error) {
+	query := r.dialect.Rebind(`SELECT ` + userColumns + ` FROM users WHERE id = ? AND deleted_at IS NULL`)
+	user, err := scanUser(r.conn.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, notFound(id)
+	}
 	if err != nil {
 		return user, err
 	}
-	return user, nil
+	return r.withContacts(ctx, user)
 }
 
-func (r *Reopository) UpdateUser(u user.User) (int64, error) {
-	var id int64
-	query := `UPDATE users SET first_name=?, last_name=? WHERE id=?`
-	statement, err := r.db.Prepare(query)
+// UpdateUser overwrites the user's names and increments its version. If
+// u.Version is set the update is conditional on it still being the stored
+// version, and fails with domain.ErrPreconditionFailed otherwise.
+func (r *Reopository) UpdateUser(ctx context.Context, u user.User, origin audit.Origin) (int64, error) {
+	_, err := r.ModifyUser(ctx, u.ID, origin, func(current user.User) (user.User, error) {
+		if u.Version != 0 && u.Version != current.Version {
+			return current, stale(u.ID, u.Version)
+		}
+		return u, nil
+	})
+	return u.ID, err
+}
+
+// ModifyUser reads the user, passes it to modify and stores the result along
+// with an audit event, all in one transaction. Nothing is written if modify
+// returns an error.
+func (r *Reopository) ModifyUser(ctx context.Context, id int64, origin audit.Origin, modify func(user.User) (user.User, error)) (user.User, error) {
+	var current, modified user.User
+	err := r.inTx(ctx, func(tx *Reopository) error {
+		var err error
+		current, err = tx.lockUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		if current.DeletedAt != nil {
+			current = user.User{}
+			return notFound(id)
+		}
+
+		modified, err = modify(current)
+		if err != nil {
+			return err
+		}
+		modified.ID = id
+		modified.Version = current.Version + 1
+		modified.DeletedAt = nil
+		if err := tx.checkConflicts(ctx, modified); err != nil {
+			return err
+		}
+
+		query := tx.dialect.Rebind(`UPDATE users SET first_name=?, last_name=?, external_id=?, title=?, department=?,
+			employee_number=?, start_date=?, manager_id=?, version=version+1 WHERE id=?`)
+		_, err = tx.conn.ExecContext(ctx, query, modified.FirstName, modified.LastName, nullString(modified.ExternalID),
+			modified.Title, modified.Department, modified.EmployeeNumber, nullString(modified.StartDate), modified.ManagerID, modified.ID)
+		if err != nil {
+			return tx.checkUnique(err, modified)
+		}
+		if err := tx.saveContacts(ctx, modified); err != nil {
+			return tx.checkUnique(err, modified)
+		}
+		return tx.recordEvent(ctx, audit.Update, origin, id, &current, &modified)
+	})
 	if err != nil {
-		return id, err
+		return current, err
 	}
-	row, err := statement.Exec(u.FirstName, u.LastName, u.ID)
+	return modified, nil
+}
+
+// DeleteUser marks the user as deleted, hiding it until it is restored or
+// purged. A non-zero version makes the delete conditional, as for
+// UpdateUser.
+func (r *Reopository) DeleteUser(ctx context.Context, id, version int64, origin audit.Origin) (int64, error) {
+	err := r.inTx(ctx, func(tx *Reopository) error {
+		current, err := tx.lockUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		if current.DeletedAt != nil {
+			return notFound(id)
+		}
+		if version != 0 && version != current.Version {
+			return stale(id, version)
+		}
+
+		deleted := current
+		deletedAt := time.Now().UTC()
+		deleted.DeletedAt = &deletedAt
+		deleted.Version++
+		query := tx.dialect.Rebind(`UPDATE users SET deleted_at=?, version=version+1 WHERE id=?`)
+		if _, err := tx.conn.ExecContext(ctx, query, deletedAt, id); err != nil {
+			return err
+		}
+		return tx.recordEvent(ctx, audit.Delete, origin, id, &current, &deleted)
+	})
+	return id, err
+}
+
+// RestoreUser undoes DeleteUser. Restoring a user that is not deleted is a
+// conflict.
+func (r *Reopository) RestoreUser(ctx context.Context, id int64, origin audit.Origin) (int64, error) {
+	err := r.inTx(ctx, func(tx *Reopository) error {
+		current, err := tx.lockUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		if current.DeletedAt == nil {
+			return fmt.Errorf("user #%d is not deleted: %w", id, domain.ErrConflict)
+		}
+
+		restored := current
+		restored.DeletedAt = nil
+		restored.Version++
+		query := tx.dialect.Rebind(`UPDATE users SET deleted_at=NULL, version=version+1 WHERE id=?`)
+		if _, err := tx.conn.ExecContext(ctx, query, id); err != nil {
+			return err
+		}
+		return tx.recordEvent(ctx, audit.Restore, origin, id, &current, &restored)
+	})
+	return id, err
+}
+
+// PurgeUsers permanently removes the users deleted before the given time and
+// returns their IDs in order. Each removal is audited as done by the system.
+func (r *Reopository) PurgeUsers(ctx context.Context, deletedBefore time.Time) ([]int64, error) {
+	var purged []user.User
+	err := r.inTx(ctx, func(tx *Reopository) error {
+		query := `SELECT ` + userColumns + ` FROM users
+			WHERE deleted_at IS NOT NULL AND deleted_at < ? ORDER BY id`
+		if tx.dialect == dialect.Postgres {
+			query += ` FOR UPDATE`
+		}
+		rows, err := tx.conn.QueryContext(ctx, tx.dialect.Rebind(query), deletedBefore.UTC())
+		if err != nil {
+			return err
+		}
+		purged = nil
+		for rows.Next() {
+			u, err := scanUser(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			purged = append(purged, u)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if err := tx.loadContacts(ctx, purged); err != nil {
+			return err
+		}
+
+		ids := make(map[int64]bool, len(purged))
+		for _, u := range purged {
+			ids[u.ID] = true
+		}
+		for _, u := range purged {
+			if err := tx.clearManager(ctx, u.ID, ids); err != nil {
+				return err
+			}
+		}
+		// Users purged together may manage each other, and each must be
+		// rid of its manager before that manager's row can go.
+		for _, u := range purged {
+			if u.ManagerID == nil {
+				continue
+			}
+			query := tx.dialect.Rebind(`UPDATE users SET manager_id = NULL WHERE id = ?`)
+			if _, err := tx.conn.ExecContext(ctx, query, u.ID); err != nil {
+				return err
+			}
+		}
+
+		for i := range purged {
+			u := &purged[i]
+			if err := tx.deleteContacts(ctx, u.ID); err != nil {
+				return err
+			}
+			// The user leaves their groups and loses their tags too.
+			for _, table := range []string{"group_users", "user_tags"} {
+				if _, err := tx.conn.ExecContext(ctx, tx.dialect.Rebind(`DELETE FROM `+table+` WHERE user_id=?`), u.ID); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.conn.ExecContext(ctx, tx.dialect.Rebind(`DELETE FROM users WHERE id=?`), u.ID); err != nil {
+				return err
+			}
+			if err := tx.recordEvent(ctx, audit.Purge, audit.System, u.ID, u, nil); err != nil {
+				return err
+			}
+		}
+		return tx.deleteUnusedTags(ctx)
+	})
 	if err != nil {
-		return id, err
+		return nil, err
 	}
-	id, err = row.LastInsertId()
-	if err != nil {
-		return id, err
+	ids := []int64{}
+	for _, u := range purged {
+		ids = append(ids, u.ID)
 	}
-	return id, nil
+	return ids, nil
 }
 
-func (r *Reopository) DeleteUser(id int64) (int64, error) {
-	query := `DELETE FROM users WHERE id=?`
-	statement, err := r.db.Prepare(query)
-	if err != nil {
-		return id, err
+// lockUser reads a user, deleted or not, within r's transaction. On
+// PostgreSQL the row stays locked until the transaction ends; SQLite only
+// ever lets one transaction write.
+func (r *Reopository) lockUser(ctx context.Context, id int64) (user.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = ?`
+	if r.dialect == dialect.Postgres {
+		query += ` FOR UPDATE`
+	}
+	u, err := scanUser(r.conn.QueryRowContext(ctx, r.dialect.Rebind(query), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return u, notFound(id)
 	}
-	row, err := statement.Exec(id)
 	if err != nil {
-		return id, err
+		return u, err
+	}
+	return r.withContacts(ctx, u)
+}
+
+// GetUsers looks the users up getUsersChunkSize at a time, keeping the
+// number of placeholders in each query well within the databases' limits.
+func (r *Reopository) GetUsers(ctx context.Context, ids []int64) ([]user.User, error) {
+	found := make(map[int64]user.User, len(ids))
+	for start := 0; start < len(ids); start += getUsersChunkSize {
+		end := start + getUsersChunkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		args := make([]interface{}, end-start)
+		for i, id := range ids[start:end] {
+			args[i] = id
+		}
+		query := `SELECT ` + userColumns + ` FROM users
+			WHERE id IN (?` + strings.Repeat(`, ?`, len(args)-1) + `) AND deleted_at IS NULL`
+		var chunk []user.User
+		err := r.eachRow(ctx, query, args, func(u user.User) error {
+			chunk = append(chunk, u)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := r.loadContacts(ctx, chunk); err != nil {
+			return nil, err
+		}
+		for _, u := range chunk {
+			found[u.ID] = u
+		}
+	}
+
+	users := make([]user.User, 0, len(found))
+	for _, id := range ids {
+		if u, ok := found[id]; ok {
+			users = append(users, u)
+			// An ID listed twice yields the user once.
+			delete(found, id)
+		}
+	}
+	return users, nil
+}
+
+// GetUserByExternalID returns the user, unless deleted, that the external
+// system knows by externalID.
+func (r *Reopository) GetUserByExternalID(ctx context.Context, externalID string) (user.User, error) {
+	query := r.dialect.Rebind(`SELECT ` + userColumns + ` FROM users WHERE external_id = ? AND deleted_at IS NULL`)
+	u, err := scanUser(r.conn.QueryRowContext(ctx, query, externalID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return u, fmt.Errorf("user with external ID %q: %w", externalID, domain.ErrNotFound)
 	}
-	id, err = row.LastInsertId()
 	if err != nil {
-		return id, err
+		return u, err
+	}
+	return r.withContacts(ctx, u)
+}
+
+// userColumns are the columns scanUser reads, qualified so that they stay
+// unambiguous in joins. The start date is cast so that PostgreSQL returns
+// it as YYYY-MM-DD rather than a timestamp.
+const userColumns = `users.id, users.first_name, users.last_name, users.version, users.deleted_at, users.external_id,
+	users.title, users.department, users.employee_number, CAST(users.start_date AS TEXT), users.manager_id`
+
+// scanUser reads a row of userColumns. Emails and phones live in their own
+// tables, for loadContacts to fill in.
+func scanUser(row interface {
+	Scan(dest ...interface{}) error
+}) (user.User, error) {
+	var u user.User
+	var externalID, startDate sql.NullString
+	var managerID sql.NullInt64
+	err := row.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Version, &u.DeletedAt, &externalID,
+		&u.Title, &u.Department, &u.EmployeeNumber, &startDate, &managerID)
+	u.ExternalID = externalID.String
+	u.StartDate = startDate.String
+	if managerID.Valid {
+		u.ManagerID = &managerID.Int64
+	}
+	return u, err
+}
+
+// nullString stores empty optional values as NULL, which unique indexes
+// ignore.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// checkConflicts looks for another user, deleted or not, holding the
+// external ID or primary email of u, and reports it as a
+// *domain.ConflictError naming that user.
+func (r *Reopository) checkConflicts(ctx context.Context, u user.User) error {
+	var id int64
+	if u.ExternalID != "" {
+		query := r.dialect.Rebind(`SELECT id FROM users WHERE external_id = ? AND id <> ?`)
+		err := r.conn.QueryRowContext(ctx, query, u.ExternalID, u.ID).Scan(&id)
+		if err == nil {
+			return &domain.ConflictError{Field: "externalId", Value: u.ExternalID, ID: id}
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+	if e, i := u.PrimaryEmail(); i >= 0 {
+		query := r.dialect.Rebind(`SELECT user_id FROM user_emails WHERE lower(address) = lower(?) AND is_primary AND user_id <> ?`)
+		err := r.conn.QueryRowContext(ctx, query, e.Address, u.ID).Scan(&id)
+		if err == nil {
+			return &domain.ConflictError{Field: fmt.Sprintf("emails[%d].address", i), Value: e.Address, ID: id}
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
 	}
-	return id, nil
+	return nil
+}
+
+// checkUnique reports the violation of a unique index by u as a conflict.
+// checkConflicts finds conflicts first, so this only happens when a
+// concurrent transaction took the value in the meantime, and the holder is
+// not known.
+func (r *Reopository) checkUnique(err error, u user.User) error {
+	if r.uniqueViolation != nil && r.uniqueViolation(err) {
+		return fmt.Errorf("the external ID or primary email of user #%d is already in use: %w", u.ID, domain.ErrConflict)
+	}
+	return err
+}
+
+func notFound(id int64) error {
+	return fmt.Errorf("user #%d: %w", id, domain.ErrNotFound)
+}
+
+func stale(id, version int64) error {
+	return fmt.Errorf("user #%d is no longer at version %d: %w", id, version, domain.ErrPreconditionFailed)
 }
@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/internal/postgres"
+	"github.com/pmaterer/peopler/internal/sqlite"
+	"github.com/pmaterer/peopler/user"
+	"github.com/pmaterer/peopler/user/repository/repositorytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// postgresDSNEnv names the environment variable holding a connection string
+// for a disposable PostgreSQL database. The Postgres suite is skipped when it
+// is unset.
+const postgresDSNEnv = "PEOPLER_TEST_POSTGRES_DSN"
+
+func TestSQLiteRepository(t *testing.T) {
+	skipWithoutFTS5(t)
+
+	repositorytest.Run(t, func(t *testing.T) repositorytest.Repository {
+		db, err := sqlite.NewSQLiteHandler(filepath.Join(t.TempDir(), "peopler.db"))
+		assert.Nil(t, err)
+		t.Cleanup(func() { db.Close() })
+		return NewRepository(db)
+	})
+}
+
+func TestSQLiteAuditEventsAreImmutable(t *testing.T) {
+	skipWithoutFTS5(t)
+
+	db, err := sqlite.NewSQLiteHandler(filepath.Join(t.TempDir(), "peopler.db"))
+	assert.Nil(t, err)
+	defer db.Close()
+	_, err = NewRepository(db).CreateUser(context.Background(), user.User{FirstName: "Shane", LastName: "Glass"}, audit.System)
+	assert.Nil(t, err)
+
+	_, err = db.Exec(`UPDATE audit_events SET actor = 'someone else'`)
+	assert.Error(t, err)
+	_, err = db.Exec(`DELETE FROM audit_events`)
+	assert.Error(t, err)
+}
+
+// TestSQLitePrimaryEmailIndex checks that the schema itself rejects a
+// duplicate primary email, which the repository checks for first.
+func TestSQLitePrimaryEmailIndex(t *testing.T) {
+	skipWithoutFTS5(t)
+
+	db, err := sqlite.NewSQLiteHandler(filepath.Join(t.TempDir(), "peopler.db"))
+	assert.Nil(t, err)
+	defer db.Close()
+	r := NewRepository(db)
+	emails := []user.Email{{Address: "shane@example.com", Type: user.ContactWork, Primary: true}}
+	_, err = r.CreateUser(context.Background(), user.User{FirstName: "Shane", LastName: "Glass", Emails: emails}, audit.System)
+	assert.Nil(t, err)
+	id, err := r.CreateUser(context.Background(), user.User{FirstName: "Timothy", LastName: "Jones"}, audit.System)
+	assert.Nil(t, err)
+
+	_, err = db.Exec(`INSERT INTO user_emails(user_id, position, address, type, is_primary) VALUES (?, 0, 'Shane@Example.com', 'work', TRUE)`, id)
+	assert.True(t, sqlite.IsUniqueViolation(err), "got %v", err)
+	_, err = db.Exec(`INSERT INTO user_emails(user_id, position, address, type, is_primary) VALUES (?, 0, 'Shane@Example.com', 'work', FALSE)`, id)
+	assert.Nil(t, err)
+}
+
+// TestSQLiteCancelledQueryIsAborted makes updates run a query that takes far
+// longer than the test, and checks that cancelling the context interrupts it
+// and rolls the update back.
+func TestSQLiteCancelledQueryIsAborted(t *testing.T) {
+	skipWithoutFTS5(t)
+
+	db, err := sqlite.NewSQLiteHandler(filepath.Join(t.TempDir(), "peopler.db"))
+	assert.Nil(t, err)
+	defer db.Close()
+	r := NewRepository(db)
+	id, err := r.CreateUser(context.Background(), user.User{FirstName: "Shane", LastName: "Glass"}, audit.System)
+	assert.Nil(t, err)
+
+	_, err = db.Exec(`
+		CREATE TABLE numbers (n INTEGER);
+		INSERT INTO numbers WITH RECURSIVE seq(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM seq LIMIT 1000) SELECT n FROM seq;
+		CREATE TRIGGER slow_update BEFORE UPDATE ON users BEGIN
+			SELECT count(*) FROM numbers a, numbers b, numbers c;
+		END;`)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name    string
+		context func() (context.Context, context.CancelFunc)
+		err     error
+	}{
+		{"Deadline", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 50*time.Millisecond)
+		}, context.DeadlineExceeded},
+		{"Cancelled", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			return ctx, cancel
+		}, context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := tt.context()
+			defer cancel()
+
+			start := time.Now()
+			_, err := r.UpdateUser(ctx, user.User{ID: id, FirstName: "Stephen", LastName: "Kingsley"}, audit.System)
+			assert.True(t, errors.Is(err, tt.err), "got %v", err)
+			assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+
+			u, err := r.GetUser(context.Background(), id)
+			assert.Nil(t, err)
+			assert.Equal(t, "Shane", u.FirstName)
+			assert.Equal(t, int64(1), u.Version)
+		})
+	}
+}
+
+// TestSQLiteWithTxRetriesBusy holds the write lock from another connection
+// and checks that WithTx retries until it is released, and gives up if it
+// never is.
+func TestSQLiteWithTxRetriesBusy(t *testing.T) {
+	skipWithoutFTS5(t)
+
+	ctx := context.Background()
+	// Fail at once instead of waiting for the lock, so retries kick in.
+	db, err := sqlite.NewSQLiteHandler(filepath.Join(t.TempDir(), "peopler.db") + "?_busy_timeout=0")
+	assert.Nil(t, err)
+	defer db.Close()
+	r := NewRepository(db)
+
+	lock := func() *sql.Conn {
+		conn, err := db.Conn(ctx)
+		assert.Nil(t, err)
+		_, err = conn.ExecContext(ctx, `BEGIN IMMEDIATE`)
+		assert.Nil(t, err)
+		return conn
+	}
+	unlock := func(conn *sql.Conn) {
+		_, err := conn.ExecContext(ctx, `ROLLBACK`)
+		assert.Nil(t, err)
+		conn.Close()
+	}
+	create := func(attempts *int) error {
+		return r.WithTx(ctx, func(tx user.Repository) error {
+			*attempts++
+			_, err := tx.CreateUser(ctx, user.User{FirstName: "Shane", LastName: "Glass"}, audit.System)
+			return err
+		})
+	}
+
+	conn := lock()
+	released := make(chan struct{})
+	time.AfterFunc(30*time.Millisecond, func() {
+		unlock(conn)
+		close(released)
+	})
+	var attempts int
+	assert.Nil(t, create(&attempts))
+	assert.Greater(t, attempts, 1)
+	<-released
+	page, err := r.GetAllUsers(ctx, user.ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), page.Total)
+
+	conn = lock()
+	defer unlock(conn)
+	attempts = 0
+	err = create(&attempts)
+	assert.True(t, sqlite.IsBusy(err), "got %v", err)
+	assert.Equal(t, maxTxAttempts, attempts)
+}
+
+func TestPostgresRepository(t *testing.T) {
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", postgresDSNEnv)
+	}
+
+	repositorytest.Run(t, func(t *testing.T) repositorytest.Repository {
+		db, err := postgres.NewPostgresHandler(dsn)
+		assert.Nil(t, err)
+		t.Cleanup(func() { db.Close() })
+		_, err = db.Exec(`TRUNCATE users, audit_events, attribute_definitions, tags RESTART IDENTITY CASCADE`)
+		assert.Nil(t, err)
+		return NewPostgresRepository(db)
+	})
+}
+
+func skipWithoutFTS5(t *testing.T) {
+	t.Helper()
+	db, err := sqlite.Open(":memory:")
+	assert.Nil(t, err)
+	enabled, err := sqlite.FTS5Enabled(db)
+	db.Close()
+	assert.Nil(t, err)
+	if !enabled {
+		t.Skip(sqlite.ErrFTS5Unavailable)
+	}
+}
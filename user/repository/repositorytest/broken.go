@@ -0,0 +1,73 @@
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/user"
+)
+
+// ErrBroken is the error a Broken repository fails with.
+var ErrBroken = errors.New("bad things")
+
+// Broken wraps a repository so that reading, listing and writing users
+// fails with ErrBroken, as it would with the database gone, for exercising
+// the error paths of the layers above. Every other call reaches the wrapped
+// repository, so only the failures a test needs have to be added here.
+type Broken struct {
+	user.Repository
+}
+
+func (Broken) CreateUser(ctx context.Context, u user.User, origin audit.Origin) (int64, error) {
+	return 0, ErrBroken
+}
+
+func (Broken) GetAllUsers(ctx context.Context, opts user.ListOptions) (user.Page, error) {
+	return user.Page{}, ErrBroken
+}
+
+func (Broken) StreamUsers(ctx context.Context, opts user.ListOptions, fn func(user.User) error) error {
+	return ErrBroken
+}
+
+func (Broken) GetUser(ctx context.Context, id int64) (user.User, error) {
+	return user.User{}, ErrBroken
+}
+
+func (Broken) UpdateUser(ctx context.Context, u user.User, origin audit.Origin) (int64, error) {
+	return 0, ErrBroken
+}
+
+func (Broken) DeleteUser(ctx context.Context, id, version int64, origin audit.Origin) (int64, error) {
+	return 0, ErrBroken
+}
+
+func (Broken) PurgeUsers(ctx context.Context, deletedBefore time.Time) ([]int64, error) {
+	return nil, ErrBroken
+}
+
+func (Broken) Reports(ctx context.Context, managerID int64, depth, limit int) ([]user.User, error) {
+	return nil, ErrBroken
+}
+
+func (Broken) Tags(ctx context.Context) ([]user.TagCount, error) {
+	return nil, ErrBroken
+}
+
+func (Broken) UserHistory(ctx context.Context, id int64) ([]audit.Event, error) {
+	return nil, ErrBroken
+}
+
+func (Broken) Batch(ctx context.Context, ops []user.BatchOperation, atomic bool, origin audit.Origin) ([]user.BatchResult, error) {
+	return nil, ErrBroken
+}
+
+// WithTx runs fn in a transaction of the wrapped repository that is broken
+// the same way.
+func (b Broken) WithTx(ctx context.Context, fn func(user.Repository) error) error {
+	return b.Repository.WithTx(ctx, func(tx user.Repository) error {
+		return fn(Broken{tx})
+	})
+}
@@ -0,0 +1,22 @@
+package repositorytest
+
+import (
+	"context"
+
+	"github.com/pmaterer/peopler/user"
+)
+
+// Counting wraps a repository to count the transactions run on it, and to
+// tell whether one is open, for checking how the layers above use them.
+type Counting struct {
+	user.Repository
+	Txs  int
+	Open bool
+}
+
+func (c *Counting) WithTx(ctx context.Context, fn func(user.Repository) error) error {
+	c.Txs++
+	c.Open = true
+	defer func() { c.Open = false }()
+	return c.Repository.WithTx(ctx, fn)
+}
@@ -0,0 +1,1244 @@
+// Package repositorytest is a conformance suite shared by every user
+// repository backend.
+package repositorytest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/user"
+	"github.com/stretchr/testify/assert"
+)
+
+// Repository is the storage contract the user service depends on.
+type Repository = user.Repository
+
+// Run exercises the behaviours every backend must share. newRepository must
+// return a repository over an empty store each time it is called.
+func Run(t *testing.T, newRepository func(t *testing.T) Repository) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, r Repository)
+	}{
+		{"CreateUser", testCreateUser},
+		{"GetAllUsers", testGetAllUsers},
+		{"GetAllUsersPagination", testGetAllUsersPagination},
+		{"GetAllUsersSort", testGetAllUsersSort},
+		{"GetAllUsersFilter", testGetAllUsersFilter},
+		{"StreamUsers", testStreamUsers},
+		{"GetUser", testGetUser},
+		{"GetUsers", testGetUsers},
+		{"SearchUsers", testSearchUsers},
+		{"UpdateUser", testUpdateUser},
+		{"ModifyUser", testModifyUser},
+		{"DeleteUser", testDeleteUser},
+		{"RestoreUser", testRestoreUser},
+		{"PurgeUsers", testPurgeUsers},
+		{"Versioning", testVersioning},
+		{"UserHistory", testUserHistory},
+		{"AuditEvents", testAuditEvents},
+		{"WithTx", testWithTx},
+		{"Batch", testBatch},
+		{"BatchUpsert", testBatchUpsert},
+		{"ExternalID", testExternalID},
+		{"Profile", testProfile},
+		{"UniquePrimaryEmail", testUniquePrimaryEmail},
+		{"Managers", testManagers},
+		{"LargeOrg", testLargeOrg},
+		{"Attributes", testAttributes},
+		{"Tags", testTags},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newRepository(t))
+		})
+	}
+}
+
+// origin is the audit origin of every change made by the suite.
+var origin = audit.Origin{Actor: "tester", RequestID: "req-1"}
+
+var testUsers = []user.User{
+	{FirstName: "Herman", LastName: "Melville"},
+	{FirstName: "Haruki", LastName: "Murakami"},
+	{FirstName: "Stanley", LastName: "Kubrick"},
+}
+
+func createUsers(t *testing.T, r Repository) []int64 {
+	var ids []int64
+	for _, u := range testUsers {
+		id, err := r.CreateUser(context.Background(), u, origin)
+		assert.Nil(t, err)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func testCreateUser(t *testing.T, r Repository) {
+	ids := createUsers(t, r)
+	for i := 1; i < len(ids); i++ {
+		assert.Greater(t, ids[i], ids[i-1])
+	}
+}
+
+func testGetAllUsers(t *testing.T, r Repository) {
+	page, err := r.GetAllUsers(context.Background(), user.ListOptions{})
+	assert.Nil(t, err)
+	assert.Empty(t, page.Users)
+	assert.Equal(t, int64(0), page.Total)
+	assert.Nil(t, page.Next)
+
+	ids := createUsers(t, r)
+	page, err = r.GetAllUsers(context.Background(), user.ListOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, page.Users, len(testUsers))
+	assert.Equal(t, int64(len(testUsers)), page.Total)
+	assert.Nil(t, page.Next)
+	for i, u := range page.Users {
+		assert.Equal(t, ids[i], u.ID)
+		assert.Equal(t, testUsers[i].FirstName, u.FirstName)
+		assert.Equal(t, testUsers[i].LastName, u.LastName)
+	}
+}
+
+// listAll follows the next cursors until the last page and returns the IDs
+// seen, in order.
+func listAll(t *testing.T, r Repository, opts user.ListOptions) []int64 {
+	var ids []int64
+	for {
+		page, err := r.GetAllUsers(context.Background(), opts)
+		assert.Nil(t, err)
+		if !assert.LessOrEqual(t, len(page.Users), opts.Limit) {
+			return ids
+		}
+		for _, u := range page.Users {
+			ids = append(ids, u.ID)
+		}
+		if page.Next == nil {
+			return ids
+		}
+		opts.After = page.Next
+	}
+}
+
+var directoryUsers = []user.User{
+	{FirstName: "Haruki", LastName: "Murakami"},
+	{FirstName: "Herman", LastName: "Melville"},
+	{FirstName: "Iris", LastName: "Murdoch"},
+	{FirstName: "Alice", LastName: "Munro"},
+	{FirstName: "Toni", LastName: "Morrison"},
+	{FirstName: "Harper", LastName: "Lee"},
+	{FirstName: "Ryu", LastName: "Murakami"},
+}
+
+func createDirectory(t *testing.T, r Repository) []int64 {
+	var ids []int64
+	for _, u := range directoryUsers {
+		id, err := r.CreateUser(context.Background(), u, origin)
+		assert.Nil(t, err)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func testGetAllUsersPagination(t *testing.T, r Repository) {
+	ids := createDirectory(t, r)
+
+	page, err := r.GetAllUsers(context.Background(), user.ListOptions{Limit: 3})
+	assert.Nil(t, err)
+	assert.Len(t, page.Users, 3)
+	assert.Equal(t, int64(len(ids)), page.Total)
+	assert.NotNil(t, page.Next)
+
+	assert.Equal(t, ids, listAll(t, r, user.ListOptions{Limit: 3}))
+	assert.Equal(t, ids, listAll(t, r, user.ListOptions{Limit: len(ids)}))
+}
+
+func testGetAllUsersSort(t *testing.T, r Repository) {
+	ids := createDirectory(t, r)
+
+	opts := user.ListOptions{
+		Limit: 2,
+		Sort: []user.SortField{
+			{Field: user.SortByLastName},
+			{Field: user.SortByID, Descending: true},
+		},
+	}
+	// Lee, Melville, Morrison, Munro, Murakami (Ryu), Murakami (Haruki), Murdoch
+	expected := []int64{ids[5], ids[1], ids[4], ids[3], ids[6], ids[0], ids[2]}
+	assert.Equal(t, expected, listAll(t, r, opts))
+
+	opts.Sort = []user.SortField{{Field: user.SortByFirstName, Descending: true}}
+	// Toni, Ryu, Iris, Herman, Haruki, Harper, Alice
+	expected = []int64{ids[4], ids[6], ids[2], ids[1], ids[0], ids[5], ids[3]}
+	assert.Equal(t, expected, listAll(t, r, opts))
+}
+
+func testGetAllUsersFilter(t *testing.T, r Repository) {
+	ids := createDirectory(t, r)
+
+	opts := user.ListOptions{Limit: 1, LastNamePrefix: "mura"}
+	page, err := r.GetAllUsers(context.Background(), opts)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), page.Total)
+	assert.Equal(t, []int64{ids[0], ids[6]}, listAll(t, r, opts))
+
+	opts = user.ListOptions{Limit: 10, FirstNamePrefix: "Ha", LastNamePrefix: "Mu"}
+	assert.Equal(t, []int64{ids[0]}, listAll(t, r, opts))
+
+	opts = user.ListOptions{Limit: 10, LastNamePrefix: "M%"}
+	assert.Empty(t, listAll(t, r, opts))
+}
+
+func testStreamUsers(t *testing.T, r Repository) {
+	ids := createDirectory(t, r)
+	stream := func(opts user.ListOptions) []int64 {
+		var streamed []int64
+		err := r.StreamUsers(context.Background(), opts, func(u user.User) error {
+			streamed = append(streamed, u.ID)
+			return nil
+		})
+		assert.Nil(t, err)
+		return streamed
+	}
+
+	opts := user.ListOptions{Limit: 2, Sort: []user.SortField{{Field: user.SortByLastName}}}
+	assert.Len(t, stream(opts), len(ids), "the limit is ignored")
+	assert.Equal(t, listAll(t, r, opts), stream(opts))
+
+	opts = user.ListOptions{LastNamePrefix: "mura", After: &user.Cursor{ID: ids[0]}}
+	assert.Equal(t, []int64{ids[6]}, stream(opts))
+
+	stop := errors.New("stop")
+	var calls int
+	err := r.StreamUsers(context.Background(), user.ListOptions{}, func(u user.User) error {
+		calls++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, calls)
+}
+
+func testGetUser(t *testing.T, r Repository) {
+	ids := createUsers(t, r)
+
+	u, err := r.GetUser(context.Background(), ids[1])
+	assert.Nil(t, err)
+	assert.Equal(t, ids[1], u.ID)
+	assert.Equal(t, testUsers[1].FirstName, u.FirstName)
+	assert.Equal(t, testUsers[1].LastName, u.LastName)
+
+	_, err = r.GetUser(context.Background(), ids[2]+100)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+}
+
+func testGetUsers(t *testing.T, r Repository) {
+	ctx := context.Background()
+	ids := createUsers(t, r)
+	_, err := r.UpdateUser(ctx, user.User{ID: ids[0], FirstName: "Herman", LastName: "Melville",
+		Emails: []user.Email{{Address: "herman@example.com", Type: user.ContactWork, Primary: true}}}, origin)
+	assert.Nil(t, err)
+	_, err = r.DeleteUser(ctx, ids[1], 0, origin)
+	assert.Nil(t, err)
+
+	users, err := r.GetUsers(ctx, []int64{ids[2], ids[1], ids[2] + 100, ids[0], ids[2]})
+	assert.Nil(t, err)
+	if assert.Len(t, users, 2, "missing, deleted and repeated users are left out") {
+		assert.Equal(t, ids[2], users[0].ID)
+		assert.Equal(t, testUsers[2].LastName, users[0].LastName)
+		assert.Equal(t, ids[0], users[1].ID)
+		assert.Len(t, users[1].Emails, 1)
+	}
+
+	users, err = r.GetUsers(ctx, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, users)
+}
+
+func searchIDs(t *testing.T, r Repository, q string, limit int) ([]int64, int64) {
+	page, err := r.SearchUsers(context.Background(), user.SearchTerms(q), limit)
+	assert.Nil(t, err)
+	var ids []int64
+	for _, u := range page.Users {
+		ids = append(ids, u.ID)
+	}
+	return ids, page.Total
+}
+
+func testSearchUsers(t *testing.T, r Repository) {
+	ids := createDirectory(t, r)
+	renee, err := r.CreateUser(context.Background(), user.User{FirstName: "Renée", LastName: "Müller"}, origin)
+	assert.Nil(t, err)
+
+	found, total := searchIDs(t, r, "Mur", 10)
+	assert.ElementsMatch(t, []int64{ids[0], ids[2], ids[6]}, found)
+	assert.Equal(t, int64(3), total)
+
+	found, total = searchIDs(t, r, "mur", 1)
+	assert.Len(t, found, 1)
+	assert.Equal(t, int64(3), total)
+
+	found, _ = searchIDs(t, r, "murakami ha", 10)
+	assert.Equal(t, []int64{ids[0]}, found)
+
+	found, _ = searchIDs(t, r, "renee muller", 10)
+	assert.Equal(t, []int64{renee}, found)
+
+	found, _ = searchIDs(t, r, "RENÉE", 10)
+	assert.Equal(t, []int64{renee}, found)
+
+	found, total = searchIDs(t, r, "nobody", 10)
+	assert.Empty(t, found)
+	assert.Equal(t, int64(0), total)
+
+	// Updates and deletes must be reflected in the index.
+	_, err = r.UpdateUser(context.Background(), user.User{ID: ids[3], FirstName: "Alice", LastName: "Murray"}, origin)
+	assert.Nil(t, err)
+	_, err = r.DeleteUser(context.Background(), ids[2], 0, origin)
+	assert.Nil(t, err)
+	found, _ = searchIDs(t, r, "mur", 10)
+	assert.ElementsMatch(t, []int64{ids[0], ids[3], ids[6]}, found)
+}
+
+func testUpdateUser(t *testing.T, r Repository) {
+	ids := createUsers(t, r)
+
+	id, err := r.UpdateUser(context.Background(), user.User{ID: ids[0], FirstName: "Herman", LastName: "Hesse"}, origin)
+	assert.Nil(t, err)
+	assert.Equal(t, ids[0], id)
+
+	u, err := r.GetUser(context.Background(), ids[0])
+	assert.Nil(t, err)
+	assert.Equal(t, "Hesse", u.LastName)
+
+	other, err := r.GetUser(context.Background(), ids[1])
+	assert.Nil(t, err)
+	assert.Equal(t, testUsers[1].LastName, other.LastName)
+
+	_, err = r.UpdateUser(context.Background(), user.User{ID: ids[2] + 100, FirstName: "Nobody", LastName: "Nowhere"}, origin)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+}
+
+func testModifyUser(t *testing.T, r Repository) {
+	ids := createUsers(t, r)
+
+	modified, err := r.ModifyUser(context.Background(), ids[0], origin, func(u user.User) (user.User, error) {
+		assert.Equal(t, testUsers[0].LastName, u.LastName)
+		u.LastName = "Hesse"
+		return u, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, ids[0], modified.ID)
+	assert.Equal(t, "Hesse", modified.LastName)
+
+	u, err := r.GetUser(context.Background(), ids[0])
+	assert.Nil(t, err)
+	assert.Equal(t, modified, u)
+
+	errRejected := errors.New("rejected")
+	_, err = r.ModifyUser(context.Background(), ids[1], origin, func(u user.User) (user.User, error) {
+		u.LastName = "Changed"
+		return u, errRejected
+	})
+	assert.True(t, errors.Is(err, errRejected))
+	u, err = r.GetUser(context.Background(), ids[1])
+	assert.Nil(t, err)
+	assert.Equal(t, testUsers[1].LastName, u.LastName)
+
+	_, err = r.ModifyUser(context.Background(), ids[2]+100, origin, func(u user.User) (user.User, error) {
+		t.Error("modify called for a missing user")
+		return u, nil
+	})
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+}
+
+func testDeleteUser(t *testing.T, r Repository) {
+	ids := createUsers(t, r)
+
+	id, err := r.DeleteUser(context.Background(), ids[0], 0, origin)
+	assert.Nil(t, err)
+	assert.Equal(t, ids[0], id)
+
+	_, err = r.GetUser(context.Background(), ids[0])
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+
+	page, err := r.GetAllUsers(context.Background(), user.ListOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, page.Users, len(testUsers)-1)
+
+	_, err = r.DeleteUser(context.Background(), ids[0], 0, origin)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+
+	// Deleted users stay hidden from every read and write...
+	_, err = r.UpdateUser(context.Background(), user.User{ID: ids[0], FirstName: "Herman", LastName: "Hesse"}, origin)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+	_, err = r.ModifyUser(context.Background(), ids[0], origin, func(u user.User) (user.User, error) { return u, nil })
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+	found, _ := searchIDs(t, r, "melville", 10)
+	assert.Empty(t, found)
+
+	// ...except listings that ask for them.
+	page, err = r.GetAllUsers(context.Background(), user.ListOptions{IncludeDeleted: true})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(testUsers)), page.Total)
+	assert.Equal(t, ids[0], page.Users[0].ID)
+	if assert.NotNil(t, page.Users[0].DeletedAt) {
+		assert.WithinDuration(t, time.Now(), *page.Users[0].DeletedAt, time.Minute)
+	}
+	assert.Nil(t, page.Users[1].DeletedAt)
+}
+
+func testRestoreUser(t *testing.T, r Repository) {
+	ids := createUsers(t, r)
+	_, err := r.DeleteUser(context.Background(), ids[0], 0, origin)
+	assert.Nil(t, err)
+
+	id, err := r.RestoreUser(context.Background(), ids[0], origin)
+	assert.Nil(t, err)
+	assert.Equal(t, ids[0], id)
+
+	u, err := r.GetUser(context.Background(), ids[0])
+	assert.Nil(t, err)
+	assert.Nil(t, u.DeletedAt)
+	assert.Equal(t, testUsers[0].LastName, u.LastName)
+	// Deleting and restoring are both changes.
+	assert.Equal(t, int64(3), u.Version)
+
+	_, err = r.RestoreUser(context.Background(), ids[0], origin)
+	assert.True(t, errors.Is(err, domain.ErrConflict))
+	_, err = r.RestoreUser(context.Background(), ids[2]+100, origin)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+}
+
+func testPurgeUsers(t *testing.T, r Repository) {
+	ids := createUsers(t, r)
+	_, err := r.DeleteUser(context.Background(), ids[0], 0, origin)
+	assert.Nil(t, err)
+
+	purged, err := r.PurgeUsers(context.Background(), time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.Empty(t, purged)
+
+	_, err = r.DeleteUser(context.Background(), ids[1], 0, origin)
+	assert.Nil(t, err)
+	purged, err = r.PurgeUsers(context.Background(), time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, ids[:2], purged)
+
+	page, err := r.GetAllUsers(context.Background(), user.ListOptions{IncludeDeleted: true})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), page.Total)
+	assert.Equal(t, ids[2], page.Users[0].ID)
+
+	_, err = r.RestoreUser(context.Background(), ids[0], origin)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+}
+
+func testVersioning(t *testing.T, r Repository) {
+	ids := createUsers(t, r)
+
+	u, err := r.GetUser(context.Background(), ids[0])
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), u.Version)
+
+	// Unconditional and matching updates both increment the version.
+	_, err = r.UpdateUser(context.Background(), user.User{ID: ids[0], FirstName: "Herman", LastName: "Hesse"}, origin)
+	assert.Nil(t, err)
+	_, err = r.UpdateUser(context.Background(), user.User{ID: ids[0], FirstName: "Hermann", LastName: "Hesse", Version: 2}, origin)
+	assert.Nil(t, err)
+	modified, err := r.ModifyUser(context.Background(), ids[0], origin, func(u user.User) (user.User, error) {
+		assert.Equal(t, int64(3), u.Version)
+		return u, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), modified.Version)
+	u, err = r.GetUser(context.Background(), ids[0])
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), u.Version)
+	assert.Equal(t, "Hermann", u.FirstName)
+
+	page, err := r.GetAllUsers(context.Background(), user.ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), page.Users[0].Version)
+
+	// A stale version changes nothing.
+	_, err = r.UpdateUser(context.Background(), user.User{ID: ids[0], FirstName: "Nobody", LastName: "Nowhere", Version: 3}, origin)
+	assert.True(t, errors.Is(err, domain.ErrPreconditionFailed))
+	_, err = r.DeleteUser(context.Background(), ids[0], 3, origin)
+	assert.True(t, errors.Is(err, domain.ErrPreconditionFailed))
+	u, err = r.GetUser(context.Background(), ids[0])
+	assert.Nil(t, err)
+	assert.Equal(t, "Hermann", u.FirstName)
+
+	// A missing user is not found whatever the version.
+	_, err = r.UpdateUser(context.Background(), user.User{ID: ids[2] + 100, FirstName: "Nobody", LastName: "Nowhere", Version: 1}, origin)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+	_, err = r.DeleteUser(context.Background(), ids[2]+100, 1, origin)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+
+	_, err = r.DeleteUser(context.Background(), ids[0], 4, origin)
+	assert.Nil(t, err)
+}
+
+func operations(events []audit.Event) []audit.Operation {
+	var ops []audit.Operation
+	for _, e := range events {
+		ops = append(ops, e.Operation)
+	}
+	return ops
+}
+
+func testUserHistory(t *testing.T, r Repository) {
+	ids := createUsers(t, r)
+	id := ids[0]
+
+	_, err := r.UpdateUser(context.Background(), user.User{ID: id, FirstName: "Herman", LastName: "Hesse"}, origin)
+	assert.Nil(t, err)
+	// Failed changes leave no trace.
+	_, err = r.UpdateUser(context.Background(), user.User{ID: id, FirstName: "Herman", LastName: "Hesse", Version: 1}, origin)
+	assert.True(t, errors.Is(err, domain.ErrPreconditionFailed))
+	_, err = r.ModifyUser(context.Background(), id, origin, func(u user.User) (user.User, error) {
+		return u, errors.New("rejected")
+	})
+	assert.Error(t, err)
+	other := audit.Origin{Actor: "admin", RequestID: "req-2"}
+	_, err = r.DeleteUser(context.Background(), id, 0, other)
+	assert.Nil(t, err)
+	_, err = r.RestoreUser(context.Background(), id, origin)
+	assert.Nil(t, err)
+	_, err = r.DeleteUser(context.Background(), id, 0, origin)
+	assert.Nil(t, err)
+	_, err = r.PurgeUsers(context.Background(), time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+
+	events, err := r.UserHistory(context.Background(), id)
+	assert.Nil(t, err)
+	assert.Equal(t, []audit.Operation{audit.Create, audit.Update, audit.Delete, audit.Restore, audit.Delete, audit.Purge},
+		operations(events))
+	for i, e := range events {
+		assert.Equal(t, id, e.UserID)
+		assert.WithinDuration(t, time.Now(), e.At, time.Minute)
+		if i > 0 {
+			assert.Greater(t, e.ID, events[i-1].ID)
+		}
+	}
+
+	created := events[0]
+	assert.Equal(t, origin.Actor, created.Actor)
+	assert.Equal(t, origin.RequestID, created.RequestID)
+	assert.Nil(t, created.Changes["lastName"].Before)
+	assert.JSONEq(t, `"Melville"`, string(created.Changes["lastName"].After))
+
+	updated := events[1]
+	assert.Equal(t, []string{"lastName"}, keys(updated.Changes))
+	assert.JSONEq(t, `"Melville"`, string(updated.Changes["lastName"].Before))
+	assert.JSONEq(t, `"Hesse"`, string(updated.Changes["lastName"].After))
+
+	deleted := events[2]
+	assert.Equal(t, other.Actor, deleted.Actor)
+	assert.Equal(t, other.RequestID, deleted.RequestID)
+	assert.Equal(t, []string{"deletedAt"}, keys(deleted.Changes))
+	assert.Nil(t, deleted.Changes["deletedAt"].Before)
+	assert.NotNil(t, deleted.Changes["deletedAt"].After)
+
+	purged := events[5]
+	assert.Equal(t, audit.System.Actor, purged.Actor)
+	assert.JSONEq(t, `"Hesse"`, string(purged.Changes["lastName"].Before))
+	assert.Nil(t, purged.Changes["lastName"].After)
+
+	events, err = r.UserHistory(context.Background(), ids[1])
+	assert.Nil(t, err)
+	assert.Equal(t, []audit.Operation{audit.Create}, operations(events))
+}
+
+func keys(changes map[string]audit.Change) []string {
+	var names []string
+	for name := range changes {
+		names = append(names, name)
+	}
+	return names
+}
+
+func testAuditEvents(t *testing.T, r Repository) {
+	ids := createUsers(t, r)
+	since := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	_, err := r.DeleteUser(context.Background(), ids[0], 0, origin)
+	assert.Nil(t, err)
+	_, err = r.DeleteUser(context.Background(), ids[1], 0, origin)
+	assert.Nil(t, err)
+	_, err = r.DeleteUser(context.Background(), ids[2], 0, origin)
+	assert.Nil(t, err)
+
+	page, err := r.AuditEvents(context.Background(), audit.Query{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), page.Total)
+	assert.Len(t, page.Events, 6)
+	assert.Zero(t, page.Next)
+
+	q := audit.Query{Since: since, Limit: 2}
+	page, err = r.AuditEvents(context.Background(), q)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), page.Total)
+	assert.Equal(t, []audit.Operation{audit.Delete, audit.Delete}, operations(page.Events))
+	assert.Equal(t, ids[0], page.Events[0].UserID)
+	assert.NotZero(t, page.Next)
+
+	q.After = page.Next
+	page, err = r.AuditEvents(context.Background(), q)
+	assert.Nil(t, err)
+	assert.Len(t, page.Events, 1)
+	assert.Equal(t, ids[2], page.Events[0].UserID)
+	assert.Zero(t, page.Next)
+
+	// Events serialise with their changes.
+	doc, err := json.Marshal(page.Events[0])
+	assert.Nil(t, err)
+	assert.Contains(t, string(doc), `"operation":"delete"`)
+	assert.Contains(t, string(doc), `"deletedAt":{"after":`)
+}
+
+func testWithTx(t *testing.T, r Repository) {
+	ctx := context.Background()
+	var first, second int64
+	err := r.WithTx(ctx, func(tx Repository) error {
+		var err error
+		first, err = tx.CreateUser(ctx, user.User{FirstName: "Shane", LastName: "Glass"}, origin)
+		if err != nil {
+			return err
+		}
+		// Writes are visible within the transaction, also when joining it.
+		return tx.WithTx(ctx, func(tx Repository) error {
+			if _, err := tx.GetUser(ctx, first); err != nil {
+				return err
+			}
+			second, err = tx.CreateUser(ctx, user.User{FirstName: "Timothy", LastName: "Jones"}, origin)
+			return err
+		})
+	})
+	assert.Nil(t, err)
+	for _, id := range []int64{first, second} {
+		_, err := r.GetUser(ctx, id)
+		assert.Nil(t, err)
+	}
+
+	// A failed unit of work leaves neither the changes nor their audit
+	// events behind.
+	rollback := errors.New("rolled back")
+	err = r.WithTx(ctx, func(tx Repository) error {
+		if _, err := tx.UpdateUser(ctx, user.User{ID: first, FirstName: "Herman", LastName: "Hesse"}, origin); err != nil {
+			return err
+		}
+		if _, err := tx.DeleteUser(ctx, second, 0, origin); err != nil {
+			return err
+		}
+		if _, err := tx.CreateUser(ctx, user.User{FirstName: "Julian", LastName: "Barnes"}, origin); err != nil {
+			return err
+		}
+		return rollback
+	})
+	assert.True(t, errors.Is(err, rollback))
+
+	page, err := r.GetAllUsers(ctx, user.ListOptions{IncludeDeleted: true})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), page.Total)
+	u, err := r.GetUser(ctx, first)
+	assert.Nil(t, err)
+	assert.Equal(t, "Shane", u.FirstName)
+	assert.Equal(t, int64(1), u.Version)
+	events, err := r.AuditEvents(ctx, audit.Query{})
+	assert.Nil(t, err)
+	assert.Equal(t, []audit.Operation{audit.Create, audit.Create}, operations(events.Events))
+}
+
+func testBatch(t *testing.T, r Repository) {
+	ctx := context.Background()
+	ids := createUsers(t, r)
+
+	results, err := r.Batch(ctx, []user.BatchOperation{
+		{Op: user.BatchCreate, User: user.User{FirstName: "Julian", LastName: "Barnes"}},
+		{Op: user.BatchUpdate, ID: ids[0], Version: 1, User: user.User{FirstName: "Herman", LastName: "Hesse"}},
+		{Op: user.BatchUpdate, ID: ids[1], Version: 7, User: user.User{FirstName: "Herman", LastName: "Hesse"}},
+		{Op: user.BatchDelete, ID: 999},
+		{Op: user.BatchDelete, ID: ids[2]},
+	}, false, origin)
+	assert.Nil(t, err)
+	if assert.Len(t, results, 5) {
+		assert.Nil(t, results[0].Err)
+		assert.NotZero(t, results[0].ID)
+		assert.Equal(t, int64(1), results[0].Version)
+		assert.Nil(t, results[1].Err)
+		assert.Equal(t, int64(2), results[1].Version)
+		assert.True(t, errors.Is(results[2].Err, domain.ErrPreconditionFailed))
+		assert.True(t, errors.Is(results[3].Err, domain.ErrNotFound))
+		assert.Nil(t, results[4].Err)
+	}
+	page, err := r.GetAllUsers(ctx, user.ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(ids)), page.Total, "one created, one deleted")
+	u, err := r.GetUser(ctx, ids[1])
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), u.Version)
+
+	// In an atomic batch one failure undoes the operations before it and
+	// skips those after it.
+	before, err := r.AuditEvents(ctx, audit.Query{})
+	assert.Nil(t, err)
+	results, err = r.Batch(ctx, []user.BatchOperation{
+		{Op: user.BatchCreate, User: user.User{FirstName: "Ian", LastName: "McEwan"}},
+		{Op: user.BatchDelete, ID: ids[1]},
+		{Op: user.BatchUpdate, ID: 999, User: user.User{FirstName: "Herman", LastName: "Hesse"}},
+		{Op: user.BatchDelete, ID: ids[0]},
+	}, true, origin)
+	assert.Nil(t, err)
+	if assert.Len(t, results, 4) {
+		assert.True(t, errors.Is(results[2].Err, domain.ErrNotFound))
+		for _, i := range []int{0, 1, 3} {
+			assert.True(t, errors.Is(results[i].Err, domain.ErrNotApplied), "operation %d", i)
+			assert.Zero(t, results[i].ID)
+		}
+	}
+	after, err := r.AuditEvents(ctx, audit.Query{})
+	assert.Nil(t, err)
+	assert.Equal(t, before.Total, after.Total)
+	page, err = r.GetAllUsers(ctx, user.ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(ids)), page.Total)
+
+	// That holds within a caller's transaction, too.
+	err = r.WithTx(ctx, func(tx Repository) error {
+		_, err := tx.Batch(ctx, []user.BatchOperation{
+			{Op: user.BatchCreate, User: user.User{FirstName: "Ian", LastName: "McEwan"}},
+			{Op: user.BatchDelete, ID: 999},
+		}, true, origin)
+		return err
+	})
+	assert.Nil(t, err)
+	page, err = r.GetAllUsers(ctx, user.ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(ids)), page.Total)
+}
+
+func testBatchUpsert(t *testing.T, r Repository) {
+	ctx := context.Background()
+	results, err := r.Batch(ctx, []user.BatchOperation{
+		{Op: user.BatchUpsert, User: user.User{FirstName: "Shane", LastName: "Glass", ExternalID: "E1"}},
+		{Op: user.BatchUpsert, User: user.User{FirstName: "Timothy", LastName: "Jones"}},
+		{Op: user.BatchUpsert, User: user.User{FirstName: "Shane", LastName: "Glasser", ExternalID: "E1"}},
+	}, true, origin)
+	assert.Nil(t, err)
+	if assert.Len(t, results, 3) {
+		assert.True(t, results[0].Created)
+		assert.True(t, results[1].Created)
+		assert.NotEqual(t, results[0].ID, results[1].ID)
+		assert.Nil(t, results[2].Err)
+		assert.False(t, results[2].Created)
+		assert.Equal(t, results[0].ID, results[2].ID)
+		assert.Equal(t, int64(2), results[2].Version)
+	}
+	u, err := r.GetUserByExternalID(ctx, "E1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Glasser", u.LastName)
+
+	// A partial upsert keeps the fields it does not name.
+	u.Title = "Engineer"
+	u.Emails = []user.Email{{Address: "shane@example.com", Type: user.ContactWork, Primary: true}}
+	_, err = r.UpdateUser(ctx, u, origin)
+	assert.Nil(t, err)
+	results, err = r.Batch(ctx, []user.BatchOperation{
+		{Op: user.BatchUpsert, User: user.User{FirstName: "Shane", LastName: "Glass", ExternalID: "E1"}, Fields: []string{"externalId", "lastName"}},
+	}, true, origin)
+	assert.Nil(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Nil(t, results[0].Err)
+	}
+	u, err = r.GetUserByExternalID(ctx, "E1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Glass", u.LastName)
+	assert.Equal(t, "Engineer", u.Title)
+	assert.Len(t, u.Emails, 1)
+}
+
+func testExternalID(t *testing.T, r Repository) {
+	ctx := context.Background()
+	id, err := r.CreateUser(ctx, user.User{FirstName: "Shane", LastName: "Glass", ExternalID: "E1"}, origin)
+	assert.Nil(t, err)
+	other, err := r.CreateUser(ctx, user.User{FirstName: "Timothy", LastName: "Jones"}, origin)
+	assert.Nil(t, err)
+	// Users without an external ID do not clash.
+	_, err = r.CreateUser(ctx, user.User{FirstName: "Herman", LastName: "Hesse"}, origin)
+	assert.Nil(t, err)
+
+	u, err := r.GetUserByExternalID(ctx, "E1")
+	assert.Nil(t, err)
+	assert.Equal(t, id, u.ID)
+	assert.Equal(t, "E1", u.ExternalID)
+	_, err = r.GetUserByExternalID(ctx, "E2")
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+
+	_, err = r.CreateUser(ctx, user.User{FirstName: "Julian", LastName: "Barnes", ExternalID: "E1"}, origin)
+	assert.True(t, errors.Is(err, domain.ErrConflict), "got %v", err)
+	var conflictErr *domain.ConflictError
+	if assert.True(t, errors.As(err, &conflictErr)) {
+		assert.Equal(t, domain.ConflictError{Field: "externalId", Value: "E1", ID: id}, *conflictErr)
+	}
+	_, err = r.UpdateUser(ctx, user.User{ID: other, FirstName: "Timothy", LastName: "Jones", ExternalID: "E1"}, origin)
+	assert.True(t, errors.Is(err, domain.ErrConflict), "got %v", err)
+
+	// Deleted users keep their external ID until purged.
+	_, err = r.DeleteUser(ctx, id, 0, origin)
+	assert.Nil(t, err)
+	_, err = r.GetUserByExternalID(ctx, "E1")
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+	_, err = r.UpdateUser(ctx, user.User{ID: other, FirstName: "Timothy", LastName: "Jones", ExternalID: "E1"}, origin)
+	assert.True(t, errors.Is(err, domain.ErrConflict), "got %v", err)
+	_, err = r.PurgeUsers(ctx, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	_, err = r.UpdateUser(ctx, user.User{ID: other, FirstName: "Timothy", LastName: "Jones", ExternalID: "E1"}, origin)
+	assert.Nil(t, err)
+	u, err = r.GetUser(ctx, other)
+	assert.Nil(t, err)
+	assert.Equal(t, "E1", u.ExternalID)
+}
+
+func testProfile(t *testing.T, r Repository) {
+	ctx := context.Background()
+	haruki := user.User{
+		FirstName: "Haruki", LastName: "Murakami", ExternalID: "E1",
+		Emails: []user.Email{
+			{Address: "haruki@example.com", Type: user.ContactWork, Primary: true},
+			{Address: "h@example.org", Type: user.ContactHome},
+		},
+		Phones:     []user.Phone{{Number: "+81312345678", Type: user.ContactMobile, Primary: true}},
+		Title:      "Novelist",
+		Department: "Fiction",
+		// Leading zeros must survive.
+		EmployeeNumber: "0042",
+		StartDate:      "1979-06-01",
+	}
+	id, err := r.CreateUser(ctx, haruki, origin)
+	assert.Nil(t, err)
+	other, err := r.CreateUser(ctx, user.User{FirstName: "Ryu", LastName: "Murakami"}, origin)
+	assert.Nil(t, err)
+	haruki.ID, haruki.Version = id, 1
+
+	u, err := r.GetUser(ctx, id)
+	assert.Nil(t, err)
+	assert.Equal(t, haruki, u)
+	u, err = r.GetUserByExternalID(ctx, "E1")
+	assert.Nil(t, err)
+	assert.Equal(t, haruki, u)
+
+	// Callers get their own copies of the contacts.
+	u.Emails[0].Address = "someone@example.com"
+	u, err = r.GetUser(ctx, id)
+	assert.Nil(t, err)
+	assert.Equal(t, "haruki@example.com", u.Emails[0].Address)
+
+	page, err := r.GetAllUsers(ctx, user.ListOptions{})
+	assert.Nil(t, err)
+	if assert.Len(t, page.Users, 2) {
+		assert.Equal(t, haruki, page.Users[0])
+		assert.Empty(t, page.Users[1].Emails)
+	}
+	var streamed []user.User
+	err = r.StreamUsers(ctx, user.ListOptions{}, func(u user.User) error {
+		streamed = append(streamed, u)
+		return nil
+	})
+	assert.Nil(t, err)
+	if assert.Len(t, streamed, 2) {
+		assert.Equal(t, haruki, streamed[0])
+	}
+	page, err = r.SearchUsers(ctx, user.SearchTerms("haruki"), 10)
+	assert.Nil(t, err)
+	if assert.Len(t, page.Users, 1) {
+		assert.Equal(t, haruki, page.Users[0])
+	}
+
+	haruki.Emails = []user.Email{{Address: "h@example.org", Type: user.ContactHome, Primary: true}}
+	haruki.Phones = nil
+	haruki.StartDate = ""
+	_, err = r.UpdateUser(ctx, haruki, origin)
+	assert.Nil(t, err)
+	haruki.Version++
+	u, err = r.GetUser(ctx, id)
+	assert.Nil(t, err)
+	assert.Equal(t, haruki, u)
+
+	// Purging a user removes its contacts without touching anyone else's.
+	_, err = r.UpdateUser(ctx, user.User{ID: other, FirstName: "Ryu", LastName: "Murakami",
+		Emails: []user.Email{{Address: "ryu@example.com", Type: user.ContactWork, Primary: true}}}, origin)
+	assert.Nil(t, err)
+	_, err = r.DeleteUser(ctx, id, 0, origin)
+	assert.Nil(t, err)
+	_, err = r.PurgeUsers(ctx, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	u, err = r.GetUser(ctx, other)
+	assert.Nil(t, err)
+	assert.Equal(t, []user.Email{{Address: "ryu@example.com", Type: user.ContactWork, Primary: true}}, u.Emails)
+}
+
+func testUniquePrimaryEmail(t *testing.T, r Repository) {
+	ctx := context.Background()
+	email := func(address string, primary bool) user.Email {
+		return user.Email{Address: address, Type: user.ContactWork, Primary: primary}
+	}
+	assertConflict := func(err error, field string, id int64) {
+		t.Helper()
+		var conflictErr *domain.ConflictError
+		if assert.True(t, errors.As(err, &conflictErr), "got %v", err) {
+			assert.Equal(t, field, conflictErr.Field)
+			assert.Equal(t, id, conflictErr.ID)
+		}
+	}
+
+	shane, err := r.CreateUser(ctx, user.User{FirstName: "Shane", LastName: "Glass",
+		Emails: []user.Email{email("shane@example.com", true)}}, origin)
+	assert.Nil(t, err)
+
+	timothy := user.User{FirstName: "Timothy", LastName: "Jones",
+		Emails: []user.Email{email("tim@example.com", true), email("Shane@Example.com", false)}}
+	timothy.ID, err = r.CreateUser(ctx, timothy, origin)
+	assert.Nil(t, err, "only primary emails are unique")
+
+	_, err = r.CreateUser(ctx, user.User{FirstName: "Julian", LastName: "Barnes", ID: shane,
+		Emails: []user.Email{email("SHANE@example.COM", true)}}, origin)
+	assertConflict(err, "emails[0].address", shane)
+
+	timothy.Emails = []user.Email{email("tim@example.com", false), email("Shane@Example.com", true)}
+	_, err = r.UpdateUser(ctx, timothy, origin)
+	assertConflict(err, "emails[1].address", shane)
+	u, err := r.GetUser(ctx, timothy.ID)
+	assert.Nil(t, err)
+	assert.True(t, u.Emails[0].Primary, "a conflicting update changes nothing")
+
+	// Users may keep their own primary email, and deleted users keep theirs
+	// until purged.
+	_, err = r.UpdateUser(ctx, user.User{ID: shane, FirstName: "Shane", LastName: "Glass",
+		Emails: []user.Email{email("SHANE@example.com", true)}}, origin)
+	assert.Nil(t, err)
+	_, err = r.DeleteUser(ctx, shane, 0, origin)
+	assert.Nil(t, err)
+	_, err = r.UpdateUser(ctx, timothy, origin)
+	assertConflict(err, "emails[1].address", shane)
+	_, err = r.PurgeUsers(ctx, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	_, err = r.UpdateUser(ctx, timothy, origin)
+	assert.Nil(t, err)
+}
+
+func testManagers(t *testing.T, r Repository) {
+	ctx := context.Background()
+	create := func(first, last string, managerID *int64) int64 {
+		t.Helper()
+		id, err := r.CreateUser(ctx, user.User{FirstName: first, LastName: last, ManagerID: managerID}, origin)
+		assert.Nil(t, err)
+		return id
+	}
+	ids := func(users []user.User) []int64 {
+		var ids []int64
+		for _, u := range users {
+			ids = append(ids, u.ID)
+		}
+		return ids
+	}
+
+	grace := create("Grace", "Hopper", nil)
+	alan := create("Alan", "Turing", &grace)
+	ada := create("Ada", "Lovelace", &grace)
+	edsger := create("Edsger", "Dijkstra", &alan)
+	barbara := create("Barbara", "Liskov", &edsger)
+
+	u, err := r.GetUser(ctx, edsger)
+	assert.Nil(t, err)
+	if assert.NotNil(t, u.ManagerID) {
+		assert.Equal(t, alan, *u.ManagerID)
+	}
+
+	reports, err := r.Reports(ctx, grace, 1, user.MaxOrgChartSize)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{ada, alan}, ids(reports), "direct reports by name")
+	reports, err = r.Reports(ctx, grace, 2, user.MaxOrgChartSize)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{ada, alan, edsger}, ids(reports))
+	reports, err = r.Reports(ctx, grace, user.MaxOrgDepth, user.MaxOrgChartSize)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{ada, alan, edsger, barbara}, ids(reports))
+	reports, err = r.Reports(ctx, barbara, user.MaxOrgDepth, user.MaxOrgChartSize)
+	assert.Nil(t, err)
+	assert.Empty(t, reports)
+
+	everyone, err := r.Reports(ctx, 0, user.MaxOrgDepth, user.MaxOrgChartSize)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{grace, ada, alan, edsger, barbara}, ids(everyone))
+	limited, err := r.Reports(ctx, 0, user.MaxOrgDepth, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{grace, ada}, ids(limited), "the limit keeps the upper levels")
+	chart := user.OrgTree(everyone, 0)
+	if assert.Len(t, chart, 1) {
+		assert.Equal(t, grace, chart[0].ID)
+		if assert.Len(t, chart[0].Reports, 2) {
+			assert.Equal(t, alan, chart[0].Reports[1].ID)
+			assert.Equal(t, edsger, chart[0].Reports[1].Reports[0].ID)
+		}
+	}
+
+	chain, err := r.ManagementChain(ctx, barbara)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{edsger, alan, grace}, ids(chain))
+	chain, err = r.ManagementChain(ctx, grace)
+	assert.Nil(t, err)
+	assert.Empty(t, chain)
+	_, err = r.ManagementChain(ctx, 1000)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+
+	missing := int64(1000)
+	var validationErr *domain.ValidationError
+	for _, managerID := range []*int64{&barbara, &grace, &missing} {
+		err := user.CheckManager(ctx, r, grace, managerID)
+		assert.True(t, errors.As(err, &validationErr), "manager #%d: got %v", *managerID, err)
+	}
+	assert.Nil(t, user.CheckManager(ctx, r, barbara, &ada))
+	assert.Nil(t, user.CheckManager(ctx, r, 0, &barbara))
+
+	// The reports of a deleted manager rise to the top of the organisation,
+	// and their chains stop below the deleted manager.
+	_, err = r.DeleteUser(ctx, alan, 0, origin)
+	assert.Nil(t, err)
+	reports, err = r.Reports(ctx, grace, user.MaxOrgDepth, user.MaxOrgChartSize)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{ada}, ids(reports))
+	everyone, err = r.Reports(ctx, 0, 1, user.MaxOrgChartSize)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{edsger, grace}, ids(everyone))
+	chain, err = r.ManagementChain(ctx, barbara)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{edsger}, ids(chain))
+
+	// A deleted manager still counts towards a cycle, which restoring them
+	// would otherwise bring back.
+	managerIDs, err := r.ManagerIDs(ctx, barbara)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []int64{edsger, alan, grace}, managerIDs)
+	err = user.CheckManager(ctx, r, grace, &barbara)
+	assert.True(t, errors.As(err, &validationErr), "got %v", err)
+
+	// Purging a manager leaves their reports without one.
+	_, err = r.PurgeUsers(ctx, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	u, err = r.GetUser(ctx, edsger)
+	assert.Nil(t, err)
+	assert.Nil(t, u.ManagerID)
+	assert.Equal(t, int64(2), u.Version)
+	events, err := r.UserHistory(ctx, edsger)
+	assert.Nil(t, err)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, audit.Update, events[1].Operation)
+		assert.Equal(t, audit.System.Actor, events[1].Actor)
+		assert.Contains(t, events[1].Changes, "managerId")
+	}
+}
+
+// testLargeOrg lists more reports, with their contacts, than the backends
+// look up in one query.
+func testLargeOrg(t *testing.T, r Repository) {
+	ctx := context.Background()
+	manager, err := r.CreateUser(ctx, user.User{FirstName: "Grace", LastName: "Hopper"}, origin)
+	assert.Nil(t, err)
+	const n = 1200
+	for i := 0; i < n; i++ {
+		_, err := r.CreateUser(ctx, user.User{FirstName: "Report", LastName: fmt.Sprintf("%04d", i), ManagerID: &manager,
+			Emails: []user.Email{{Address: fmt.Sprintf("report%d@example.com", i), Type: user.ContactWork, Primary: true}}}, origin)
+		assert.Nil(t, err)
+	}
+
+	reports, err := r.Reports(ctx, manager, 1, user.MaxOrgChartSize)
+	assert.Nil(t, err)
+	if assert.Len(t, reports, n) {
+		for i, u := range reports {
+			assert.Equal(t, []user.Email{{Address: fmt.Sprintf("report%d@example.com", i), Type: user.ContactWork, Primary: true}}, u.Emails)
+		}
+	}
+}
+
+func testAttributes(t *testing.T, r Repository) {
+	ctx := context.Background()
+	defs, err := r.AttributeDefinitions(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, defs)
+
+	desk := user.AttributeDefinition{Name: "desk", Type: user.AttributeString, Pattern: "[0-9]+[A-Z]"}
+	size := user.AttributeDefinition{Name: "shirtSize", Type: user.AttributeString, Required: true, Enum: []string{"S", "M", "L"}}
+	for _, d := range []user.AttributeDefinition{size, desk} {
+		created, err := r.PutAttributeDefinition(ctx, d)
+		assert.Nil(t, err)
+		assert.True(t, created)
+	}
+	size.Description = "For the company T-shirt"
+	created, err := r.PutAttributeDefinition(ctx, size)
+	assert.Nil(t, err)
+	assert.False(t, created)
+	defs, err = r.AttributeDefinitions(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []user.AttributeDefinition{desk, size}, defs)
+
+	herman, err := r.CreateUser(ctx, user.User{FirstName: "Herman", LastName: "Melville",
+		Attributes: map[string]string{"desk": "4B", "shirtSize": "M"}}, origin)
+	assert.Nil(t, err)
+	haruki, err := r.CreateUser(ctx, user.User{FirstName: "Haruki", LastName: "Murakami",
+		Attributes: map[string]string{"desk": "7A", "shirtSize": "M"}}, origin)
+	assert.Nil(t, err)
+	stanley, err := r.CreateUser(ctx, user.User{FirstName: "Stanley", LastName: "Kubrick"}, origin)
+	assert.Nil(t, err)
+
+	u, err := r.GetUser(ctx, herman)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"desk": "4B", "shirtSize": "M"}, u.Attributes)
+	u, err = r.GetUser(ctx, stanley)
+	assert.Nil(t, err)
+	assert.Nil(t, u.Attributes)
+
+	filtered := func(attributes map[string]string) []int64 {
+		t.Helper()
+		page, err := r.GetAllUsers(ctx, user.ListOptions{Attributes: attributes})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len(page.Users)), page.Total)
+		var ids []int64
+		for _, u := range page.Users {
+			ids = append(ids, u.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []int64{herman}, filtered(map[string]string{"desk": "4B"}))
+	assert.Equal(t, []int64{herman, haruki}, filtered(map[string]string{"shirtSize": "M"}))
+	assert.Equal(t, []int64{haruki}, filtered(map[string]string{"shirtSize": "M", "desk": "7A"}))
+	assert.Empty(t, filtered(map[string]string{"desk": "4b"}), "values match exactly")
+
+	_, err = r.ModifyUser(ctx, haruki, origin, func(u user.User) (user.User, error) {
+		delete(u.Attributes, "desk")
+		return u, nil
+	})
+	assert.Nil(t, err)
+	u, err = r.GetUser(ctx, haruki)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"shirtSize": "M"}, u.Attributes)
+
+	// Deleting a definition takes the attribute from every user, deleted or
+	// not, as an audited change.
+	_, err = r.DeleteUser(ctx, haruki, 0, origin)
+	assert.Nil(t, err)
+	assert.Nil(t, r.DeleteAttributeDefinition(ctx, "shirtSize", origin))
+	defs, err = r.AttributeDefinitions(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []user.AttributeDefinition{desk}, defs)
+	u, err = r.GetUser(ctx, herman)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"desk": "4B"}, u.Attributes)
+	assert.Equal(t, int64(2), u.Version)
+	events, err := r.UserHistory(ctx, herman)
+	assert.Nil(t, err)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, audit.Update, events[1].Operation)
+		assert.Contains(t, events[1].Changes, "attributes")
+	}
+	_, err = r.RestoreUser(ctx, haruki, origin)
+	assert.Nil(t, err)
+	u, err = r.GetUser(ctx, haruki)
+	assert.Nil(t, err)
+	assert.Nil(t, u.Attributes)
+
+	err = r.DeleteAttributeDefinition(ctx, "shirtSize", origin)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+
+	// Purged users take their attributes with them.
+	_, err = r.DeleteUser(ctx, herman, 0, origin)
+	assert.Nil(t, err)
+	_, err = r.PurgeUsers(ctx, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	page, err := r.GetAllUsers(ctx, user.ListOptions{Attributes: map[string]string{"desk": "4B"}, IncludeDeleted: true})
+	assert.Nil(t, err)
+	assert.Empty(t, page.Users)
+}
+
+func testTags(t *testing.T, r Repository) {
+	ctx := context.Background()
+	ids := createUsers(t, r)
+	herman, haruki, stanley := ids[0], ids[1], ids[2]
+
+	for _, tagging := range []struct {
+		id   int64
+		tags []string
+	}{
+		{herman, []string{"remote", "oncall"}},
+		{haruki, []string{"remote"}},
+		{stanley, []string{"contractor", "oncall"}},
+	} {
+		for _, tag := range tagging.tags {
+			assert.Nil(t, r.TagUser(ctx, tagging.id, tag))
+		}
+	}
+	assert.Nil(t, r.TagUser(ctx, herman, "remote"), "tagging twice changes nothing")
+	assert.True(t, errors.Is(r.TagUser(ctx, 1000, "remote"), domain.ErrNotFound))
+
+	tags, err := r.UserTags(ctx, herman)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"oncall", "remote"}, tags)
+	_, err = r.UserTags(ctx, 1000)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+
+	counts, err := r.Tags(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []user.TagCount{{Name: "contractor", Count: 1}, {Name: "oncall", Count: 2}, {Name: "remote", Count: 2}}, counts)
+
+	tagged := func(anyTag bool, tags ...string) []int64 {
+		t.Helper()
+		page, err := r.GetAllUsers(ctx, user.ListOptions{Tags: tags, AnyTag: anyTag})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len(page.Users)), page.Total)
+		var ids []int64
+		for _, u := range page.Users {
+			ids = append(ids, u.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []int64{herman, haruki}, tagged(false, "remote"))
+	assert.Equal(t, []int64{herman}, tagged(false, "remote", "oncall"))
+	assert.Equal(t, []int64{herman, haruki, stanley}, tagged(true, "remote", "oncall"))
+	assert.Equal(t, []int64{stanley}, tagged(true, "contractor", "intern"))
+	assert.Empty(t, tagged(false, "contractor", "intern"))
+
+	assert.Nil(t, r.UntagUser(ctx, stanley, "contractor"))
+	assert.True(t, errors.Is(r.UntagUser(ctx, stanley, "contractor"), domain.ErrNotFound))
+	counts, err = r.Tags(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []user.TagCount{{Name: "oncall", Count: 2}, {Name: "remote", Count: 2}}, counts)
+
+	// Deleted users keep their tags until purged, but are not counted.
+	_, err = r.DeleteUser(ctx, haruki, 0, origin)
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(r.TagUser(ctx, haruki, "remote"), domain.ErrNotFound))
+	counts, err = r.Tags(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []user.TagCount{{Name: "oncall", Count: 2}, {Name: "remote", Count: 1}}, counts)
+	_, err = r.RestoreUser(ctx, haruki, origin)
+	assert.Nil(t, err)
+	tags, err = r.UserTags(ctx, haruki)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"remote"}, tags)
+
+	_, err = r.DeleteUser(ctx, herman, 0, origin)
+	assert.Nil(t, err)
+	_, err = r.PurgeUsers(ctx, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	counts, err = r.Tags(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []user.TagCount{{Name: "oncall", Count: 1}, {Name: "remote", Count: 1}}, counts)
+}
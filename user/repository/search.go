@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pmaterer/peopler/internal/dialect"
+	"github.com/pmaterer/peopler/user"
+)
+
+// SearchUsers returns up to limit users whose names contain words starting
+// with every term, best matches first. The page's Total counts every match.
+// Terms must come from user.SearchTerms. Deleted users are never found.
+func (r *Reopository) SearchUsers(ctx context.Context, terms []string, limit int) (user.Page, error) {
+	var match, count, query string
+	if r.dialect == dialect.Postgres {
+		// e.g. mur:* & hal:*
+		prefixes := make([]string, len(terms))
+		for i, term := range terms {
+			prefixes[i] = term + `:*`
+		}
+		match = strings.Join(prefixes, ` & `)
+		count = `SELECT COUNT(*) FROM users WHERE search @@ to_tsquery('simple', unaccent(?)) AND deleted_at IS NULL`
+		query = `SELECT ` + userColumns + ` FROM users
+			WHERE search @@ to_tsquery('simple', unaccent(?)) AND deleted_at IS NULL
+			ORDER BY ts_rank(search, to_tsquery('simple', unaccent(?))) DESC, id
+			LIMIT ?`
+	} else {
+		// e.g. "mur"* "hal"*, where juxtaposition means AND.
+		prefixes := make([]string, len(terms))
+		for i, term := range terms {
+			prefixes[i] = `"` + term + `"*`
+		}
+		match = strings.Join(prefixes, ` `)
+		count = `SELECT COUNT(*) FROM users_fts
+			JOIN users ON users.id = users_fts.rowid
+			WHERE users_fts MATCH ? AND users.deleted_at IS NULL`
+		query = `SELECT ` + userColumns + ` FROM users_fts
+			JOIN users ON users.id = users_fts.rowid
+			WHERE users_fts MATCH ? AND users.deleted_at IS NULL
+			ORDER BY bm25(users_fts), users.id
+			LIMIT ?`
+	}
+
+	var page user.Page
+	err := r.conn.QueryRowContext(ctx, r.dialect.Rebind(count), match).Scan(&page.Total)
+	if err != nil {
+		return page, err
+	}
+
+	args := []interface{}{match, limit}
+	if r.dialect == dialect.Postgres {
+		args = []interface{}{match, match, limit}
+	}
+	rows, err := r.conn.QueryContext(ctx, r.dialect.Rebind(query), args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return page, err
+		}
+		page.Users = append(page.Users, user)
+	}
+	err = rows.Err()
+	if err != nil {
+		return page, err
+	}
+	rows.Close()
+	return page, r.loadContacts(ctx, page.Users)
+}
@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/user"
+)
+
+func (r *Reopository) TagUser(ctx context.Context, id int64, tag string) error {
+	return r.inTx(ctx, func(tx *Reopository) error {
+		if _, err := tx.GetUser(ctx, id); err != nil {
+			return err
+		}
+		query := tx.dialect.Rebind(`INSERT INTO tags(name) VALUES (?) ON CONFLICT DO NOTHING`)
+		if _, err := tx.conn.ExecContext(ctx, query, tag); err != nil {
+			return err
+		}
+		query = tx.dialect.Rebind(`INSERT INTO user_tags(user_id, tag_id) SELECT ?, id FROM tags WHERE name = ? ON CONFLICT DO NOTHING`)
+		_, err := tx.conn.ExecContext(ctx, query, id, tag)
+		return err
+	})
+}
+
+func (r *Reopository) UntagUser(ctx context.Context, id int64, tag string) error {
+	return r.inTx(ctx, func(tx *Reopository) error {
+		if _, err := tx.GetUser(ctx, id); err != nil {
+			return err
+		}
+		query := tx.dialect.Rebind(`DELETE FROM user_tags WHERE user_id = ? AND tag_id IN (SELECT id FROM tags WHERE name = ?)`)
+		result, err := tx.conn.ExecContext(ctx, query, id, tag)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("user #%d is not tagged %q: %w", id, tag, domain.ErrNotFound)
+		}
+		return tx.deleteUnusedTags(ctx)
+	})
+}
+
+func (r *Reopository) UserTags(ctx context.Context, id int64) ([]string, error) {
+	var tags []string
+	err := r.inTx(ctx, func(tx *Reopository) error {
+		if _, err := tx.GetUser(ctx, id); err != nil {
+			return err
+		}
+		query := tx.dialect.Rebind(`SELECT tags.name FROM tags JOIN user_tags ON user_tags.tag_id = tags.id
+			WHERE user_tags.user_id = ? ORDER BY tags.name`)
+		rows, err := tx.conn.QueryContext(ctx, query, id)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		tags = []string{}
+		for rows.Next() {
+			var tag string
+			if err := rows.Scan(&tag); err != nil {
+				return err
+			}
+			tags = append(tags, tag)
+		}
+		return rows.Err()
+	})
+	return tags, err
+}
+
+func (r *Reopository) Tags(ctx context.Context) ([]user.TagCount, error) {
+	rows, err := r.conn.QueryContext(ctx, `SELECT tags.name, COUNT(*) FROM tags
+		JOIN user_tags ON user_tags.tag_id = tags.id
+		JOIN users ON users.id = user_tags.user_id
+		WHERE users.deleted_at IS NULL
+		GROUP BY tags.name ORDER BY tags.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []user.TagCount{}
+	for rows.Next() {
+		var t user.TagCount
+		if err := rows.Scan(&t.Name, &t.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// deleteUnusedTags removes the tags nobody has any more, within r's
+// transaction.
+func (r *Reopository) deleteUnusedTags(ctx context.Context) error {
+	_, err := r.conn.ExecContext(ctx, `DELETE FROM tags WHERE NOT EXISTS (SELECT 1 FROM user_tags WHERE user_tags.tag_id = tags.id)`)
+	return err
+}
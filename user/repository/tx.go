@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pmaterer/peopler/user"
+)
+
+const (
+	// maxTxAttempts bounds how often WithTx runs a transaction that keeps
+	// failing with a retryable error.
+	maxTxAttempts = 5
+	// txRetryDelay is the wait before the first retry; it doubles after each.
+	txRetryDelay = 10 * time.Millisecond
+)
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// WithTx runs fn against a repository bound to a new transaction, committing
+// if fn succeeds and rolling back otherwise. Transactions failing because
+// the database is busy are retried from the start with exponential backoff.
+func (r *Reopository) WithTx(ctx context.Context, fn func(user.Repository) error) error {
+	return r.inTx(ctx, func(tx *Reopository) error {
+		return fn(tx)
+	})
+}
+
+// inTx is WithTx for the repository's own multi-statement operations. On a
+// repository that is already bound to a transaction it just runs fn, so
+// operations compose into the caller's unit of work.
+func (r *Reopository) inTx(ctx context.Context, fn func(tx *Reopository) error) error {
+	if r.tx != nil {
+		return fn(r)
+	}
+
+	delay := txRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := r.runTx(ctx, fn)
+		if err == nil || r.retryable == nil || !r.retryable(err) || attempt == maxTxAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (r *Reopository) runTx(ctx context.Context, fn func(tx *Reopository) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	scoped := *r
+	scoped.conn, scoped.tx = tx, tx
+	if err := fn(&scoped); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
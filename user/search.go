@@ -0,0 +1,30 @@
+package user
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+const DefaultSearchLimit = 20
+
+// SearchTerms splits a search query into lower case words stripped of
+// diacritics, so "Renée  MUR" becomes ["renee", "mur"]. Anything other than
+// letters and digits separates words, which keeps the terms safe to embed in
+// full-text query syntax.
+func SearchTerms(q string) []string {
+	folded, _, err := transform.String(foldDiacritics(), q)
+	if err != nil {
+		folded = q
+	}
+	return strings.FieldsFunc(strings.ToLower(folded), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func foldDiacritics() transform.Transformer {
+	return transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+}
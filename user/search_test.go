@@ -0,0 +1,26 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchTerms(t *testing.T) {
+	tests := []struct {
+		query string
+		terms []string
+	}{
+		{"Mur", []string{"mur"}},
+		{"  Renée  MURAKAMI ", []string{"renee", "murakami"}},
+		{"Zoë O'Brien-Núñez", []string{"zoe", "o", "brien", "nunez"}},
+		{`"mur"* OR NEAR(a b)`, []string{"mur", "or", "near", "a", "b"}},
+		{"   ", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			assert.Equal(t, tt.terms, SearchTerms(tt.query))
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/user"
+)
+
+func (s *Service) GetAttributeDefinitions(ctx context.Context) ([]user.AttributeDefinition, error) {
+	return s.repository.AttributeDefinitions(ctx)
+}
+
+func (s *Service) GetAttributeDefinition(ctx context.Context, name string) (user.AttributeDefinition, error) {
+	defs, err := s.repository.AttributeDefinitions(ctx)
+	if err != nil {
+		return user.AttributeDefinition{}, err
+	}
+	for _, d := range defs {
+		if d.Name == name {
+			return d, nil
+		}
+	}
+	return user.AttributeDefinition{}, fmt.Errorf("attribute %q: %w", name, domain.ErrNotFound)
+}
+
+// PutAttributeDefinition creates or replaces the definition and returns it
+// as stored, reporting whether it was created. Users keep the values they
+// have; they are checked against the new definition the next time the user
+// is written.
+func (s *Service) PutAttributeDefinition(ctx context.Context, d user.AttributeDefinition) (user.AttributeDefinition, bool, error) {
+	d.Normalize()
+	if err := d.Validate(); err != nil {
+		return d, false, err
+	}
+	created, err := s.repository.PutAttributeDefinition(ctx, d)
+	if err != nil {
+		return d, false, err
+	}
+	if created {
+		log.Printf("Defined attribute %q\n", d.Name)
+	} else {
+		log.Printf("Redefined attribute %q\n", d.Name)
+	}
+	return d, created, nil
+}
+
+// DeleteAttributeDefinition removes the definition along with the values
+// users have for it.
+func (s *Service) DeleteAttributeDefinition(ctx context.Context, name string, origin audit.Origin) error {
+	if err := s.repository.DeleteAttributeDefinition(ctx, name, origin); err != nil {
+		return err
+	}
+	log.Printf("Deleted attribute %q\n", name)
+	return nil
+}
+
+// checkAttributes checks the attributes of u against the definitions repo
+// holds, putting their values in canonical form.
+func checkAttributes(ctx context.Context, repo user.Repository, u *user.User) error {
+	defs, err := repo.AttributeDefinitions(ctx)
+	if err != nil {
+		return err
+	}
+	return u.CheckAttributes(defs)
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"context"
+
+	"github.com/pmaterer/peopler/audit"
+)
+
+// GetUserHistory returns the audit events of a user, oldest first. The
+// history of a deleted or purged user is still available.
+func (s *Service) GetUserHistory(ctx context.Context, id int64) ([]audit.Event, error) {
+	events, err := s.repository.UserHistory(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		// Only users that predate the audit log have no events.
+		if _, err := s.repository.GetUser(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+func (s *Service) GetAuditEvents(ctx context.Context, q audit.Query) (audit.Page, error) {
+	page, err := s.repository.AuditEvents(ctx, q)
+	if err != nil {
+		return page, err
+	}
+	return page, nil
+}
@@ -0,0 +1,232 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/user"
+)
+
+// errDryRun rolls back a dry run once its results are known.
+var errDryRun = errors.New("dry run")
+
+// ImportUsers upserts up to user.MaxImportSize users by external ID, as a
+// batch of user.BatchUpsert operations, so imported users obey the same
+// rules as the API. Users without an external ID are always created.
+//
+// If fields is set, the users only hold those fields, named as for
+// user.BatchOperation.Fields, and the users found by external ID keep the
+// rest.
+//
+// A dry run does everything in a transaction that is then rolled back, so
+// its results show exactly what a real import would do. Users it would
+// create have no ID yet.
+func (s *Service) ImportUsers(ctx context.Context, users []user.User, fields []string, atomic, dryRun bool, origin audit.Origin) ([]user.BatchResult, error) {
+	if len(users) == 0 || len(users) > user.MaxImportSize {
+		return nil, &domain.ValidationError{Fields: []domain.FieldError{
+			{Field: "rows", Message: fmt.Sprintf("must contain between 1 and %d users", user.MaxImportSize)},
+		}}
+	}
+	ops := make([]user.BatchOperation, len(users))
+	for i, u := range users {
+		ops[i] = user.BatchOperation{Op: user.BatchUpsert, User: u, Fields: fields}
+	}
+
+	var results []user.BatchResult
+	err := s.repository.WithTx(ctx, func(tx user.Repository) error {
+		defs, err := tx.AttributeDefinitions(ctx)
+		if err != nil {
+			return err
+		}
+		results, err = applyBatch(ctx, tx, ops, defs, atomic, origin)
+		if err == nil && dryRun {
+			return errDryRun
+		}
+		return err
+	})
+	if err != nil && !(dryRun && errors.Is(err, errDryRun)) {
+		return nil, err
+	}
+
+	if dryRun {
+		for i := range results {
+			if results[i].Created {
+				results[i].ID, results[i].Version = 0, 0
+			}
+		}
+		return results, nil
+	}
+	var summary user.ImportSummary
+	for _, result := range results {
+		summary.Add(result)
+	}
+	if atomic && summary.Failed > 0 {
+		// The import was rolled back, so nothing was imported.
+		return results, nil
+	}
+	log.Printf("Imported users: %d created, %d updated\n", summary.Created, summary.Updated)
+	return results, nil
+}
+
+// importChunkSize is how many users a non-atomic streamed import reads
+// before writing them in a transaction of their own.
+const importChunkSize = 500
+
+// StreamImportUsers upserts the users next returns, like ImportUsers. next
+// returns io.EOF after the last user. An error from next that is one of the
+// domain errors fails just that record; any other error ends the import.
+// failed is called for every record that fails, with what next returned
+// for it.
+//
+// No transaction is open while next reads, so a slow client cannot hold up
+// other writers. A non-atomic import is written a chunk of users at a time,
+// so memory use does not grow with the import; a dry run rolls back every
+// chunk, so a user is only found by the chunks after the one creating it
+// once the import is real. An atomic import is read and checked in full,
+// stopping at its first failure, and then written in one transaction.
+func (s *Service) StreamImportUsers(ctx context.Context, next func() (user.ImportRecord, error), atomic, dryRun bool, origin audit.Origin, failed func(user.ImportRecord, user.BatchResult)) (user.ImportSummary, error) {
+	var summary user.ImportSummary
+	var err error
+	if atomic {
+		summary, err = s.importAtomically(ctx, next, dryRun, origin, failed)
+	} else {
+		summary, err = s.importInChunks(ctx, next, dryRun, origin, failed)
+	}
+	if err != nil || summary.Aborted || dryRun {
+		return summary, err
+	}
+	log.Printf("Imported users: %d created, %d updated\n", summary.Created, summary.Updated)
+	return summary, nil
+}
+
+// importInChunks applies the records next returns a chunk at a time.
+func (s *Service) importInChunks(ctx context.Context, next func() (user.ImportRecord, error), dryRun bool, origin audit.Origin, failed func(user.ImportRecord, user.BatchResult)) (user.ImportSummary, error) {
+	var summary user.ImportSummary
+	for {
+		var records []user.ImportRecord
+		var results []user.BatchResult
+		var ops []user.BatchOperation
+		var indexes []int
+		eof := false
+		for len(records) < importChunkSize {
+			record, err := next()
+			if errors.Is(err, io.EOF) {
+				eof = true
+				break
+			}
+			if err != nil && !domain.IsKnown(err) {
+				return summary, err
+			}
+			records = append(records, record)
+			results = append(results, user.BatchResult{Err: err})
+			if err == nil {
+				ops = append(ops, user.BatchOperation{Op: user.BatchUpsert, User: record.User})
+				indexes = append(indexes, len(records)-1)
+			}
+		}
+
+		if len(ops) > 0 {
+			applied, err := s.importBatch(ctx, ops, false, dryRun, origin)
+			if err != nil {
+				return summary, err
+			}
+			for j, result := range applied {
+				results[indexes[j]] = result
+			}
+		}
+		for i, result := range results {
+			summary.Add(result)
+			if result.Err != nil {
+				failed(records[i], result)
+			}
+		}
+		if eof {
+			return summary, nil
+		}
+	}
+}
+
+// importAtomically reads and checks every record next returns before
+// applying them all in one transaction. It stops at the first failure.
+func (s *Service) importAtomically(ctx context.Context, next func() (user.ImportRecord, error), dryRun bool, origin audit.Origin, failed func(user.ImportRecord, user.BatchResult)) (user.ImportSummary, error) {
+	abort := func(record user.ImportRecord, err error) (user.ImportSummary, error) {
+		failed(record, user.BatchResult{Err: err})
+		return user.ImportSummary{Failed: 1, Aborted: true}, nil
+	}
+	defs, err := s.repository.AttributeDefinitions(ctx)
+	if err != nil {
+		return user.ImportSummary{}, err
+	}
+	var records []user.ImportRecord
+	var ops []user.BatchOperation
+	for {
+		record, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if !domain.IsKnown(err) {
+				return user.ImportSummary{}, err
+			}
+			return abort(record, err)
+		}
+		if len(ops) == user.MaxAtomicImportSize {
+			return user.ImportSummary{}, &domain.ValidationError{Fields: []domain.FieldError{
+				{Field: "lines", Message: fmt.Sprintf("must hold at most %d users in an atomic import; send more with atomic=false", user.MaxAtomicImportSize)},
+			}}
+		}
+		op := user.BatchOperation{Op: user.BatchUpsert, User: record.User}
+		op.Normalize()
+		err = op.Validate()
+		if err == nil {
+			err = op.User.CheckAttributes(defs)
+		}
+		if err != nil {
+			return abort(record, err)
+		}
+		records = append(records, record)
+		ops = append(ops, op)
+	}
+	if len(ops) == 0 {
+		return user.ImportSummary{}, nil
+	}
+
+	results, err := s.importBatch(ctx, ops, true, dryRun, origin)
+	if err != nil {
+		return user.ImportSummary{}, err
+	}
+	var summary user.ImportSummary
+	for i, result := range results {
+		if result.Err != nil && !errors.Is(result.Err, domain.ErrNotApplied) {
+			return abort(records[i], result.Err)
+		}
+		summary.Add(result)
+	}
+	return summary, nil
+}
+
+// importBatch applies ops in a transaction of their own, which a dry run
+// rolls back.
+func (s *Service) importBatch(ctx context.Context, ops []user.BatchOperation, atomic, dryRun bool, origin audit.Origin) ([]user.BatchResult, error) {
+	var results []user.BatchResult
+	err := s.repository.WithTx(ctx, func(tx user.Repository) error {
+		defs, err := tx.AttributeDefinitions(ctx)
+		if err != nil {
+			return err
+		}
+		results, err = applyBatch(ctx, tx, ops, defs, atomic, origin)
+		if err == nil && dryRun {
+			return errDryRun
+		}
+		return err
+	})
+	if err != nil && !(dryRun && errors.Is(err, errDryRun)) {
+		return nil, err
+	}
+	return results, nil
+}
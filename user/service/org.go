@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/user"
+)
+
+// GetReports returns the users managed by the user, as trees depth levels
+// deep at most.
+func (s *Service) GetReports(ctx context.Context, id int64, depth int) ([]user.OrgNode, error) {
+	if err := checkDepth(depth); err != nil {
+		return nil, err
+	}
+	var reports []user.User
+	err := s.repository.WithTx(ctx, func(repo user.Repository) error {
+		if _, err := repo.GetUser(ctx, id); err != nil {
+			return err
+		}
+		var err error
+		reports, err = repo.Reports(ctx, id, depth, user.MaxOrgChartSize+1)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := checkChartSize(reports); err != nil {
+		return nil, err
+	}
+	return user.OrgTree(reports, id), nil
+}
+
+// GetManagementChain returns the user's managers, from their direct manager
+// up to the top of the organisation.
+func (s *Service) GetManagementChain(ctx context.Context, id int64) ([]user.User, error) {
+	return s.repository.ManagementChain(ctx, id)
+}
+
+// GetOrgChart returns the whole organisation as trees, depth levels deep at
+// most, rooted at the users without a manager.
+func (s *Service) GetOrgChart(ctx context.Context, depth int) ([]user.OrgNode, error) {
+	if err := checkDepth(depth); err != nil {
+		return nil, err
+	}
+	users, err := s.repository.Reports(ctx, 0, depth, user.MaxOrgChartSize+1)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkChartSize(users); err != nil {
+		return nil, err
+	}
+	return user.OrgTree(users, 0), nil
+}
+
+// checkChartSize rejects a chart of more than user.MaxOrgChartSize users,
+// which the caller can narrow down with a smaller depth or by asking for the
+// reports of a manager further down.
+func checkChartSize(users []user.User) error {
+	if len(users) > user.MaxOrgChartSize {
+		return &domain.ValidationError{Fields: []domain.FieldError{
+			{Field: "depth", Message: fmt.Sprintf("must be smaller: more than %d users are that many levels deep", user.MaxOrgChartSize)},
+		}}
+	}
+	return nil
+}
+
+func checkDepth(depth int) error {
+	if depth < 1 || depth > user.MaxOrgDepth {
+		return &domain.ValidationError{Fields: []domain.FieldError{
+			{Field: "depth", Message: fmt.Sprintf("must be between 1 and %d", user.MaxOrgDepth)},
+		}}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/user"
+)
+
+// applyPatch applies an RFC 7396 merge patch or an RFC 6902 JSON patch to
+// the JSON form of u. A failed JSON patch test operation is a conflict; any
+// other problem with the patch is a validation error.
+func applyPatch(u user.User, patchType string, patch []byte) (user.User, error) {
+	doc, err := json.Marshal(u)
+	if err != nil {
+		return u, err
+	}
+
+	var patched []byte
+	switch patchType {
+	case user.MergePatchType:
+		patched, err = jsonpatch.MergePatch(doc, patch)
+	case user.JSONPatchType:
+		var ops jsonpatch.Patch
+		ops, err = jsonpatch.DecodePatch(patch)
+		if err == nil {
+			patched, err = ops.Apply(doc)
+		}
+	default:
+		return u, invalidPatch(fmt.Sprintf("unsupported patch type %q", patchType))
+	}
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		return u, fmt.Errorf("patch: %v: %w", err, domain.ErrConflict)
+	}
+	if err != nil {
+		return u, invalidPatch(err.Error())
+	}
+
+	var result user.User
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&result); err != nil {
+		return u, invalidPatch(err.Error())
+	}
+	if result.ID != u.ID {
+		return u, &domain.ValidationError{Fields: []domain.FieldError{
+			{Field: "id", Message: "cannot be changed"},
+		}}
+	}
+	return result, nil
+}
+
+func invalidPatch(message string) error {
+	return &domain.ValidationError{Fields: []domain.FieldError{
+		{Field: "patch", Message: message},
+	}}
+}
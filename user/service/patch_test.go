@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatchUser(t *testing.T) {
+	tests := []struct {
+		name      string
+		patchType string
+		patch     string
+		expected  user.User
+		err       error
+	}{
+		{
+			name:      "Merge patch one field",
+			patchType: user.MergePatchType,
+			patch:     `{"lastName":" Kingsley "}`,
+			expected:  user.User{ID: 1, FirstName: "Stephen", LastName: "Kingsley", Version: 2},
+		},
+		{
+			name:      "Merge patch removing a required field",
+			patchType: user.MergePatchType,
+			patch:     `{"lastName":null}`,
+			err:       domain.ErrValidation,
+		},
+		{
+			name:      "Merge patch unknown field",
+			patchType: user.MergePatchType,
+			patch:     `{"nickname":"Steve"}`,
+			err:       domain.ErrValidation,
+		},
+		{
+			name:      "Merge patch changing the ID",
+			patchType: user.MergePatchType,
+			patch:     `{"id":2}`,
+			err:       domain.ErrValidation,
+		},
+		{
+			name:      "JSON patch",
+			patchType: user.JSONPatchType,
+			patch:     `[{"op":"test","path":"/lastName","value":"King"},{"op":"replace","path":"/firstName","value":"Steve"}]`,
+			expected:  user.User{ID: 1, FirstName: "Steve", LastName: "King", Version: 2},
+		},
+		{
+			name:      "JSON patch failed test",
+			patchType: user.JSONPatchType,
+			patch:     `[{"op":"test","path":"/lastName","value":"Queen"},{"op":"replace","path":"/firstName","value":"Steve"}]`,
+			err:       domain.ErrConflict,
+		},
+		{
+			name:      "JSON patch missing path",
+			patchType: user.JSONPatchType,
+			patch:     `[{"op":"replace","path":"/middleName","value":"Edwin"}]`,
+			err:       domain.ErrValidation,
+		},
+		{
+			name:      "JSON patch not a patch",
+			patchType: user.JSONPatchType,
+			patch:     `{"lastName":"Kingsley"}`,
+			err:       domain.ErrValidation,
+		},
+		{
+			name:      "Unsupported patch type",
+			patchType: "application/json",
+			patch:     `{"lastName":"Kingsley"}`,
+			err:       domain.ErrValidation,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepository(t)
+			s := NewService(r)
+
+			patched, err := s.PatchUser(context.Background(), testUser.ID, 0, tt.patchType, []byte(tt.patch), testOrigin)
+			stored, getErr := r.GetUser(context.Background(), testUser.ID)
+			assert.Nil(t, getErr)
+
+			if tt.err != nil {
+				assert.True(t, errors.Is(err, tt.err), "unexpected error %v", err)
+				unchanged := testUser
+				unchanged.Version = 1
+				assert.Equal(t, unchanged, stored)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, patched)
+			assert.Equal(t, tt.expected, stored)
+		})
+	}
+}
+
+func TestPatchMissingUser(t *testing.T) {
+	s := NewService(newTestRepository(t))
+
+	_, err := s.PatchUser(context.Background(), 99, 0, user.MergePatchType, []byte(`{"lastName":"Nobody"}`), testOrigin)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+}
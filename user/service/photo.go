@@ -0,0 +1,78 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/pmaterer/peopler/user"
+)
+
+// PutUserPhoto sets the user's photo, replacing any they had, and stores
+// its thumbnails along with it.
+func (s *Service) PutUserPhoto(ctx context.Context, id int64, data []byte) error {
+	if _, err := s.repository.GetUser(ctx, id); err != nil {
+		return err
+	}
+	renditions, err := user.RenderPhoto(data)
+	if err != nil {
+		return err
+	}
+	// The original goes last, so a photo is only served once its
+	// thumbnails are in place.
+	for i := len(user.PhotoSizes) - 1; i >= 0; i-- {
+		size := user.PhotoSizes[i]
+		if err := s.Photos.Put(ctx, photoKey(id, size), bytes.NewReader(renditions[size])); err != nil {
+			return err
+		}
+	}
+	log.Printf("Updated photo of user #%d\n", id)
+	return nil
+}
+
+// GetUserPhoto returns the user's photo in the given size, encoded as a
+// JPEG or PNG image.
+func (s *Service) GetUserPhoto(ctx context.Context, id int64, size user.PhotoSize) ([]byte, error) {
+	if _, err := s.repository.GetUser(ctx, id); err != nil {
+		return nil, err
+	}
+	rc, err := s.Photos.Get(ctx, photoKey(id, size))
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, fmt.Errorf("user #%d has no photo: %w", id, domain.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+func (s *Service) DeleteUserPhoto(ctx context.Context, id int64) error {
+	if _, err := s.GetUserPhoto(ctx, id, user.PhotoOriginal); err != nil {
+		return err
+	}
+	if err := s.deletePhoto(ctx, id); err != nil {
+		return err
+	}
+	log.Printf("Deleted photo of user #%d\n", id)
+	return nil
+}
+
+// deletePhoto removes every size of the user's photo, if they have one.
+func (s *Service) deletePhoto(ctx context.Context, id int64) error {
+	for _, size := range user.PhotoSizes {
+		if err := s.Photos.Delete(ctx, photoKey(id, size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// photoKey returns the blob key of the user's photo in the given size.
+func photoKey(id int64, size user.PhotoSize) string {
+	return fmt.Sprintf("users/%d/photo/%s", id, size)
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// PurgeDeletedUsers permanently removes the users deleted more than
+// retention ago, along with their photos, and returns how many there were.
+func (s *Service) PurgeDeletedUsers(ctx context.Context, retention time.Duration) (int64, error) {
+	purged, err := s.repository.PurgeUsers(ctx, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range purged {
+		// The users are gone whatever happens to their photos, so a photo
+		// that cannot be deleted is left behind rather than failing the
+		// purge.
+		if err := s.deletePhoto(ctx, id); err != nil {
+			log.Printf("Deleting the photo of purged user #%d failed: %v\n", id, err)
+		}
+	}
+	if len(purged) > 0 {
+		log.Printf("Purged %d deleted user(s)\n", len(purged))
+	}
+	return int64(len(purged)), nil
+}
+
+// RunPurge calls PurgeDeletedUsers every interval until ctx is done. Failures
+// are logged and retried at the next interval.
+func (s *Service) RunPurge(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.PurgeDeletedUsers(ctx, retention); err != nil {
+			log.Printf("Purging deleted users failed: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
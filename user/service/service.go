@@ -1,68 +1,308 @@
 package service
 
 import (
+	"context"
+	"fmt"
 	"log"
 
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/blob"
+	"github.com/pmaterer/peopler/domain"
 	"github.com/pmaterer/peopler/user"
 )
 
-type repository interface {
-	CreateUser(u user.User) (int64, error)
-	GetAllUsers() ([]user.User, error)
-	GetUser(id int64) (user.User, error)
-	UpdateUser(u user.User) (int64, error)
-	DeleteUser(id int64) (int64, error)
-}
-
 type Service struct {
-	repository repository
+	repository user.Repository
+	// Photos holds the users' profile photos. It starts out as an empty
+	// blob.MemoryStore.
+	Photos blob.Store
 }
 
-func NewService(r repository) *Service {
+func NewService(r user.Repository) *Service {
 	return &Service{
 		repository: r,
+		Photos:     blob.NewMemoryStore(),
 	}
 }
 
-func (s *Service) CreateUser(u user.User) error {
-	id, err := s.repository.CreateUser(u)
-	if err != nil {
+// CreateUser stores a new user and returns it as persisted, including its
+// assigned ID, read back in the same transaction. Every change is audited
+// as made by origin.
+func (s *Service) CreateUser(ctx context.Context, u user.User, origin audit.Origin) (user.User, error) {
+	u.Normalize()
+	if err := u.Validate(); err != nil {
+		return user.User{}, err
+	}
+	var created user.User
+	err := s.repository.WithTx(ctx, func(repo user.Repository) error {
+		if err := checkAttributes(ctx, repo, &u); err != nil {
+			return err
+		}
+		if err := user.CheckManager(ctx, repo, 0, u.ManagerID); err != nil {
+			return err
+		}
+		id, err := repo.CreateUser(ctx, u, origin)
+		if err != nil {
+			return err
+		}
+		created, err = repo.GetUser(ctx, id)
 		return err
+	})
+	if err != nil {
+		return user.User{}, err
 	}
-	log.Printf("Created new user #%d\n", id)
-	return nil
+	log.Printf("Created new user #%d\n", created.ID)
+	return created, nil
 }
 
-func (s *Service) GetUser(id int64) (user.User, error) {
-	user, err := s.repository.GetUser(id)
+func (s *Service) GetUser(ctx context.Context, id int64) (user.User, error) {
+	user, err := s.repository.GetUser(ctx, id)
 	if err != nil {
 		return user, err
 	}
 	return user, nil
 }
 
-func (s *Service) GetAllUsers() ([]user.User, error) {
-	users, err := s.repository.GetAllUsers()
+// GetAllUsers returns one page of the users matching opts. An attribute
+// filter must name defined attributes and values they could have.
+func (s *Service) GetAllUsers(ctx context.Context, opts user.ListOptions) (user.Page, error) {
+	opts, err := s.checkListOptions(ctx, opts)
+	if err != nil {
+		return user.Page{}, err
+	}
+	page, err := s.repository.GetAllUsers(ctx, opts)
 	if err != nil {
-		return users, err
+		return page, err
 	}
-	return users, nil
+	return page, nil
 }
 
-func (s *Service) UpdateUser(u user.User) error {
-	id, err := s.repository.UpdateUser(u)
+// StreamUsers calls fn with every user matching opts without loading them
+// all first. It is meant for exports of any size.
+func (s *Service) StreamUsers(ctx context.Context, opts user.ListOptions, fn func(user.User) error) error {
+	opts, err := s.checkListOptions(ctx, opts)
 	if err != nil {
 		return err
 	}
-	log.Printf("Updated user #%d\n", id)
-	return nil
+	return s.repository.StreamUsers(ctx, opts, fn)
+}
+
+// SearchUsers finds users whose names contain words starting with every word
+// of q, ignoring case and diacritics, best matches first.
+func (s *Service) SearchUsers(ctx context.Context, q string, limit int) (user.Page, error) {
+	terms := user.SearchTerms(q)
+	if len(terms) == 0 {
+		return user.Page{}, &domain.ValidationError{Fields: []domain.FieldError{
+			{Field: "q", Message: "must contain at least one letter or digit"},
+		}}
+	}
+	if limit <= 0 {
+		limit = user.DefaultSearchLimit
+	}
+	if limit > user.MaxListLimit {
+		limit = user.MaxListLimit
+	}
+
+	page, err := s.repository.SearchUsers(ctx, terms, limit)
+	if err != nil {
+		return page, err
+	}
+	return page, nil
+}
+
+// UpdateUser replaces the user and returns it as persisted, read back in the
+// same transaction. A non-zero u.Version makes the update conditional on the
+// user still being at that version.
+func (s *Service) UpdateUser(ctx context.Context, u user.User, origin audit.Origin) (user.User, error) {
+	u.Normalize()
+	if err := u.Validate(); err != nil {
+		return user.User{}, err
+	}
+	var updated user.User
+	err := s.repository.WithTx(ctx, func(repo user.Repository) error {
+		if err := checkAttributes(ctx, repo, &u); err != nil {
+			return err
+		}
+		current, err := repo.GetUser(ctx, u.ID)
+		if err != nil {
+			return err
+		}
+		if !user.SameManager(current.ManagerID, u.ManagerID) {
+			if err := user.CheckManager(ctx, repo, u.ID, u.ManagerID); err != nil {
+				return err
+			}
+		}
+		if _, err := repo.UpdateUser(ctx, u, origin); err != nil {
+			return err
+		}
+		updated, err = repo.GetUser(ctx, u.ID)
+		return err
+	})
+	if err != nil {
+		return user.User{}, err
+	}
+	log.Printf("Updated user #%d\n", u.ID)
+	return updated, nil
 }
 
-func (s *Service) DeleteUser(id int64) error {
-	id, err := s.repository.DeleteUser(id)
+// PatchUser applies a merge patch or JSON patch, identified by its media
+// type, to the stored user. Reading, patching, validating and writing happen
+// in one transaction, so concurrent patches cannot interleave. A non-zero
+// version makes the patch conditional, as for UpdateUser.
+func (s *Service) PatchUser(ctx context.Context, id, version int64, patchType string, patch []byte, origin audit.Origin) (user.User, error) {
+	var patched user.User
+	var manager *int64
+	err := s.repository.WithTx(ctx, func(repo user.Repository) error {
+		defs, err := repo.AttributeDefinitions(ctx)
+		if err != nil {
+			return err
+		}
+		patched, err = repo.ModifyUser(ctx, id, origin, func(current user.User) (user.User, error) {
+			if version != 0 && version != current.Version {
+				return current, fmt.Errorf("user #%d is no longer at version %d: %w", id, version, domain.ErrPreconditionFailed)
+			}
+			manager = current.ManagerID
+			u, err := applyPatch(current, patchType, patch)
+			if err != nil {
+				return u, err
+			}
+			u.Normalize()
+			if err := u.Validate(); err != nil {
+				return u, err
+			}
+			if err := u.CheckAttributes(defs); err != nil {
+				return u, err
+			}
+			return u, nil
+		})
+		if err != nil {
+			return err
+		}
+		// The manager is checked once the patch is written, as the
+		// repository may not be used while ModifyUser runs modify.
+		if user.SameManager(manager, patched.ManagerID) {
+			return nil
+		}
+		return user.CheckManager(ctx, repo, id, patched.ManagerID)
+	})
+	if err != nil {
+		return patched, err
+	}
+	log.Printf("Patched user #%d\n", id)
+	return patched, nil
+}
+
+// DeleteUser hides the user until it is restored or purged. A non-zero
+// version makes the delete conditional, as for UpdateUser.
+func (s *Service) DeleteUser(ctx context.Context, id, version int64, origin audit.Origin) error {
+	id, err := s.repository.DeleteUser(ctx, id, version, origin)
 	if err != nil {
 		return err
 	}
 	log.Printf("Deleted user #%d\n", id)
 	return nil
 }
+
+// RestoreUser undeletes a user that has not been purged yet and returns it.
+func (s *Service) RestoreUser(ctx context.Context, id int64, origin audit.Origin) (user.User, error) {
+	var restored user.User
+	err := s.repository.WithTx(ctx, func(repo user.Repository) error {
+		if _, err := repo.RestoreUser(ctx, id, origin); err != nil {
+			return err
+		}
+		var err error
+		restored, err = repo.GetUser(ctx, id)
+		return err
+	})
+	if err != nil {
+		return user.User{}, err
+	}
+	log.Printf("Restored user #%d\n", id)
+	return restored, nil
+}
+
+// Batch validates and applies up to user.MaxBatchSize operations in one
+// transaction, returning a result per operation in order. An invalid
+// operation fails without reaching the repository, and in an atomic batch
+// aborts it before anything is written.
+func (s *Service) Batch(ctx context.Context, ops []user.BatchOperation, atomic bool, origin audit.Origin) ([]user.BatchResult, error) {
+	if len(ops) == 0 || len(ops) > user.MaxBatchSize {
+		return nil, &domain.ValidationError{Fields: []domain.FieldError{
+			{Field: "operations", Message: fmt.Sprintf("must contain between 1 and %d operations", user.MaxBatchSize)},
+		}}
+	}
+	defs, err := s.repository.AttributeDefinitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results, err := applyBatch(ctx, s.repository, ops, defs, atomic, origin)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Applied batch of %d operations\n", len(ops))
+	return results, nil
+}
+
+// applyBatch checks the attributes of the users in ops against defs, which
+// the caller reads once for however many batches it applies, and applies
+// the valid operations on r.
+func applyBatch(ctx context.Context, r user.Repository, ops []user.BatchOperation, defs []user.AttributeDefinition, atomic bool, origin audit.Origin) ([]user.BatchResult, error) {
+	results := make([]user.BatchResult, len(ops))
+	var valid []user.BatchOperation
+	var indexes []int
+	for i, op := range ops {
+		op.Normalize()
+		err := op.Validate()
+		// The attributes of a partial user are checked by Apply, once
+		// merged into the stored user.
+		if err == nil && op.Op != user.BatchDelete && op.Fields == nil {
+			err = op.User.CheckAttributes(defs)
+		}
+		if err != nil {
+			results[i].Err = err
+			if atomic {
+				user.AbortBatch(results, i)
+				return results, nil
+			}
+			continue
+		}
+		valid = append(valid, op)
+		indexes = append(indexes, i)
+	}
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	applied, err := r.Batch(ctx, valid, atomic, origin)
+	if err != nil {
+		return nil, err
+	}
+	for j, result := range applied {
+		results[indexes[j]] = result
+	}
+	return results, nil
+}
+
+// checkListOptions normalizes the tags of opts, and puts the values of its
+// attribute filter in canonical form, so that they match the stored ones.
+func (s *Service) checkListOptions(ctx context.Context, opts user.ListOptions) (user.ListOptions, error) {
+	if len(opts.Tags) > user.MaxTagFilters {
+		return opts, &domain.ValidationError{Fields: []domain.FieldError{
+			{Field: "tag", Message: fmt.Sprintf("must be given at most %d times", user.MaxTagFilters)},
+		}}
+	}
+	tags := opts.Tags
+	opts.Tags = nil
+	for _, tag := range tags {
+		opts.Tags = append(opts.Tags, user.NormalizeTag(tag))
+	}
+
+	if len(opts.Attributes) == 0 {
+		return opts, nil
+	}
+	defs, err := s.repository.AttributeDefinitions(ctx)
+	if err != nil {
+		return opts, err
+	}
+	return opts, user.CheckAttributeFilter(defs, opts.Attributes)
+}
@@ -1,26 +1,26 @@
 package service
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
 	"testing"
+	"time"
 
+	"github.com/pmaterer/peopler/audit"
+	"github.com/pmaterer/peopler/domain"
 	"github.com/pmaterer/peopler/user"
+	"github.com/pmaterer/peopler/user/repository/memory"
+	"github.com/pmaterer/peopler/user/repository/repositorytest"
 	"github.com/stretchr/testify/assert"
 )
 
-type mockRepository struct {
-	CreateUserFunc  func(u user.User) (int64, error)
-	GetAllUsersFunc func() ([]user.User, error)
-	GetUserFunc     func(id int64) (user.User, error)
-	UpdateUserFunc  func(u user.User) (int64, error)
-	DeleteUserFunc  func(id int64) (int64, error)
-}
-
-func (r *mockRepository) CreateUser(u user.User) (int64, error) { return r.CreateUserFunc(u) }
-func (r *mockRepository) GetAllUsers() ([]user.User, error)     { return r.GetAllUsersFunc() }
-func (r *mockRepository) GetUser(id int64) (user.User, error)   { return r.GetUserFunc(id) }
-func (r *mockRepository) UpdateUser(u user.User) (int64, error) { return r.UpdateUserFunc(u) }
-func (r *mockRepository) DeleteUser(id int64) (int64, error)    { return r.DeleteUserFunc(id) }
+// testOrigin is the audit origin of the changes made by the tests.
+var testOrigin = audit.Origin{Actor: "tester", RequestID: "req-1"}
 
 var (
 	testUser = user.User{
@@ -48,37 +48,58 @@ var (
 	}
 )
 
+// newBrokenRepository returns a repository that fails like one whose
+// database went away.
+func newBrokenRepository(t *testing.T) user.Repository {
+	return repositorytest.Broken{Repository: memory.NewRepository()}
+}
+
+// newTestRepository returns an in-memory repository holding testUser followed
+// by testUsers, so their IDs match the fixtures.
+func newTestRepository(t *testing.T) *memory.Reopository {
+	r := memory.NewRepository()
+	for _, u := range append([]user.User{testUser}, testUsers...) {
+		id, err := r.CreateUser(context.Background(), u, testOrigin)
+		assert.Nil(t, err)
+		assert.Equal(t, u.ID, id)
+	}
+	return r
+}
+
 func TestCreateUser(t *testing.T) {
 	tests := []struct {
 		name        string
 		errExpected bool
-		method      func(u user.User) (int64, error)
+		repository  func(t *testing.T) user.Repository
 	}{
 		{
 			name:        "Create user OK",
 			errExpected: false,
-			method: func(u user.User) (int64, error) {
-				return testUser.ID, nil
-			},
+			repository:  func(t *testing.T) user.Repository { return memory.NewRepository() },
 		},
 		{
 			name:        "Create user error",
 			errExpected: true,
-			method: func(u user.User) (int64, error) {
-				return testUser.ID, errors.New("bad things")
-			},
+			repository:  newBrokenRepository,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r := &mockRepository{CreateUserFunc: tt.method}
+			r := tt.repository(t)
 			s := NewService(r)
-			err := s.CreateUser(testUser)
+			created, err := s.CreateUser(context.Background(), testUser, testOrigin)
 			if tt.errExpected {
 				assert.Error(t, err)
 			} else {
 				assert.Nil(t, err)
+				assert.Equal(t, int64(1), created.ID)
+				assert.Equal(t, testUser.FirstName, created.FirstName)
+				assert.Equal(t, testUser.LastName, created.LastName)
+
+				stored, err := r.GetUser(context.Background(), created.ID)
+				assert.Nil(t, err)
+				assert.Equal(t, created, stored)
 			}
 		})
 	}
@@ -88,36 +109,34 @@ func TestGetAllUsers(t *testing.T) {
 	tests := []struct {
 		name        string
 		errExpected bool
-		method      func() ([]user.User, error)
+		repository  func(t *testing.T) user.Repository
 	}{
 		{
 			name:        "Get all users OK",
 			errExpected: false,
-			method: func() ([]user.User, error) {
-				return testUsers, nil
-			},
+			repository:  func(t *testing.T) user.Repository { return newTestRepository(t) },
 		},
 		{
 			name:        "Get all users error",
 			errExpected: true,
-			method: func() ([]user.User, error) {
-				return testUsers, errors.New("bad things")
-			},
+			repository:  newBrokenRepository,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r := &mockRepository{GetAllUsersFunc: tt.method}
-			s := NewService(r)
-			users, err := s.GetAllUsers()
+			s := NewService(tt.repository(t))
+			page, err := s.GetAllUsers(context.Background(), user.ListOptions{})
 			if tt.errExpected {
 				assert.Error(t, err)
 			} else {
-				for i, user := range users {
-					assert.Equal(t, testUsers[i].ID, user.ID)
-					assert.Equal(t, testUsers[i].FirstName, user.FirstName)
-					assert.Equal(t, testUsers[i].LastName, user.LastName)
+				expected := append([]user.User{testUser}, testUsers...)
+				assert.Len(t, page.Users, len(expected))
+				assert.Equal(t, int64(len(expected)), page.Total)
+				for i, user := range page.Users {
+					assert.Equal(t, expected[i].ID, user.ID)
+					assert.Equal(t, expected[i].FirstName, user.FirstName)
+					assert.Equal(t, expected[i].LastName, user.LastName)
 				}
 			}
 		})
@@ -128,29 +147,33 @@ func TestGetUser(t *testing.T) {
 	tests := []struct {
 		name        string
 		errExpected bool
-		method      func(id int64) (user.User, error)
+		repository  func(t *testing.T) user.Repository
+		id          int64
 	}{
 		{
 			name:        "Get user OK",
 			errExpected: false,
-			method: func(id int64) (user.User, error) {
-				return testUser, nil
-			},
+			repository:  func(t *testing.T) user.Repository { return newTestRepository(t) },
+			id:          testUser.ID,
+		},
+		{
+			name:        "Get user not found",
+			errExpected: true,
+			repository:  func(t *testing.T) user.Repository { return newTestRepository(t) },
+			id:          99,
 		},
 		{
 			name:        "Get user error",
 			errExpected: true,
-			method: func(id int64) (user.User, error) {
-				return testUser, errors.New("bad things")
-			},
+			repository:  newBrokenRepository,
+			id:          testUser.ID,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r := &mockRepository{GetUserFunc: tt.method}
-			s := NewService(r)
-			user, err := s.GetUser(testUser.ID)
+			s := NewService(tt.repository(t))
+			user, err := s.GetUser(context.Background(), tt.id)
 			if tt.errExpected {
 				assert.Error(t, err)
 			} else {
@@ -166,33 +189,36 @@ func TestUpdateUser(t *testing.T) {
 	tests := []struct {
 		name        string
 		errExpected bool
-		method      func(u user.User) (int64, error)
+		repository  func(t *testing.T) user.Repository
 	}{
 		{
 			name:        "Update user OK",
 			errExpected: false,
-			method: func(u user.User) (int64, error) {
-				return testUser.ID, nil
-			},
+			repository:  func(t *testing.T) user.Repository { return newTestRepository(t) },
 		},
 		{
 			name:        "Update user error",
 			errExpected: true,
-			method: func(u user.User) (int64, error) {
-				return testUser.ID, errors.New("bad things")
-			},
+			repository:  newBrokenRepository,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r := &mockRepository{UpdateUserFunc: tt.method}
+			r := tt.repository(t)
 			s := NewService(r)
-			err := s.UpdateUser(testUser)
+			updated := testUser
+			updated.LastName = "Kingsley"
+			stored, err := s.UpdateUser(context.Background(), updated, testOrigin)
 			if tt.errExpected {
 				assert.Error(t, err)
 			} else {
 				assert.Nil(t, err)
+				assert.Equal(t, "Kingsley", stored.LastName)
+				assert.Equal(t, int64(2), stored.Version)
+				u, err := r.GetUser(context.Background(), testUser.ID)
+				assert.Nil(t, err)
+				assert.Equal(t, stored, u)
 			}
 		})
 	}
@@ -202,34 +228,533 @@ func TestDeleteUser(t *testing.T) {
 	tests := []struct {
 		name        string
 		errExpected bool
-		method      func(id int64) (int64, error)
+		repository  func(t *testing.T) user.Repository
 	}{
 		{
 			name:        "Delete user OK",
 			errExpected: false,
-			method: func(id int64) (int64, error) {
-				return testUser.ID, nil
-			},
+			repository:  func(t *testing.T) user.Repository { return newTestRepository(t) },
 		},
 		{
 			name:        "Delete user error",
 			errExpected: true,
-			method: func(id int64) (int64, error) {
-				return testUser.ID, errors.New("bad things")
-			},
+			repository:  newBrokenRepository,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r := &mockRepository{DeleteUserFunc: tt.method}
+			r := tt.repository(t)
 			s := NewService(r)
-			err := s.DeleteUser(testUser.ID)
+			err := s.DeleteUser(context.Background(), testUser.ID, 0, testOrigin)
 			if tt.errExpected {
 				assert.Error(t, err)
 			} else {
 				assert.Nil(t, err)
+				_, err := r.GetUser(context.Background(), testUser.ID)
+				assert.Error(t, err)
 			}
 		})
 	}
 }
+
+func TestInvalidUserIsRejected(t *testing.T) {
+	r := newTestRepository(t)
+	s := NewService(r)
+
+	_, err := s.CreateUser(context.Background(), user.User{FirstName: "  ", LastName: "King"}, testOrigin)
+	assert.True(t, errors.Is(err, domain.ErrValidation))
+
+	_, err = s.UpdateUser(context.Background(), user.User{ID: testUser.ID, FirstName: "Stephen"}, testOrigin)
+	assert.True(t, errors.Is(err, domain.ErrValidation))
+
+	u, err := r.GetUser(context.Background(), testUser.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, testUser.LastName, u.LastName)
+}
+
+func TestUserIsNormalized(t *testing.T) {
+	r := memory.NewRepository()
+	s := NewService(r)
+
+	created, err := s.CreateUser(context.Background(), user.User{FirstName: " Stephen ", LastName: "King\n"}, testOrigin)
+	assert.Nil(t, err)
+	assert.Equal(t, "Stephen", created.FirstName)
+
+	u, err := r.GetUser(context.Background(), created.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Stephen", u.FirstName)
+	assert.Equal(t, "King", u.LastName)
+}
+
+func TestNotFoundIsPropagated(t *testing.T) {
+	s := NewService(newTestRepository(t))
+
+	_, err := s.GetUser(context.Background(), 99)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+
+	_, err = s.UpdateUser(context.Background(), user.User{ID: 99, FirstName: "Nobody", LastName: "Nowhere"}, testOrigin)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+
+	err = s.DeleteUser(context.Background(), 99, 0, testOrigin)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+}
+
+func TestSearchUsers(t *testing.T) {
+	s := NewService(newTestRepository(t))
+
+	page, err := s.SearchUsers(context.Background(), "  mel ", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), page.Total)
+	assert.Equal(t, testUsers[0].ID, page.Users[0].ID)
+
+	_, err = s.SearchUsers(context.Background(), " -- ", 0)
+	assert.True(t, errors.Is(err, domain.ErrValidation))
+}
+
+func TestConditionalWrites(t *testing.T) {
+	r := newTestRepository(t)
+	s := NewService(r)
+
+	current, err := s.GetUser(context.Background(), testUser.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), current.Version)
+
+	current.LastName = "Kingsley"
+	_, err = s.UpdateUser(context.Background(), current, testOrigin)
+	assert.Nil(t, err)
+
+	// current.Version is now stale.
+	_, err = s.UpdateUser(context.Background(), current, testOrigin)
+	assert.True(t, errors.Is(err, domain.ErrPreconditionFailed))
+	_, err = s.PatchUser(context.Background(), testUser.ID, current.Version, user.MergePatchType, []byte(`{"lastName":"King"}`), testOrigin)
+	assert.True(t, errors.Is(err, domain.ErrPreconditionFailed))
+	err = s.DeleteUser(context.Background(), testUser.ID, current.Version, testOrigin)
+	assert.True(t, errors.Is(err, domain.ErrPreconditionFailed))
+
+	patched, err := s.PatchUser(context.Background(), testUser.ID, 2, user.MergePatchType, []byte(`{"lastName":"King"}`), testOrigin)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), patched.Version)
+
+	assert.Nil(t, s.DeleteUser(context.Background(), testUser.ID, 3, testOrigin))
+}
+
+func TestRestoreUser(t *testing.T) {
+	s := NewService(newTestRepository(t))
+
+	assert.Nil(t, s.DeleteUser(context.Background(), testUser.ID, 0, testOrigin))
+	_, err := s.GetUser(context.Background(), testUser.ID)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+
+	restored, err := s.RestoreUser(context.Background(), testUser.ID, testOrigin)
+	assert.Nil(t, err)
+	assert.Equal(t, testUser.LastName, restored.LastName)
+	assert.Nil(t, restored.DeletedAt)
+
+	_, err = s.RestoreUser(context.Background(), testUser.ID, testOrigin)
+	assert.True(t, errors.Is(err, domain.ErrConflict))
+}
+
+func TestPurgeDeletedUsers(t *testing.T) {
+	r := newTestRepository(t)
+	s := NewService(r)
+	assert.Nil(t, s.DeleteUser(context.Background(), testUser.ID, 0, testOrigin))
+
+	purged, err := s.PurgeDeletedUsers(context.Background(), time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), purged)
+
+	purged, err = s.PurgeDeletedUsers(context.Background(), -time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), purged)
+	_, err = s.RestoreUser(context.Background(), testUser.ID, testOrigin)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+
+	_, err = NewService(newBrokenRepository(t)).PurgeDeletedUsers(context.Background(), time.Hour)
+	assert.True(t, errors.Is(err, repositorytest.ErrBroken))
+}
+
+func TestGetUserHistory(t *testing.T) {
+	r := newTestRepository(t)
+	s := NewService(r)
+
+	_, err := s.UpdateUser(context.Background(), user.User{ID: testUser.ID, FirstName: "Stephen", LastName: "Kingsley"}, testOrigin)
+	assert.Nil(t, err)
+	events, err := s.GetUserHistory(context.Background(), testUser.ID)
+	assert.Nil(t, err)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, audit.Update, events[1].Operation)
+		assert.Equal(t, testOrigin.Actor, events[1].Actor)
+	}
+
+	_, err = s.GetUserHistory(context.Background(), 99)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+
+	_, err = NewService(newBrokenRepository(t)).GetUserHistory(context.Background(), testUser.ID)
+	assert.True(t, errors.Is(err, repositorytest.ErrBroken))
+}
+
+func TestBatch(t *testing.T) {
+	ops := []user.BatchOperation{
+		{Op: user.BatchCreate, User: user.User{FirstName: "  Julian ", LastName: "Barnes"}},
+		{Op: user.BatchUpdate, ID: testUser.ID, User: user.User{FirstName: "Stephen", LastName: ""}},
+		{Op: "merge", ID: testUser.ID},
+		{Op: user.BatchDelete},
+		{Op: user.BatchDelete, ID: testUsers[0].ID},
+	}
+
+	t.Run("Not atomic", func(t *testing.T) {
+		s := NewService(newTestRepository(t))
+		results, err := s.Batch(context.Background(), ops, false, testOrigin)
+		assert.Nil(t, err)
+		if assert.Len(t, results, len(ops)) {
+			assert.Nil(t, results[0].Err)
+			for _, i := range []int{1, 2, 3} {
+				assert.True(t, errors.Is(results[i].Err, domain.ErrValidation), "operation %d", i)
+			}
+			assert.Nil(t, results[4].Err)
+		}
+		created, err := s.GetUser(context.Background(), results[0].ID)
+		assert.Nil(t, err)
+		assert.Equal(t, "Julian", created.FirstName)
+		_, err = s.GetUser(context.Background(), testUsers[0].ID)
+		assert.True(t, errors.Is(err, domain.ErrNotFound))
+	})
+
+	t.Run("Atomic", func(t *testing.T) {
+		s := NewService(newTestRepository(t))
+		results, err := s.Batch(context.Background(), ops, true, testOrigin)
+		assert.Nil(t, err)
+		if assert.Len(t, results, len(ops)) {
+			assert.True(t, errors.Is(results[1].Err, domain.ErrValidation))
+			for _, i := range []int{0, 2, 3, 4} {
+				assert.True(t, errors.Is(results[i].Err, domain.ErrNotApplied), "operation %d", i)
+			}
+		}
+		_, err = s.GetUser(context.Background(), testUsers[0].ID)
+		assert.Nil(t, err)
+	})
+
+	t.Run("Size", func(t *testing.T) {
+		s := NewService(newTestRepository(t))
+		for _, n := range []int{0, user.MaxBatchSize + 1} {
+			_, err := s.Batch(context.Background(), make([]user.BatchOperation, n), false, testOrigin)
+			assert.True(t, errors.Is(err, domain.ErrValidation), "%d operations", n)
+		}
+	})
+
+	t.Run("Broken repository", func(t *testing.T) {
+		_, err := NewService(newBrokenRepository(t)).Batch(context.Background(), ops, false, testOrigin)
+		assert.True(t, errors.Is(err, repositorytest.ErrBroken))
+	})
+}
+
+func TestImportUsers(t *testing.T) {
+	s := NewService(newTestRepository(t))
+	users := []user.User{
+		{FirstName: "Julian", LastName: "Barnes", ExternalID: " HR-1 "},
+		{FirstName: "Ian", LastName: "McEwan"},
+	}
+
+	results, err := s.ImportUsers(context.Background(), users, nil, true, true, testOrigin)
+	assert.Nil(t, err)
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, user.BatchResult{Created: true}, results[0])
+	}
+	_, err = s.repository.GetUserByExternalID(context.Background(), "HR-1")
+	assert.True(t, errors.Is(err, domain.ErrNotFound), "a dry run is rolled back")
+
+	users[0].LastName = "Barnes-Smith"
+	for _, created := range []bool{true, false} {
+		results, err = s.ImportUsers(context.Background(), users[:1], nil, true, false, testOrigin)
+		assert.Nil(t, err)
+		if assert.Len(t, results, 1) {
+			assert.Nil(t, results[0].Err)
+			assert.Equal(t, created, results[0].Created)
+		}
+	}
+	u, err := s.repository.GetUserByExternalID(context.Background(), "HR-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Barnes-Smith", u.LastName)
+
+	_, err = s.ImportUsers(context.Background(), nil, nil, true, false, testOrigin)
+	assert.True(t, errors.Is(err, domain.ErrValidation))
+	_, err = NewService(newBrokenRepository(t)).ImportUsers(context.Background(), users, nil, false, false, testOrigin)
+	assert.True(t, errors.Is(err, repositorytest.ErrBroken))
+}
+
+func TestStreamImportUsers(t *testing.T) {
+	s := NewService(newTestRepository(t))
+	records := func(items ...interface{}) func() (user.ImportRecord, error) {
+		var line int
+		return func() (user.ImportRecord, error) {
+			if len(items) == 0 {
+				return user.ImportRecord{}, io.EOF
+			}
+			item := items[0]
+			items = items[1:]
+			line++
+			if err, ok := item.(error); ok {
+				return user.ImportRecord{Line: line}, err
+			}
+			return user.ImportRecord{User: item.(user.User), Line: line}, nil
+		}
+	}
+	var failures []int
+	failed := func(record user.ImportRecord, result user.BatchResult) {
+		assert.Error(t, result.Err)
+		failures = append(failures, record.Line)
+	}
+	malformed := &domain.ValidationError{Fields: []domain.FieldError{{Field: "line", Message: "is not a JSON user"}}}
+	julian := user.User{FirstName: "Julian", LastName: "Barnes", ExternalID: "HR-1"}
+	nameless := user.User{LastName: "Hesse", ExternalID: "HR-2"}
+
+	summary, err := s.StreamImportUsers(context.Background(), records(julian, malformed, nameless), false, true, testOrigin, failed)
+	assert.Nil(t, err)
+	assert.Equal(t, user.ImportSummary{Created: 1, Failed: 2}, summary)
+	assert.Equal(t, []int{2, 3}, failures)
+	_, err = s.repository.GetUserByExternalID(context.Background(), "HR-1")
+	assert.True(t, errors.Is(err, domain.ErrNotFound), "a dry run is rolled back")
+
+	failures = nil
+	summary, err = s.StreamImportUsers(context.Background(), records(julian, nameless, julian), true, false, testOrigin, failed)
+	assert.Nil(t, err)
+	assert.Equal(t, user.ImportSummary{Failed: 1, Aborted: true}, summary)
+	assert.Equal(t, []int{2}, failures, "an atomic import stops at its first failure")
+	_, err = s.repository.GetUserByExternalID(context.Background(), "HR-1")
+	assert.True(t, errors.Is(err, domain.ErrNotFound), "an aborted import is rolled back")
+
+	julian.LastName = "Barnes-Smith"
+	summary, err = s.StreamImportUsers(context.Background(), records(julian, julian), true, false, testOrigin, failed)
+	assert.Nil(t, err)
+	assert.Equal(t, user.ImportSummary{Created: 1, Updated: 1}, summary)
+	u, err := s.repository.GetUserByExternalID(context.Background(), "HR-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Barnes-Smith", u.LastName)
+
+	broken := errors.New("connection reset")
+	_, err = s.StreamImportUsers(context.Background(), records(julian, broken), false, false, testOrigin, failed)
+	assert.Equal(t, broken, err)
+	_, err = NewService(newBrokenRepository(t)).StreamImportUsers(context.Background(), records(julian), false, false, testOrigin, failed)
+	assert.True(t, errors.Is(err, repositorytest.ErrBroken))
+
+	r := &repositorytest.Counting{Repository: newTestRepository(t)}
+	readers := make([]interface{}, importChunkSize+1)
+	for i := range readers {
+		readers[i] = user.User{FirstName: "Reader", LastName: fmt.Sprint(i)}
+	}
+	next := records(readers...)
+	summary, err = NewService(r).StreamImportUsers(context.Background(), func() (user.ImportRecord, error) {
+		assert.False(t, r.Open, "no transaction is open while reading")
+		return next()
+	}, false, false, testOrigin, failed)
+	assert.Nil(t, err)
+	assert.Equal(t, user.ImportSummary{Created: importChunkSize + 1}, summary)
+	assert.Equal(t, 2, r.Txs, "a non-atomic import is written a chunk at a time")
+}
+
+func TestManagers(t *testing.T) {
+	ctx := context.Background()
+	s := NewService(newTestRepository(t))
+	manage := func(id, managerID int64) error {
+		u, err := s.GetUser(ctx, id)
+		assert.Nil(t, err)
+		u.ManagerID = &managerID
+		_, err = s.UpdateUser(ctx, u, testOrigin)
+		return err
+	}
+	assert.Nil(t, manage(2, 1))
+	assert.Nil(t, manage(3, 2))
+
+	missing := int64(99)
+	_, err := s.CreateUser(ctx, user.User{FirstName: "Julian", LastName: "Barnes", ManagerID: &missing}, testOrigin)
+	assert.True(t, errors.Is(err, domain.ErrValidation))
+	assert.True(t, errors.Is(manage(2, 2), domain.ErrValidation), "users cannot manage themselves")
+	assert.True(t, errors.Is(manage(1, 3), domain.ErrValidation), "management cannot go round in circles")
+
+	_, err = s.PatchUser(ctx, 1, 0, user.MergePatchType, []byte(`{"managerId":3}`), testOrigin)
+	assert.True(t, errors.Is(err, domain.ErrValidation))
+	u, err := s.GetUser(ctx, 1)
+	assert.Nil(t, err)
+	assert.Nil(t, u.ManagerID, "a rejected patch is rolled back")
+	assert.Equal(t, int64(1), u.Version)
+
+	reports, err := s.GetReports(ctx, 1, 2)
+	assert.Nil(t, err)
+	if assert.Len(t, reports, 1) && assert.Len(t, reports[0].Reports, 1) {
+		assert.Equal(t, int64(2), reports[0].ID)
+		assert.Equal(t, int64(3), reports[0].Reports[0].ID)
+	}
+	_, err = s.GetReports(ctx, 99, 1)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+	_, err = s.GetReports(ctx, 1, 0)
+	assert.True(t, errors.Is(err, domain.ErrValidation))
+
+	chain, err := s.GetManagementChain(ctx, 3)
+	assert.Nil(t, err)
+	if assert.Len(t, chain, 2) {
+		assert.Equal(t, int64(2), chain[0].ID)
+		assert.Equal(t, int64(1), chain[1].ID)
+	}
+
+	chart, err := s.GetOrgChart(ctx, user.MaxOrgDepth)
+	assert.Nil(t, err)
+	if assert.Len(t, chart, 2) {
+		assert.Equal(t, int64(1), chart[0].ID)
+		assert.Len(t, chart[0].Reports, 1)
+		assert.Equal(t, int64(4), chart[1].ID)
+	}
+
+	// The reports of a deleted manager can still be edited, but not given
+	// to the deleted manager anew.
+	assert.Nil(t, s.DeleteUser(ctx, 2, 0, testOrigin))
+	_, err = s.PatchUser(ctx, 3, 0, user.MergePatchType, []byte(`{"title":"Engineer"}`), testOrigin)
+	assert.Nil(t, err)
+	u, err = s.GetUser(ctx, 3)
+	assert.Nil(t, err)
+	u.Title = "Senior Engineer"
+	_, err = s.UpdateUser(ctx, u, testOrigin)
+	assert.Nil(t, err)
+	results, err := s.Batch(ctx, []user.BatchOperation{{Op: user.BatchUpdate, ID: 3, User: u}}, false, testOrigin)
+	assert.Nil(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Nil(t, results[0].Err)
+	}
+	_, err = s.PatchUser(ctx, 4, 0, user.MergePatchType, []byte(`{"managerId":2}`), testOrigin)
+	assert.True(t, errors.Is(err, domain.ErrValidation))
+
+	for i := 0; i < user.MaxOrgChartSize; i++ {
+		_, err := s.repository.CreateUser(ctx, user.User{FirstName: "Reader", LastName: fmt.Sprint(i)}, testOrigin)
+		assert.Nil(t, err)
+	}
+	_, err = s.GetOrgChart(ctx, user.MaxOrgDepth)
+	assert.True(t, errors.Is(err, domain.ErrValidation), "the chart is too large to list")
+
+	_, err = NewService(newBrokenRepository(t)).GetOrgChart(ctx, 1)
+	assert.True(t, errors.Is(err, repositorytest.ErrBroken))
+}
+
+func TestAttributes(t *testing.T) {
+	ctx := context.Background()
+	s := NewService(newTestRepository(t))
+
+	d, created, err := s.PutAttributeDefinition(ctx, user.AttributeDefinition{Name: " remote ", Type: "Boolean"})
+	assert.Nil(t, err)
+	assert.True(t, created)
+	assert.Equal(t, user.AttributeDefinition{Name: "remote", Type: user.AttributeBoolean}, d)
+	_, _, err = s.PutAttributeDefinition(ctx, user.AttributeDefinition{Name: "desk", Pattern: "[0-9]+[A-Z]", Required: true})
+	assert.Nil(t, err)
+	_, _, err = s.PutAttributeDefinition(ctx, user.AttributeDefinition{Name: "floor", Type: "storey"})
+	assert.True(t, errors.Is(err, domain.ErrValidation))
+	_, err = s.GetAttributeDefinition(ctx, "floor")
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+
+	_, err = s.CreateUser(ctx, user.User{FirstName: "Julian", LastName: "Barnes",
+		Attributes: map[string]string{"desk": "4B", "remote": "yes"}}, testOrigin)
+	assert.True(t, errors.Is(err, domain.ErrValidation), "yes is not a boolean")
+	julian, err := s.CreateUser(ctx, user.User{FirstName: "Julian", LastName: "Barnes",
+		Attributes: map[string]string{"desk": "4B", "remote": "TRUE"}}, testOrigin)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"desk": "4B", "remote": "true"}, julian.Attributes)
+
+	// Users defined before the attribute became required must be given one
+	// on their next write.
+	_, err = s.PatchUser(ctx, 1, 0, user.MergePatchType, []byte(`{"title":"Novelist"}`), testOrigin)
+	assert.True(t, errors.Is(err, domain.ErrValidation))
+	patched, err := s.PatchUser(ctx, 1, 0, user.MergePatchType, []byte(`{"attributes":{"desk":"7A"}}`), testOrigin)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"desk": "7A"}, patched.Attributes)
+
+	page, err := s.GetAllUsers(ctx, user.ListOptions{Attributes: map[string]string{"remote": "1"}})
+	assert.Nil(t, err)
+	if assert.Len(t, page.Users, 1) {
+		assert.Equal(t, julian.ID, page.Users[0].ID)
+	}
+	_, err = s.GetAllUsers(ctx, user.ListOptions{Attributes: map[string]string{"github": "jbarnes"}})
+	assert.True(t, errors.Is(err, domain.ErrValidation))
+
+	results, err := s.Batch(ctx, []user.BatchOperation{
+		{Op: user.BatchCreate, User: user.User{FirstName: "Ian", LastName: "McEwan", Attributes: map[string]string{"desk": "2C"}}},
+		{Op: user.BatchCreate, User: user.User{FirstName: "Zadie", LastName: "Smith"}},
+	}, false, testOrigin)
+	assert.Nil(t, err)
+	if assert.Len(t, results, 2) {
+		assert.Nil(t, results[0].Err)
+		assert.True(t, errors.Is(results[1].Err, domain.ErrValidation), "desk is required")
+	}
+
+	assert.Nil(t, s.DeleteAttributeDefinition(ctx, "desk", testOrigin))
+	u, err := s.GetUser(ctx, 1)
+	assert.Nil(t, err)
+	assert.Nil(t, u.Attributes)
+	err = s.DeleteAttributeDefinition(ctx, "desk", testOrigin)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+}
+
+func TestTags(t *testing.T) {
+	ctx := context.Background()
+	s := NewService(newTestRepository(t))
+
+	assert.Nil(t, s.TagUser(ctx, 1, " Remote"))
+	assert.Nil(t, s.TagUser(ctx, 2, "remote"))
+	assert.Nil(t, s.TagUser(ctx, 2, "OnCall"))
+	assert.True(t, errors.Is(s.TagUser(ctx, 1, "part time"), domain.ErrValidation))
+	assert.True(t, errors.Is(s.TagUser(ctx, 99, "remote"), domain.ErrNotFound))
+
+	tags, err := s.GetUserTags(ctx, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"oncall", "remote"}, tags)
+	counts, err := s.GetTags(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []user.TagCount{{Name: "oncall", Count: 1}, {Name: "remote", Count: 2}}, counts)
+
+	page, err := s.GetAllUsers(ctx, user.ListOptions{Tags: []string{"REMOTE", "oncall"}})
+	assert.Nil(t, err)
+	if assert.Len(t, page.Users, 1) {
+		assert.Equal(t, int64(2), page.Users[0].ID)
+	}
+	page, err = s.GetAllUsers(ctx, user.ListOptions{Tags: []string{"Remote", "oncall"}, AnyTag: true})
+	assert.Nil(t, err)
+	assert.Len(t, page.Users, 2)
+	_, err = s.GetAllUsers(ctx, user.ListOptions{Tags: make([]string, user.MaxTagFilters+1)})
+	assert.True(t, errors.Is(err, domain.ErrValidation))
+
+	assert.Nil(t, s.UntagUser(ctx, 1, "REMOTE"))
+	assert.True(t, errors.Is(s.UntagUser(ctx, 1, "remote"), domain.ErrNotFound))
+}
+
+func TestPhotos(t *testing.T) {
+	ctx := context.Background()
+	s := NewService(newTestRepository(t))
+	var buf bytes.Buffer
+	assert.Nil(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 300, 100))))
+
+	_, err := s.GetUserPhoto(ctx, 1, user.PhotoOriginal)
+	assert.True(t, errors.Is(err, domain.ErrNotFound))
+	assert.True(t, errors.Is(s.PutUserPhoto(ctx, 1, []byte("not a photo")), domain.ErrValidation))
+	assert.True(t, errors.Is(s.PutUserPhoto(ctx, 99, buf.Bytes()), domain.ErrNotFound))
+
+	assert.Nil(t, s.PutUserPhoto(ctx, 1, buf.Bytes()))
+	for _, size := range user.PhotoSizes {
+		photo, err := s.GetUserPhoto(ctx, 1, size)
+		assert.Nil(t, err)
+		assert.Equal(t, user.PhotoPNG, user.PhotoContentType(photo), size)
+	}
+
+	assert.Nil(t, s.DeleteUser(ctx, 1, 0, testOrigin))
+	_, err = s.GetUserPhoto(ctx, 1, user.PhotoSmall)
+	assert.True(t, errors.Is(err, domain.ErrNotFound), "deleted users hide their photo")
+	_, err = s.RestoreUser(ctx, 1, testOrigin)
+	assert.Nil(t, err)
+	assert.Nil(t, s.DeleteUserPhoto(ctx, 1))
+	assert.True(t, errors.Is(s.DeleteUserPhoto(ctx, 1), domain.ErrNotFound))
+
+	assert.Nil(t, s.PutUserPhoto(ctx, 1, buf.Bytes()))
+	assert.Nil(t, s.DeleteUser(ctx, 1, 0, testOrigin))
+	_, err = s.PurgeDeletedUsers(ctx, -time.Hour)
+	assert.Nil(t, err)
+	for _, size := range user.PhotoSizes {
+		_, err := s.Photos.Get(ctx, photoKey(1, size))
+		assert.True(t, errors.Is(err, domain.ErrNotFound), "purging a user deletes their photo")
+	}
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	"github.com/pmaterer/peopler/user"
+)
+
+// TagUser tags the user. Tags ignore case, and tagging a user twice changes
+// nothing.
+func (s *Service) TagUser(ctx context.Context, id int64, tag string) error {
+	tag = user.NormalizeTag(tag)
+	if err := user.ValidateTag(tag); err != nil {
+		return err
+	}
+	if err := s.repository.TagUser(ctx, id, tag); err != nil {
+		return err
+	}
+	log.Printf("Tagged user #%d %q\n", id, tag)
+	return nil
+}
+
+func (s *Service) UntagUser(ctx context.Context, id int64, tag string) error {
+	tag = user.NormalizeTag(tag)
+	if err := s.repository.UntagUser(ctx, id, tag); err != nil {
+		return err
+	}
+	log.Printf("Untagged user #%d %q\n", id, tag)
+	return nil
+}
+
+func (s *Service) GetUserTags(ctx context.Context, id int64) ([]string, error) {
+	return s.repository.UserTags(ctx, id)
+}
+
+// GetTags returns every tag in use, with how many people have it.
+func (s *Service) GetTags(ctx context.Context) ([]user.TagCount, error) {
+	return s.repository.Tags(ctx)
+}
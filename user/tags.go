@@ -0,0 +1,50 @@
+package user
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pmaterer/peopler/domain"
+)
+
+const (
+	// MaxTagLength is the longest tag accepted, in characters.
+	MaxTagLength = 50
+	// MaxTagFilters is the most tags a listing may filter by.
+	MaxTagFilters = 20
+)
+
+// tagPattern matches a normalized tag, which has to stand in a URL path.
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N}._-]*$`)
+
+// TagCount is a tag with the number of live users who have it.
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// NormalizeTag trims the tag, converts it to Unicode NFC and lowers its
+// case, so Remote and remote are the same tag.
+func NormalizeTag(tag string) string {
+	return strings.ToLower(normalizeString(tag))
+}
+
+// ValidateTag reports a tag that breaks the rules as a
+// *domain.ValidationError. Call NormalizeTag first.
+func ValidateTag(tag string) error {
+	var message string
+	switch {
+	case tag == "":
+		message = "is required"
+	case utf8.RuneCountInString(tag) > MaxTagLength:
+		message = fmt.Sprintf("must be at most %d characters", MaxTagLength)
+	case !tagPattern.MatchString(tag):
+		message = "must start with a letter or digit and contain only letters, digits, dots, dashes and underscores"
+	}
+	if message != "" {
+		return &domain.ValidationError{Fields: []domain.FieldError{{Field: "tag", Message: message}}}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package user
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTag(t *testing.T) {
+	tests := []struct {
+		tag     string
+		message string
+	}{
+		{tag: " On-Call ", message: ""},
+		{tag: "Équipe.Paris_2", message: ""},
+		{tag: "", message: "is required"},
+		{tag: "-remote", message: "must start with a letter or digit and contain only letters, digits, dots, dashes and underscores"},
+		{tag: "part time", message: "must start with a letter or digit and contain only letters, digits, dots, dashes and underscores"},
+		{tag: strings.Repeat("x", MaxTagLength+1), message: "must be at most 50 characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			err := ValidateTag(NormalizeTag(tt.tag))
+			if tt.message == "" {
+				assert.Nil(t, err)
+				return
+			}
+			var validationErr *domain.ValidationError
+			assert.True(t, errors.As(err, &validationErr))
+			assert.Equal(t, []domain.FieldError{{Field: "tag", Message: tt.message}}, validationErr.Fields)
+		})
+	}
+	assert.Equal(t, "on-call", NormalizeTag(" On-Call "))
+}
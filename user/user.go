@@ -1,7 +1,64 @@
 package user
 
+import "time"
+
 type User struct {
 	ID        int64  `json:"id"`
 	FirstName string `json:"firstName"`
 	LastName  string `json:"lastName"`
+	// ExternalID is the optional key of the user in another system, such as
+	// an HR spreadsheet. It is unique among all users.
+	ExternalID string `json:"externalId,omitempty"`
+	// Emails and Phones list the ways to reach the user. At most one of each
+	// is primary.
+	Emails         []Email `json:"emails,omitempty"`
+	Phones         []Phone `json:"phones,omitempty"`
+	Title          string  `json:"title,omitempty"`
+	Department     string  `json:"department,omitempty"`
+	EmployeeNumber string  `json:"employeeNumber,omitempty"`
+	// StartDate is the user's first working day, as YYYY-MM-DD.
+	StartDate string `json:"startDate,omitempty"`
+	// ManagerID is the ID of the user this one reports to, if any.
+	ManagerID *int64 `json:"managerId,omitempty"`
+	// Attributes holds the values of the custom attributes the deployment
+	// defines, by name. See AttributeDefinition.
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Version starts at 1 and is incremented by every update. It is exposed
+	// as the ETag rather than in the body.
+	Version int64 `json:"-"`
+	// DeletedAt is set while the user is deleted but not yet purged. Deleted
+	// users are only visible in listings that ask for them.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// Contact types of emails and phones. Mobile only applies to phones.
+const (
+	ContactWork   = "work"
+	ContactHome   = "home"
+	ContactMobile = "mobile"
+	ContactOther  = "other"
+)
+
+// PrimaryEmail returns the primary email and its index in u.Emails, or -1 if
+// u has none.
+func (u User) PrimaryEmail() (Email, int) {
+	for i, e := range u.Emails {
+		if e.Primary {
+			return e, i
+		}
+	}
+	return Email{}, -1
+}
+
+type Email struct {
+	Address string `json:"address"`
+	Type    string `json:"type"`
+	Primary bool   `json:"primary"`
+}
+
+// Phone holds a number in E.164 format, such as +14155550123.
+type Phone struct {
+	Number  string `json:"number"`
+	Type    string `json:"type"`
+	Primary bool   `json:"primary"`
 }
@@ -0,0 +1,217 @@
+package user
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pmaterer/peopler/domain"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// MaxNameLength is the longest first or last name accepted, in
+	// characters.
+	MaxNameLength = 100
+	// MaxExternalIDLength is the longest external ID accepted, in
+	// characters.
+	MaxExternalIDLength = 64
+	// MaxTitleLength is the longest job title or department accepted, in
+	// characters.
+	MaxTitleLength = 100
+	// MaxEmployeeNumberLength is the longest employee number accepted, in
+	// characters.
+	MaxEmployeeNumberLength = 32
+	// MaxEmailLength is the longest email address accepted, in bytes, as
+	// limited by SMTP.
+	MaxEmailLength = 254
+	// MaxContacts is the most emails, and the most phones, a user may have.
+	MaxContacts = 10
+)
+
+// dateLayout is the format of StartDate.
+const dateLayout = "2006-01-02"
+
+// e164 matches a phone number in E.164 format: a plus sign and up to 15
+// digits, the first of which is the country code and never 0.
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// phoneFormatting strips the separators people write phone numbers with.
+var phoneFormatting = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "")
+
+// Normalize trims surrounding whitespace from the names and converts them to
+// Unicode NFC, so visually identical names are stored identically. Phone
+// numbers lose their formatting, contact types default to work, and the
+// first email and phone become primary if none is. Attributes with empty
+// values are dropped.
+func (u *User) Normalize() {
+	u.FirstName = normalizeString(u.FirstName)
+	u.LastName = normalizeString(u.LastName)
+	u.ExternalID = normalizeString(u.ExternalID)
+	u.Title = normalizeString(u.Title)
+	u.Department = normalizeString(u.Department)
+	u.EmployeeNumber = normalizeString(u.EmployeeNumber)
+	u.StartDate = strings.TrimSpace(u.StartDate)
+	u.Attributes = normalizeAttributes(u.Attributes)
+
+	var primary bool
+	for i := range u.Emails {
+		e := &u.Emails[i]
+		e.Address = normalizeString(e.Address)
+		e.Type = normalizeContactType(e.Type)
+		primary = primary || e.Primary
+	}
+	if !primary && len(u.Emails) > 0 {
+		u.Emails[0].Primary = true
+	}
+
+	primary = false
+	for i := range u.Phones {
+		p := &u.Phones[i]
+		p.Number = phoneFormatting.Replace(strings.TrimSpace(p.Number))
+		p.Type = normalizeContactType(p.Type)
+		primary = primary || p.Primary
+	}
+	if !primary && len(u.Phones) > 0 {
+		u.Phones[0].Primary = true
+	}
+}
+
+// Validate reports every field that breaks the rules as a
+// *domain.ValidationError. Call Normalize first. Attributes are only checked
+// against their definitions by CheckAttributes.
+func (u User) Validate() error {
+	var fields []domain.FieldError
+	fields = appendNameErrors(fields, "firstName", u.FirstName)
+	fields = appendNameErrors(fields, "lastName", u.LastName)
+	fields = appendTextErrors(fields, "externalId", u.ExternalID, MaxExternalIDLength)
+	fields = appendTextErrors(fields, "title", u.Title, MaxTitleLength)
+	fields = appendTextErrors(fields, "department", u.Department, MaxTitleLength)
+	fields = appendTextErrors(fields, "employeeNumber", u.EmployeeNumber, MaxEmployeeNumberLength)
+	if u.StartDate != "" {
+		if _, err := time.Parse(dateLayout, u.StartDate); err != nil {
+			fields = append(fields, domain.FieldError{Field: "startDate", Message: "must be a date such as 2021-03-15"})
+		}
+	}
+	if u.ManagerID != nil && *u.ManagerID < 1 {
+		fields = append(fields, domain.FieldError{Field: "managerId", Message: "must be the ID of a user"})
+	}
+	fields = appendEmailErrors(fields, u.Emails)
+	fields = appendPhoneErrors(fields, u.Phones)
+	fields = appendAttributeErrors(fields, u.Attributes)
+	if len(fields) > 0 {
+		return &domain.ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+func normalizeString(s string) string {
+	return norm.NFC.String(strings.TrimSpace(s))
+}
+
+func normalizeContactType(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return ContactWork
+	}
+	return s
+}
+
+func appendNameErrors(fields []domain.FieldError, field, value string) []domain.FieldError {
+	if value == "" {
+		return append(fields, domain.FieldError{Field: field, Message: "is required"})
+	}
+	return appendTextErrors(fields, field, value, MaxNameLength)
+}
+
+// appendTextErrors checks an optional single line of text.
+func appendTextErrors(fields []domain.FieldError, field, value string, max int) []domain.FieldError {
+	switch {
+	case !utf8.ValidString(value):
+		return append(fields, domain.FieldError{Field: field, Message: "must be valid UTF-8"})
+	case utf8.RuneCountInString(value) > max:
+		return append(fields, domain.FieldError{Field: field, Message: fmt.Sprintf("must be at most %d characters", max)})
+	case strings.IndexFunc(value, unicode.IsControl) >= 0:
+		return append(fields, domain.FieldError{Field: field, Message: "must not contain control characters"})
+	}
+	return fields
+}
+
+// appendEmailErrors checks that every address is a bare RFC 5322 address,
+// listed once regardless of case.
+func appendEmailErrors(fields []domain.FieldError, emails []Email) []domain.FieldError {
+	if len(emails) > MaxContacts {
+		return append(fields, domain.FieldError{Field: "emails", Message: fmt.Sprintf("must have at most %d entries", MaxContacts)})
+	}
+	seen := map[string]bool{}
+	var primaries int
+	for i, e := range emails {
+		field := fmt.Sprintf("emails[%d]", i)
+		switch {
+		case e.Address == "":
+			fields = append(fields, domain.FieldError{Field: field + ".address", Message: "is required"})
+		case len(e.Address) > MaxEmailLength:
+			fields = append(fields, domain.FieldError{Field: field + ".address", Message: fmt.Sprintf("must be at most %d characters", MaxEmailLength)})
+		case !isEmailAddress(e.Address):
+			fields = append(fields, domain.FieldError{Field: field + ".address", Message: "must be an email address such as jane@example.com"})
+		case seen[strings.ToLower(e.Address)]:
+			fields = append(fields, domain.FieldError{Field: field + ".address", Message: "is listed more than once"})
+		}
+		seen[strings.ToLower(e.Address)] = true
+		if e.Type != ContactWork && e.Type != ContactHome && e.Type != ContactOther {
+			fields = append(fields, domain.FieldError{Field: field + ".type", Message: fmt.Sprintf("must be one of %s, %s or %s", ContactWork, ContactHome, ContactOther)})
+		}
+		if e.Primary {
+			primaries++
+		}
+	}
+	if primaries > 1 {
+		fields = append(fields, domain.FieldError{Field: "emails", Message: "must have at most one primary address"})
+	}
+	return fields
+}
+
+// appendPhoneErrors checks that every number is in E.164 format and listed
+// once.
+func appendPhoneErrors(fields []domain.FieldError, phones []Phone) []domain.FieldError {
+	if len(phones) > MaxContacts {
+		return append(fields, domain.FieldError{Field: "phones", Message: fmt.Sprintf("must have at most %d entries", MaxContacts)})
+	}
+	seen := map[string]bool{}
+	var primaries int
+	for i, p := range phones {
+		field := fmt.Sprintf("phones[%d]", i)
+		switch {
+		case p.Number == "":
+			fields = append(fields, domain.FieldError{Field: field + ".number", Message: "is required"})
+		case !e164.MatchString(p.Number):
+			fields = append(fields, domain.FieldError{Field: field + ".number", Message: "must be an E.164 number such as +14155550123"})
+		case seen[p.Number]:
+			fields = append(fields, domain.FieldError{Field: field + ".number", Message: "is listed more than once"})
+		}
+		seen[p.Number] = true
+		switch p.Type {
+		case ContactWork, ContactHome, ContactMobile, ContactOther:
+		default:
+			fields = append(fields, domain.FieldError{Field: field + ".type", Message: fmt.Sprintf("must be one of %s, %s, %s or %s", ContactWork, ContactHome, ContactMobile, ContactOther)})
+		}
+		if p.Primary {
+			primaries++
+		}
+	}
+	if primaries > 1 {
+		fields = append(fields, domain.FieldError{Field: "phones", Message: "must have at most one primary number"})
+	}
+	return fields
+}
+
+// isEmailAddress reports whether s is an RFC 5322 address on its own,
+// without a display name or angle brackets.
+func isEmailAddress(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Name == "" && addr.Address == s
+}
@@ -0,0 +1,157 @@
+package user
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pmaterer/peopler/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	u := User{
+		FirstName: "  Rene\u0301e\t",
+		LastName:  "\nMurakami ",
+	}
+	u.Normalize()
+
+	assert.Equal(t, "Renée", u.FirstName)
+	assert.Equal(t, "Murakami", u.LastName)
+
+	u = User{
+		Emails: []Email{{Address: " haruki@example.com "}, {Address: "h@example.org", Type: " Home"}},
+		Phones: []Phone{{Number: "+1 (415) 555-0123", Type: "mobile"}, {Number: "+81 3.1234.5678", Primary: true}},
+	}
+	u.Normalize()
+
+	assert.Equal(t, []Email{
+		{Address: "haruki@example.com", Type: ContactWork, Primary: true},
+		{Address: "h@example.org", Type: ContactHome},
+	}, u.Emails)
+	assert.Equal(t, []Phone{
+		{Number: "+14155550123", Type: ContactMobile},
+		{Number: "+81312345678", Type: ContactWork, Primary: true},
+	}, u.Phones)
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   User
+		fields []domain.FieldError
+	}{
+		{
+			name: "Valid user",
+			user: User{FirstName: "Haruki", LastName: "Murakami"},
+		},
+		{
+			name: "Missing names",
+			user: User{},
+			fields: []domain.FieldError{
+				{Field: "firstName", Message: "is required"},
+				{Field: "lastName", Message: "is required"},
+			},
+		},
+		{
+			name: "Name too long",
+			user: User{FirstName: strings.Repeat("é", MaxNameLength+1), LastName: "Murakami"},
+			fields: []domain.FieldError{
+				{Field: "firstName", Message: "must be at most 100 characters"},
+			},
+		},
+		{
+			name: "Longest name allowed",
+			user: User{FirstName: strings.Repeat("é", MaxNameLength), LastName: "Murakami"},
+		},
+		{
+			name: "External ID too long",
+			user: User{FirstName: "Haruki", LastName: "Murakami", ExternalID: strings.Repeat("x", MaxExternalIDLength+1)},
+			fields: []domain.FieldError{
+				{Field: "externalId", Message: "must be at most 64 characters"},
+			},
+		},
+		{
+			name: "Control characters",
+			user: User{FirstName: "Haruki", LastName: "Mura\x00kami"},
+			fields: []domain.FieldError{
+				{Field: "lastName", Message: "must not contain control characters"},
+			},
+		},
+		{
+			name: "Full profile",
+			user: User{
+				FirstName: "Haruki", LastName: "Murakami",
+				Emails: []Email{{Address: "haruki@example.com", Type: ContactWork, Primary: true}, {Address: "h.murakami+books@example.co.jp", Type: ContactOther}},
+				Phones: []Phone{{Number: "+14155550123", Type: ContactMobile, Primary: true}},
+				Title:  "Novelist", Department: "Fiction", EmployeeNumber: "E-1949", StartDate: "1979-06-01",
+			},
+		},
+		{
+			name: "Malformed profile",
+			user: User{
+				FirstName: "Haruki", LastName: "Murakami",
+				Emails: []Email{
+					{Address: "Haruki <haruki@example.com>", Type: ContactWork, Primary: true},
+					{Address: "haruki.example.com", Type: ContactMobile, Primary: true},
+					{Type: ContactHome},
+				},
+				Phones: []Phone{
+					{Number: "4155550123", Type: ContactWork, Primary: true},
+					{Number: "+14155550123", Type: ContactWork},
+					{Number: "+14155550123", Type: "fax"},
+				},
+				StartDate: "01/06/1979",
+			},
+			fields: []domain.FieldError{
+				{Field: "startDate", Message: "must be a date such as 2021-03-15"},
+				{Field: "emails[0].address", Message: "must be an email address such as jane@example.com"},
+				{Field: "emails[1].address", Message: "must be an email address such as jane@example.com"},
+				{Field: "emails[1].type", Message: "must be one of work, home or other"},
+				{Field: "emails[2].address", Message: "is required"},
+				{Field: "emails", Message: "must have at most one primary address"},
+				{Field: "phones[0].number", Message: "must be an E.164 number such as +14155550123"},
+				{Field: "phones[2].number", Message: "is listed more than once"},
+				{Field: "phones[2].type", Message: "must be one of work, home, mobile or other"},
+			},
+		},
+		{
+			name: "Same email in another case",
+			user: User{
+				FirstName: "Haruki", LastName: "Murakami",
+				Emails: []Email{{Address: "haruki@example.com", Type: ContactWork}, {Address: "Haruki@Example.com", Type: ContactHome}},
+			},
+			fields: []domain.FieldError{
+				{Field: "emails[1].address", Message: "is listed more than once"},
+			},
+		},
+		{
+			name: "Manager ID not an ID",
+			user: User{FirstName: "Haruki", LastName: "Murakami", ManagerID: new(int64)},
+			fields: []domain.FieldError{
+				{Field: "managerId", Message: "must be the ID of a user"},
+			},
+		},
+		{
+			name: "Invalid UTF-8",
+			user: User{FirstName: "Haruki\xff", LastName: "Murakami"},
+			fields: []domain.FieldError{
+				{Field: "firstName", Message: "must be valid UTF-8"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.user.Validate()
+			if tt.fields == nil {
+				assert.Nil(t, err)
+				return
+			}
+			assert.True(t, errors.Is(err, domain.ErrValidation))
+			var validationErr *domain.ValidationError
+			assert.True(t, errors.As(err, &validationErr))
+			assert.Equal(t, tt.fields, validationErr.Fields)
+		})
+	}
+}